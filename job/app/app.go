@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -10,11 +11,29 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	appConfig "github.com/yigithankarabulut/asyncs3todbloader/job/config"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/migration"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/outbox"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/service"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/sink"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/deadletterstorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/outboxstorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqlobjectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqlproductstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/throttle"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/filter"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/locals3"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/mongo"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/postgres"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/sqlite"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/transform"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"io"
 	"log/slog"
 	"os"
 	"sync"
@@ -22,17 +41,28 @@ import (
 )
 
 const (
-	LineChannelSize    = 50
-	ProductChannelSize = 50
-	LineHandlerCount   = 50
-	DBWriteWorkerCount = 50
+	RelayDrainTimeout = 30 * time.Second
 )
 
 type app struct {
+	// ctx is cancelled by the shutdown signal. The objects in flight are interrupted and the objects not started are skipped.
+	ctx      context.Context
 	config   *appConfig.Config
 	logLevel slog.Level
 	logger   *slog.Logger
-	doneChan chan struct{}
+	relay    *outbox.Relay
+	closers  []io.Closer
+	// deadLetterStorage stores the products that could not be written. Only the mongo driver has a dead-letter collection.
+	deadLetterStorage deadletterstorage.DeadLetterStorer
+	// limiter limits the product writes of all S3 objects together. It is nil if the writes are not throttled.
+	limiter *throttle.Limiter
+	// migrator manages the indexes and validators of the mongo collections. It is nil for the sql drivers.
+	migrator *migration.Migrator
+	// transformers are the transformer chains of the S3 objects in the order of the configuration.
+	transformers []transform.Chain
+	// filters are the compiled filter expressions of the S3 objects in the order of the configuration.
+	// The filter of an S3 object without a filter expression is nil.
+	filters []*filter.Filter
 }
 
 type Option func(*app)
@@ -66,22 +96,25 @@ func WithConfig(cfg *appConfig.Config) Option {
 	}
 }
 
-func WithDoneChan(doneChan chan struct{}) Option {
+// WithContext sets the root context of the app. Cancelling it shuts the app down gracefully.
+func WithContext(ctx context.Context) Option {
 	return func(s *app) {
-		s.doneChan = doneChan
+		s.ctx = ctx
 	}
 }
 
-// New creates a new app instance. It initializes the storages, logger, connects to MongoDB and AWS, and runs the app.
-func New(opts ...Option) error {
+// New creates a new app instance. It initializes the storages, logger, connects to the database and AWS, and runs the app.
+// It returns a nil result if the app cannot start, otherwise the result of the run and its error.
+func New(opts ...Option) (*Result, error) {
 	app := &app{
+		ctx:      context.Background(),
 		logLevel: slog.LevelInfo,
 	}
 	for _, opt := range opts {
 		opt(app)
 	}
 	if app.config == nil {
-		return errors.New("config is required")
+		return nil, errors.New("config is required")
 	}
 	// set default logger if not provided
 	if app.logger == nil {
@@ -92,68 +125,274 @@ func New(opts ...Option) error {
 	slog.SetDefault(app.logger)
 	app.logger.Info("Starting app...")
 
-	// Connect to MongoDB
-	db, err := mongo.ConnectMongo(app.config.Database)
+	// Create the transformers and filters before connecting, so a misconfigured object fails fast.
+	if err := app.newTransformers(); err != nil {
+		return nil, err
+	}
+	if err := app.newFilters(); err != nil {
+		return nil, err
+	}
+
+	// Connect to the database
+	productStorage, objectInfoStorage, err := app.newStorages()
 	if err != nil {
-		return fmt.Errorf("error connecting to mongo: %w", err)
+		return nil, err
 	}
 
 	// Connect to AWS
+	s3Client, err := app.newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	// A dry run reads from the database but only simulates the writes, so it does not change the schema either.
+	if app.config.DryRun {
+		app.logger.Info("Dry run: nothing is written to the database")
+		return app.Run(app.ctx, s3Client, productstorage.NewDryRun(productStorage), objectInfoStorage)
+	}
+
+	app.limiter = app.newLimiter()
+
+	// Create indexes. The schema of the mongo collections is managed by the migrations.
+	if app.migrator != nil {
+		if _, err := app.migrator.Up(app.ctx); err != nil {
+			return nil, fmt.Errorf("error applying migrations: %w", err)
+		}
+	} else {
+		if err := productStorage.CreateIndex(context.Background()); err != nil {
+			return nil, fmt.Errorf("error creating index: %w", err)
+		}
+		if err := objectInfoStorage.CreateIndex(context.Background()); err != nil {
+			return nil, fmt.Errorf("error creating index: %w", err)
+		}
+	}
+	return app.Run(app.ctx, s3Client, productStorage, objectInfoStorage)
+}
+
+// newFilters compiles the filter expression of each S3 object.
+func (a *app) newFilters() error {
+	a.filters = make([]*filter.Filter, len(a.config.Aws.S3))
+	for i, s3Object := range a.config.Aws.S3 {
+		if s3Object.Filter == "" {
+			continue
+		}
+		f, err := filter.Compile(s3Object.Filter)
+		if err != nil {
+			return fmt.Errorf("error compiling filter of %s: %w", s3Object.ObjectKey, err)
+		}
+		a.filters[i] = f
+	}
+	return nil
+}
+
+// newTransformers creates the transformer chain of each S3 object.
+func (a *app) newTransformers() error {
+	a.transformers = make([]transform.Chain, len(a.config.Aws.S3))
+	for i, s3Object := range a.config.Aws.S3 {
+		chain, err := transform.NewChain(s3Object.Transformers)
+		if err != nil {
+			return fmt.Errorf("error creating transformers of %s: %w", s3Object.ObjectKey, err)
+		}
+		a.transformers[i] = chain
+	}
+	return nil
+}
+
+// newLimiter returns the limiter of the throttle config. It returns nil if neither the rate nor the writes in flight are limited.
+func (a *app) newLimiter() *throttle.Limiter {
+	cfg := a.config.Throttle
+	if cfg.Rate <= 0 && cfg.MaxInFlight <= 0 {
+		return nil
+	}
+	opts := []throttle.Option{
+		throttle.WithRate(cfg.Rate, cfg.Burst),
+		throttle.WithMaxInFlight(cfg.MaxInFlight),
+	}
+	if cfg.Adaptive {
+		opts = append(opts, throttle.WithAdaptive(cfg.MinInFlight, cfg.TargetLatency))
+	}
+	return throttle.New(opts...)
+}
+
+// newS3Client returns the S3 client of the aws config. If the local directory is set, the objects are read from it.
+func (a *app) newS3Client() (service.S3Client, error) {
+	if a.config.Aws.LocalDir != "" {
+		return locals3.New(a.config.Aws.LocalDir), nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	awsConfig, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(app.config.Aws.Region),
+		config.WithRegion(a.config.Aws.Region),
 		config.WithCredentialsProvider(credentials.StaticCredentialsProvider{
 			Value: aws.Credentials{
-				AccessKeyID:     app.config.Aws.AccessKey,
-				SecretAccessKey: app.config.Aws.SecretKey,
+				AccessKeyID:     a.config.Aws.AccessKey,
+				SecretAccessKey: a.config.Aws.SecretKey,
 			}}),
 	)
 	if err != nil {
-		return fmt.Errorf("error loading aws config: %w", err)
+		return nil, fmt.Errorf("error loading aws config: %w", err)
+	}
+	return s3.NewFromConfig(awsConfig), nil
+}
+
+// newStorages connects to the database selected by the driver of the database config and returns its storages.
+func (a *app) newStorages() (productstorage.ProductStorer, objectinfostorage.ObjectInfoStorer, error) {
+	switch a.config.Database.Driver {
+	case appConfig.DriverSQLite:
+		db, err := sqlite.ConnectSQLite(a.config.Database)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error connecting to sqlite: %w", err)
+		}
+		productStorage, objectInfoStorage := a.newSQLStorages(db, sqldialect.SQLite)
+		return productStorage, objectInfoStorage, nil
+	case appConfig.DriverPostgres:
+		db, err := postgres.ConnectPostgres(a.config.Database)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error connecting to postgres: %w", err)
+		}
+		productStorage, objectInfoStorage := a.newSQLStorages(db, sqldialect.Postgres)
+		return productStorage, objectInfoStorage, nil
 	}
-	s3Client := s3.NewFromConfig(awsConfig)
 
-	// Initialize storage instances
-	productStorage := productstorage.New(
-		productstorage.WithProductCollection(app.config.Database.ProductCollection),
+	db, err := mongo.ConnectMongo(a.config.Database)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to mongo: %w", err)
+	}
+	productOpts := []productstorage.Option{
+		productstorage.WithProductCollection(a.config.Database.ProductCollection),
+		productstorage.WithHistoryCollection(a.config.Database.HistoryCollection),
 		productstorage.WithDB(db),
-	)
+	}
+	// a dry run does not publish the outbox events or write dead letters.
+	if !a.config.DryRun {
+		if a.config.Outbox.Enabled() {
+			if err := a.newRelay(db); err != nil {
+				return nil, nil, err
+			}
+			productOpts = append(productOpts, productstorage.WithOutboxCollection(a.config.Outbox.Collection))
+		}
+		deadLetterStorage := deadletterstorage.New(
+			deadletterstorage.WithDeadLetterCollection(a.config.Database.DeadLetterCollection),
+			deadletterstorage.WithDB(db),
+		)
+		if err := deadLetterStorage.CreateIndex(context.Background()); err != nil {
+			return nil, nil, fmt.Errorf("error creating dead-letter index: %w", err)
+		}
+		a.deadLetterStorage = deadLetterStorage
+		a.migrator = newMigrator(db, a.config.Database, a.logger)
+	}
+	productStorage := productstorage.New(productOpts...)
 	objectInfoStorage := objectinfostorage.New(
-		objectinfostorage.WithObjectCollection(app.config.Database.ObjectInfoCollection),
+		objectinfostorage.WithObjectCollection(a.config.Database.ObjectInfoCollection),
 		objectinfostorage.WithDB(db),
 	)
+	return productStorage, objectInfoStorage, nil
+}
 
-	// Create indexes
-	if err := productStorage.CreateIndex(context.Background()); err != nil {
+// newRelay creates the relay that publishes the events of the outbox collection to the publisher of the outbox config.
+func (a *app) newRelay(db *mongoDriver.Database) error {
+	outboxStorage := outboxstorage.New(
+		outboxstorage.WithOutboxCollection(a.config.Outbox.Collection),
+		outboxstorage.WithDB(db),
+	)
+	if err := outboxStorage.CreateIndex(context.Background()); err != nil {
 		return fmt.Errorf("error creating index: %w", err)
 	}
-	if err := objectInfoStorage.CreateIndex(context.Background()); err != nil {
-		return fmt.Errorf("error creating index: %w", err)
+	publisher, err := outbox.NewPublisher(a.config.Outbox)
+	if err != nil {
+		return fmt.Errorf("error creating outbox publisher: %w", err)
 	}
-	return app.Run(s3Client, productStorage, objectInfoStorage)
+	a.closers = append(a.closers, publisher)
+	a.relay = outbox.NewRelay(
+		outbox.WithStorage(outboxStorage),
+		outbox.WithPublisher(publisher),
+		outbox.WithLogger(a.logger),
+		outbox.WithPollInterval(a.config.Outbox.PollInterval),
+		outbox.WithBatchSize(a.config.Outbox.BatchSize),
+	)
+	return nil
 }
 
-// Run starts the app. It processes each S3 object concurrently.
+// newSQLStorages returns the SQL storages of the database with the tables named after the collections of the database config.
+func (a *app) newSQLStorages(db *sql.DB, dialect sqldialect.Dialect) (productstorage.ProductStorer, objectinfostorage.ObjectInfoStorer) {
+	productStorage := sqlproductstorage.New(
+		sqlproductstorage.WithProductTable(a.config.Database.ProductCollection),
+		sqlproductstorage.WithHistoryTable(a.config.Database.HistoryCollection),
+		sqlproductstorage.WithDB(db),
+		sqlproductstorage.WithDialect(dialect),
+	)
+	objectInfoStorage := sqlobjectinfostorage.New(
+		sqlobjectinfostorage.WithObjectTable(a.config.Database.ObjectInfoCollection),
+		sqlobjectinfostorage.WithDB(db),
+		sqlobjectinfostorage.WithDialect(dialect),
+	)
+	return productStorage, objectInfoStorage
+}
+
+// drainRelay publishes the events written after the last poll of the relay.
+func (a *app) drainRelay() {
+	if a.relay == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RelayDrainTimeout)
+	defer cancel()
+	if err := a.relay.Drain(ctx); err != nil {
+		a.logger.Error(fmt.Sprintf("failed to drain outbox: %v", err))
+	}
+}
+
+// Run starts the app. It processes up to ObjectsInFlight S3 objects concurrently.
 // It creates a service instance for each S3 object and runs it.
-// Each service instance will have its own out, line, and product channels.
-// Each S3 object will have its own line handler and db writer workers.
-// It waits for all S3 objects to be processed and sends a signal to the done channel.
-func (a *app) Run(s3Client *s3.Client, productStorage productstorage.ProductStorer, objectInfoStorage objectinfostorage.ObjectInfoStorer) error {
+// Each service instance will have its own out, line, and product channels sized by the pipeline of the S3 object.
+// The S3 objects share the parser and writer pools, and each can use its own share of the workers at once.
+// It waits for all S3 objects to be processed and returns the result of each S3 object, with the errors of the
+// failed and interrupted objects joined as the error of the run.
+// If ctx is cancelled, the S3 objects in flight are interrupted and the others are not started.
+func (a *app) Run(ctx context.Context, s3Client service.S3Client, productStorage productstorage.ProductStorer, objectInfoStorage objectinfostorage.ObjectInfoStorer) (*Result, error) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 	start := time.Now()
 
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		if a.relay != nil {
+			a.relay.Run(relayCtx)
+		}
+	}()
+
+	workers := a.config.Workers
+	parserPool := pool.New(workers.Parsers)
+	writerPool := pool.New(workers.Writers)
+	inFlight := make(chan struct{}, max(workers.ObjectsInFlight, 1))
+
+	// each goroutine sets the result of its own S3 object.
+	result := &Result{Objects: make([]ObjectResult, len(a.config.Aws.S3))}
 	wg := sync.WaitGroup{}
-	for _, s3Object := range a.config.Aws.S3 {
+	for i, s3Object := range a.config.Aws.S3 {
 		wg.Add(1)
-		go func(s3Object appConfig.S3) {
+		go func(object *ObjectResult, s3Object appConfig.S3, chain transform.Chain, f *filter.Filter) {
 			defer wg.Done()
+			object.BucketName, object.ObjectKey = s3Object.BucketName, s3Object.ObjectKey
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				a.logger.Info(fmt.Sprintf("Skipped %s, shutting down", s3Object.ObjectKey))
+				object.Status, object.Err = model.ObjectStatusInterrupted, ctx.Err()
+				return
+			}
+			defer func() { <-inFlight }()
 			outChan := make(chan *s3.GetObjectOutput, 1)
-			lineChan := make(chan string, LineChannelSize)
-			productChan := make(chan model.Product, ProductChannelSize)
+			pipeline := s3Object.Pipeline
+			parsers, writers := pipeline.ObjectWorkers(workers)
+			a.logger.Info(fmt.Sprintf("Pipeline of %s: line channel %d, product channel %d, parser workers %d/%d, writer workers %d/%d, batch size %d, batch bytes %d, flush interval %s, flush deadline %s",
+				s3Object.ObjectKey, pipeline.LineChannelSize, pipeline.ProductChannelSize, parsers, workers.Parsers, writers, workers.Writers,
+				pipeline.BatchSize, pipeline.BatchBytes, pipeline.FlushInterval, pipeline.FlushDeadline))
+			lineChan := make(chan string, pipeline.LineChannelSize)
+			productChan := make(chan model.Product, pipeline.ProductChannelSize)
 
-			service := service.New(
+			opts := []service.Option{
 				service.WithS3Client(s3Client),
 				service.WithS3Data(s3Object),
 				service.WithProductStorage(productStorage),
@@ -162,33 +401,87 @@ func (a *app) Run(s3Client *s3.Client, productStorage productstorage.ProductStor
 				service.WithS3OutChan(outChan),
 				service.WithProductChannel(productChan),
 				service.WithLineChannel(lineChan),
-				service.WithLineHandlerWorkerCount(LineHandlerCount),
-				service.WithDBWriteWorkerCount(DBWriteWorkerCount),
-			)
-
-			if err := service.Run(); err != nil {
-				var ce *customerror.Error
-				// check if error is custom error.
-				// if error must be logged, log it.
-				if errors.As(err, &ce) {
-					message := ce.Message
-					if ce.Data != nil {
-						data, ok := ce.Data.(string)
-						if ok {
-							message += ", " + data
-						}
-						if ce.Loggable {
-							a.logger.Error(message)
-						}
-					}
+				service.WithParserPool(parserPool),
+				service.WithWriterPool(writerPool),
+				service.WithLineHandlerWorkerCount(parsers),
+				service.WithDBWriteWorkerCount(writers),
+				service.WithBatchSize(pipeline.BatchSize),
+				service.WithBatchBytes(pipeline.BatchBytes),
+				service.WithFlushInterval(pipeline.FlushInterval),
+				service.WithFlushDeadline(pipeline.FlushDeadline),
+			}
+			if a.deadLetterStorage != nil {
+				opts = append(opts, service.WithDeadLetterStorage(a.deadLetterStorage))
+			}
+			if a.limiter != nil {
+				opts = append(opts, service.WithLimiter(a.limiter))
+			}
+			if len(chain) > 0 {
+				opts = append(opts, service.WithTransformer(chain))
+			}
+			if f != nil {
+				opts = append(opts, service.WithFilter(f))
+			}
+			if a.config.DryRun {
+				// the sinks write files, so a dry run does not open them.
+				opts = append(opts, service.WithDryRun(true))
+				s3Object.Sinks = nil
+			}
+			for _, sinkConfig := range s3Object.Sinks {
+				s, err := sink.New(sinkConfig)
+				if err != nil {
+					a.logger.Error(fmt.Sprintf("failed to open sink %s of %s: %v", sinkConfig.Name, s3Object.ObjectKey, err))
+					object.Status, object.Err = model.ObjectStatusFailed, fmt.Errorf("open sink %s: %w", sinkConfig.Name, err)
+					return
 				}
-				return
+				defer s.Close()
+				opts = append(opts, service.WithSink(sinkConfig, s))
 			}
-		}(s3Object)
+			service := service.New(opts...)
+
+			err := service.Run(ctx)
+			object.Report = service.Report()
+			object.Status, object.Err = a.objectStatus(ctx, s3Object, err)
+		}(&result.Objects[i], s3Object, a.transformers[i], a.filters[i])
 	}
 	wg.Wait()
-	a.doneChan <- struct{}{}
+	parserPool.Close()
+	writerPool.Close()
+	stopRelay()
+	<-relayDone
+	a.drainRelay()
+	for _, closer := range a.closers {
+		if err := closer.Close(); err != nil {
+			a.logger.Error(err.Error())
+		}
+	}
 	elapsed := time.Since(start)
 	a.logger.Info(fmt.Sprintf("Elapsed Time: %s", elapsed))
-	return nil
+	a.logger.Info(fmt.Sprintf("%d of %d objects failed or were interrupted", result.Failed(), len(result.Objects)),
+		slog.Int("exit_code", result.ExitCode()))
+	return result, result.Err()
+}
+
+// objectStatus returns the status and the error of an S3 object from the error of its service, and logs the error.
+// Every error is logged with its data, whatever its type. An object that is already loaded is skipped, not failed.
+func (a *app) objectStatus(ctx context.Context, s3Object appConfig.S3, err error) (string, error) {
+	if err == nil {
+		return model.ObjectStatusDone, nil
+	}
+	var ce *customerror.Error
+	isCustom := errors.As(err, &ce)
+	if isCustom && ce.Message == constant.ErrObjectLoaded {
+		a.logger.Info(fmt.Sprintf("Skipped %s, it is already loaded", s3Object.ObjectKey))
+		return ObjectStatusSkipped, nil
+	}
+	status := model.ObjectStatusFailed
+	if ctx.Err() != nil {
+		status = model.ObjectStatusInterrupted
+	}
+	message := err.Error()
+	if isCustom && ce.Data != nil {
+		message += fmt.Sprintf(", %v", ce.Data)
+	}
+	a.logger.Error(fmt.Sprintf("%s %s: %s", status, s3Object.ObjectKey, message))
+	return status, err
 }
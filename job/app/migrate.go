@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	appConfig "github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/migration"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/mongo"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+)
+
+// Migrate commands.
+const (
+	MigrateUp     = "up"
+	MigrateStatus = "status"
+)
+
+// newMigrator returns the migrator of the collections of the database config.
+func newMigrator(db *mongoDriver.Database, dbConfig appConfig.Database, logger *slog.Logger) *migration.Migrator {
+	return migration.New(
+		migration.WithDB(db),
+		migration.WithCollection(dbConfig.MigrationCollection),
+		migration.WithMigrations(migration.Default(dbConfig)),
+		migration.WithLogger(logger),
+	)
+}
+
+// Migrate runs the migrate command on the mongo database of the database config and writes its output to out.
+// The up command applies the pending migrations and the status command lists the applied and pending migrations.
+func Migrate(dbConfig appConfig.Database, command string, out io.Writer) error {
+	if dbConfig.Driver != appConfig.DriverMongo {
+		return errors.New("migrations require the mongo DB_DRIVER, the sql tables are created on startup")
+	}
+	db, err := mongo.ConnectMongo(dbConfig)
+	if err != nil {
+		return fmt.Errorf("error connecting to mongo: %w", err)
+	}
+	defer db.Client().Disconnect(context.Background())
+	migrator := newMigrator(db, dbConfig, slog.Default())
+
+	switch command {
+	case MigrateUp:
+		versions, err := migrator.Up(context.Background())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migrations %v\n", len(versions), versions)
+		return nil
+	case MigrateStatus:
+		statuses, err := migrator.Status(context.Background())
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied() {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Description, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New("migrate command must be one of up, status")
+	}
+}
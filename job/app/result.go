@@ -0,0 +1,84 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+)
+
+// Exit codes of the job. A job that cannot start exits with 1.
+const (
+	// ExitSuccess is the exit code of a run whose objects are all loaded or skipped as already loaded.
+	ExitSuccess = 0
+	// ExitPartialFailure is the exit code of a run where some objects failed or were interrupted and the others succeeded.
+	ExitPartialFailure = 2
+	// ExitFailure is the exit code of a run where every object failed or was interrupted.
+	ExitFailure = 3
+)
+
+// ObjectStatusSkipped is the status of an object that is already loaded by an earlier run.
+// It is only a status of the run result, it is not stored in the object info record.
+const ObjectStatusSkipped = "skipped"
+
+// ObjectResult is the result of an S3 object of a run.
+type ObjectResult struct {
+	BucketName string
+	ObjectKey  string
+	// Status is the status of the object info record, done, failed or interrupted, or skipped.
+	// An object that is not started because of the shutdown is interrupted.
+	Status string
+	// Report is the report of the object. It is empty if the object is not started.
+	Report model.Report
+	// Err is the error of a failed or interrupted object.
+	Err error
+}
+
+// Succeeded reports whether the object is loaded or skipped as already loaded.
+func (r ObjectResult) Succeeded() bool {
+	return r.Status == model.ObjectStatusDone || r.Status == ObjectStatusSkipped
+}
+
+// Result is the result of a run, with an object result for each S3 object in the order of the configuration.
+type Result struct {
+	Objects []ObjectResult
+}
+
+// Failed returns the number of objects that failed or were interrupted.
+func (r *Result) Failed() int {
+	failed := 0
+	for _, object := range r.Objects {
+		if !object.Succeeded() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Err returns the errors of the failed and interrupted objects joined with errors.Join, or nil if every object succeeded.
+// Each error is prefixed with the bucket and the key of its object.
+func (r *Result) Err() error {
+	var errs []error
+	for _, object := range r.Objects {
+		if object.Succeeded() {
+			continue
+		}
+		err := object.Err
+		if err == nil {
+			err = errors.New(object.Status)
+		}
+		errs = append(errs, fmt.Errorf("%s/%s: %w", object.BucketName, object.ObjectKey, err))
+	}
+	return errors.Join(errs...)
+}
+
+// ExitCode returns the exit code of the run.
+func (r *Result) ExitCode() int {
+	switch failed := r.Failed(); {
+	case failed == 0:
+		return ExitSuccess
+	case failed < len(r.Objects):
+		return ExitPartialFailure
+	default:
+		return ExitFailure
+	}
+}
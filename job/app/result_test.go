@@ -0,0 +1,52 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/app"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"strings"
+	"testing"
+)
+
+func TestResult_ExitCode(t *testing.T) {
+	errWrite := errors.New("write error")
+	done := app.ObjectResult{BucketName: "bucket", ObjectKey: "done", Status: model.ObjectStatusDone}
+	skipped := app.ObjectResult{BucketName: "bucket", ObjectKey: "skipped", Status: app.ObjectStatusSkipped}
+	failed := app.ObjectResult{BucketName: "bucket", ObjectKey: "failed", Status: model.ObjectStatusFailed, Err: errWrite}
+	interrupted := app.ObjectResult{BucketName: "bucket", ObjectKey: "interrupted", Status: model.ObjectStatusInterrupted, Err: context.Canceled}
+	tests := []struct {
+		name     string
+		objects  []app.ObjectResult
+		wantCode int
+		wantErrs []error
+	}{
+		{name: "no objects", wantCode: app.ExitSuccess},
+		{name: "done and skipped objects", objects: []app.ObjectResult{done, skipped}, wantCode: app.ExitSuccess},
+		{name: "some objects failed", objects: []app.ObjectResult{done, failed}, wantCode: app.ExitPartialFailure, wantErrs: []error{errWrite}},
+		{name: "every object failed or interrupted", objects: []app.ObjectResult{failed, interrupted}, wantCode: app.ExitFailure, wantErrs: []error{errWrite, context.Canceled}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &app.Result{Objects: tt.objects}
+			if code := result.ExitCode(); code != tt.wantCode {
+				t.Errorf("ExitCode() = %d, want %d", code, tt.wantCode)
+			}
+			if result.Failed() != len(tt.wantErrs) {
+				t.Errorf("Failed() = %d, want %d", result.Failed(), len(tt.wantErrs))
+			}
+			err := result.Err()
+			if (err != nil) != (len(tt.wantErrs) > 0) {
+				t.Fatalf("Err() = %v, want %d errors", err, len(tt.wantErrs))
+			}
+			for _, want := range tt.wantErrs {
+				if !errors.Is(err, want) {
+					t.Errorf("Err() = %v, want it to wrap %v", err, want)
+				}
+			}
+			if err != nil && !strings.Contains(err.Error(), "bucket/failed: ") {
+				t.Errorf("Err() = %v, want the errors prefixed with their objects", err)
+			}
+		})
+	}
+}
@@ -1,41 +1,76 @@
 package main
 
 import (
+	"context"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/app"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
 	"log"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 )
 
 func main() {
+	// run the migrate command if it is given. e.g. job migrate up, job migrate status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
+	// parse the flags that override the job config file and the environment variables.
+	flags, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatalf("failed to parse flags: %v", err)
+	}
+
 	// load configuration.
-	cfg, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(flags)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
-	doneChan := make(chan struct{})
-	shutdownChan := make(chan os.Signal, 2)
-	signal.Notify(shutdownChan, os.Interrupt, os.Kill)
+	// the shutdown signal cancels the root context of the app. A second signal kills the job.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	resultChan := make(chan *app.Result, 1)
 	go func() {
-		if err := app.New(
+		// the errors of the objects are logged by the app, so only a failure to start is fatal here.
+		result, err := app.New(
+			app.WithContext(ctx),
 			app.WithConfig(cfg),
 			app.WithLogLevel("INFO"),
-			app.WithDoneChan(doneChan),
-		); err != nil {
+		)
+		if result == nil {
 			log.Fatalf("failed to create app: %v", err)
 		}
+		resultChan <- result
 	}()
-	// graceful shutdown. listen for shutdown signal.
+	// graceful shutdown. the objects in flight write their batches within the flush deadline and are recorded as interrupted.
+	var result *app.Result
 	select {
-	case <-shutdownChan:
-		log.Println("Shutdown signal received. Shutting down...")
-		log.Println("Waiting 5 seconds for goroutines to complete the job...")
-		time.Sleep(5 * time.Second)
-	case <-doneChan:
+	case <-ctx.Done():
+		stop()
+		log.Println("Shutdown signal received. Waiting for the batches in flight to be written...")
+		result = <-resultChan
+	case result = <-resultChan:
 		log.Println("All goroutines completed the job.")
 	}
-	log.Println("Exiting...")
+	stop()
+	// the exit code tells the scheduler whether all, some or none of the objects succeeded.
+	log.Printf("Exiting with code %d...", result.ExitCode())
+	os.Exit(result.ExitCode())
+}
+
+// migrate runs the migrate command with the database configuration only, so it does not need the S3 objects.
+func migrate(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: job migrate <up|status>")
+	}
+	var cfg config.Config
+	if err := cfg.LoadDatabase(); err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if err := app.Migrate(cfg.Database, args[0], os.Stdout); err != nil {
+		log.Fatalf("failed to migrate: %v", err)
+	}
 }
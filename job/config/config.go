@@ -4,14 +4,97 @@ import (
 	"errors"
 	"github.com/spf13/viper"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Database Database `mapstructure:"database"`
 	Aws      Aws      `mapstructure:"aws"`
+	Outbox   Outbox   `mapstructure:"outbox"`
+	Throttle Throttle `mapstructure:"throttle"`
+	Workers  Workers  `mapstructure:"workers"`
+	Pipeline Pipeline `mapstructure:"pipeline"`
+	// DryRun reads, parses and transforms the S3 objects and reports what would be written,
+	// without writing the object info records, the products or the outbox events.
+	DryRun bool `mapstructure:"dry_run"`
 }
 
+// Default values of the adaptive write throttle.
+const (
+	DefaultThrottleMinInFlight   = 1
+	DefaultThrottleTargetLatency = 500 * time.Millisecond
+)
+
+// Throttle limits the product writes of all S3 objects together, so the load does not overwhelm the database.
+// A zero Rate or MaxInFlight is not limited.
+type Throttle struct {
+	// Rate is the number of products written per second and Burst the number of products written at once.
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+	// MaxInFlight is the maximum number of batch writes in flight.
+	MaxInFlight int `mapstructure:"max_in_flight"`
+	// Adaptive lowers the in-flight limit down to MinInFlight while the write latency exceeds TargetLatency
+	// or the writes fail, and raises it back up to MaxInFlight when the writes are fast again.
+	Adaptive      bool          `mapstructure:"adaptive"`
+	MinInFlight   int           `mapstructure:"min_in_flight"`
+	TargetLatency time.Duration `mapstructure:"target_latency"`
+}
+
+// Outbox publishers. The outbox is disabled if the publisher is empty.
+const (
+	PublisherStdout = "stdout"
+	PublisherFile   = "file"
+	PublisherNATS   = "nats"
+	PublisherKafka  = "kafka"
+)
+
+// Default values of the outbox.
+const (
+	DefaultOutboxCollection   = "outbox"
+	DefaultOutboxSubject      = "products"
+	DefaultOutboxPollInterval = time.Second
+	DefaultOutboxBatchSize    = 100
+)
+
+// Outbox writes a change event for each product written by the job in the same transaction as the write.
+// The relay publishes the events to the publisher in the order they are written.
+type Outbox struct {
+	Publisher  string `mapstructure:"publisher"`
+	Collection string `mapstructure:"collection"`
+	// FilePath is the file that the file publisher appends the events to.
+	FilePath string `mapstructure:"file_path"`
+	// NATSURL is the server URL of the nats publisher. The events are published to the JetStream stream of NATSSubject.
+	NATSURL     string `mapstructure:"nats_url"`
+	NATSSubject string `mapstructure:"nats_subject"`
+	// KafkaBrokers are the broker addresses of the kafka publisher. The events are keyed by product ID.
+	KafkaBrokers []string      `mapstructure:"kafka_brokers"`
+	KafkaTopic   string        `mapstructure:"kafka_topic"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+}
+
+// Enabled reports whether the outbox has a publisher.
+func (o Outbox) Enabled() bool {
+	return o.Publisher != ""
+}
+
+// Database drivers. DriverMongo is the default driver.
+const (
+	DriverMongo    = "mongo"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
 type Database struct {
+	Driver  string `mapstructure:"driver"`
+	SSLMode string `mapstructure:"sslmode"`
+	// URI is the connection string of the mongo or postgres driver. If it is set, the host, port, user and password are not used.
+	URI string `mapstructure:"uri"`
+	// Path is the database file of the sqlite driver.
+	Path                 string `mapstructure:"path"`
 	Name                 string `mapstructure:"name"`
 	Host                 string `mapstructure:"host"`
 	Pass                 string `mapstructure:"pass"`
@@ -19,23 +102,277 @@ type Database struct {
 	Port                 string `mapstructure:"port"`
 	ProductCollection    string `mapstructure:"products"`
 	ObjectInfoCollection string `mapstructure:"objectinfo"`
+	HistoryCollection    string `mapstructure:"product_history"`
+	// DeadLetterCollection stores the products that could not be written.
+	DeadLetterCollection string `mapstructure:"dead_letters"`
+	// MigrationCollection records the applied migrations of the mongo driver.
+	MigrationCollection string       `mapstructure:"migrations"`
+	Mongo               MongoOptions `mapstructure:"mongo"`
+}
+
+// MongoOptions are the client options of the mongo driver. They override the options of the connection string.
+// A zero value keeps the option of the connection string or the default of the driver.
+type MongoOptions struct {
+	AuthSource string `mapstructure:"auth_source"`
+	ReplicaSet string `mapstructure:"replica_set"`
+	TLS        bool   `mapstructure:"tls"`
+	// TLSCAFile is the PEM file of the certificate authorities that the server certificate is verified with.
+	TLSCAFile string `mapstructure:"tls_ca_file"`
+	// TLSCertificateKeyFile is the PEM file of the client certificate and its key.
+	// Without a user, the client authenticates with the certificate (MONGODB-X509).
+	TLSCertificateKeyFile  string        `mapstructure:"tls_certificate_key_file"`
+	MaxPoolSize            uint64        `mapstructure:"max_pool_size"`
+	MinPoolSize            uint64        `mapstructure:"min_pool_size"`
+	ConnectTimeout         time.Duration `mapstructure:"connect_timeout"`
+	ServerSelectionTimeout time.Duration `mapstructure:"server_selection_timeout"`
+	// Timeout is the timeout of each operation.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// WriteConcern is majority, a number of members or a tag set name.
+	WriteConcern string `mapstructure:"write_concern"`
+	// ReadPreference is one of primary, primaryPreferred, secondary, secondaryPreferred, nearest.
+	ReadPreference string `mapstructure:"read_preference"`
 }
 
 type Aws struct {
 	Region    string `mapstructure:"region"`
 	AccessKey string `mapstructure:"access_key"`
 	SecretKey string `mapstructure:"secret_key"`
-	S3        []S3   `mapstructure:"S3"`
+	// LocalDir serves the buckets from the subdirectories of a local directory instead of S3.
+	LocalDir string `mapstructure:"local_dir"`
+	S3       []S3   `mapstructure:"S3"`
 }
 
+// Write modes of an S3 object. WriteModeInsert is the default mode.
+const (
+	WriteModeInsert  = "insert"
+	WriteModeReplace = "replace"
+	WriteModeMerge   = "merge"
+)
+
 type S3 struct {
 	BucketName string `mapstructure:"BucketName"`
 	ObjectKey  string `mapstructure:"ObjectKey"`
+	WriteMode  string `mapstructure:"WriteMode"`
+	Format     string `mapstructure:"Format"`
+	// ChangeDetection compares the hash of each product with the stored one and writes only the changed products.
+	// The previous version of a changed product is appended to the history collection.
+	ChangeDetection bool `mapstructure:"ChangeDetection"`
+	// Source identifies the products written by the S3 object. It defaults to BucketName/ObjectKey.
+	Source   string   `mapstructure:"Source"`
+	Snapshot Snapshot `mapstructure:"Snapshot"`
+	Atomic   Atomic   `mapstructure:"Atomic"`
+	// Sinks are the secondary destinations of the products next to the database.
+	Sinks []Sink `mapstructure:"Sinks"`
+	// WriteRetry is the retry policy of the product writes that fail with a retryable error.
+	WriteRetry WriteRetry `mapstructure:"WriteRetry"`
+	// Pipeline overrides the global pipeline sizing for the S3 object.
+	Pipeline Pipeline `mapstructure:"Pipeline"`
+	// Filter is an expression over the product fields that a product must match to be loaded,
+	// e.g. `category == "electronics" && price > 0`. The delete events of CDC formatted objects are not filtered.
+	Filter string `mapstructure:"Filter"`
+	// Transformers are applied in order to each decoded product before it is written.
+	Transformers []Transformer `mapstructure:"Transformers"`
+	// Join merges the lines of secondary objects into the lines of the S3 object by product id.
+	Join Join `mapstructure:"Join"`
+	// Dedup keeps a single product of each product id in the S3 object.
+	Dedup Dedup `mapstructure:"Dedup"`
+	// OrderedWrites writes the products of the same product id in the order of the lines of the S3 object.
+	// The products are partitioned by product id across the writer workers of the object, and each partition
+	// writes its batches one at a time.
+	OrderedWrites bool `mapstructure:"OrderedWrites"`
+}
+
+// Dedup policies. The dedup is disabled if the policy is empty.
+const (
+	DedupFirst = "first"
+	DedupLast  = "last"
+	DedupMax   = "max"
+)
+
+// Fields of the max dedup policy.
+const (
+	DedupFieldTs    = "ts"
+	DedupFieldPrice = "price"
+)
+
+// DefaultDedupMemoryRecords is the default number of products kept in memory by a dedup.
+const DefaultDedupMemoryRecords = 100000
+
+// Dedup keeps the first or the last product of each product id in the order of the lines of the S3 object,
+// or the product with the greatest Field. A tie of the max policy keeps the last product.
+// The products are written after the whole object is parsed, so the result does not depend on the order of the writes.
+type Dedup struct {
+	Policy string `mapstructure:"Policy"`
+	// Field is the field compared by the max policy, one of ts, price. The ts field requires the cdc format.
+	Field string `mapstructure:"Field"`
+	// MemoryRecords is the number of products kept in memory. Above it, the products are spilled to partition files
+	// in SpillDir, and the partitions are deduplicated one at a time.
+	MemoryRecords int    `mapstructure:"MemoryRecords"`
+	SpillDir      string `mapstructure:"SpillDir"`
+}
+
+// Enabled reports whether the dedup has a policy.
+func (d Dedup) Enabled() bool {
+	return d.Policy != ""
+}
+
+// Join types. JoinLeft is the default type.
+const (
+	JoinLeft  = "left"
+	JoinInner = "inner"
+)
+
+// Default values of a join.
+const (
+	DefaultJoinKey           = "id"
+	DefaultJoinMemoryRecords = 100000
+)
+
+// Join reads the secondary objects before the S3 object and merges the fields of their lines into the line of
+// the S3 object with the same product id. The fields of a later source override the fields of an earlier one.
+// A left join loads a line without a match as it is, and an inner join loads only the lines with a match in every source.
+type Join struct {
+	Type    string       `mapstructure:"Type"`
+	Sources []JoinSource `mapstructure:"Sources"`
+	// MemoryRecords is the number of secondary lines kept in memory. Above it, both sides of the join
+	// are spilled to partition files in SpillDir, and the partitions are joined one at a time.
+	MemoryRecords int    `mapstructure:"MemoryRecords"`
+	SpillDir      string `mapstructure:"SpillDir"`
+}
+
+// Enabled reports whether the join has a secondary object.
+func (j Join) Enabled() bool {
+	return len(j.Sources) > 0
+}
+
+// JoinSource is a secondary object of a join. Key is the field of its lines that holds the product id.
+// BucketName defaults to the bucket of the S3 object and Key to id.
+type JoinSource struct {
+	BucketName string `mapstructure:"BucketName"`
+	ObjectKey  string `mapstructure:"ObjectKey"`
+	Key        string `mapstructure:"Key"`
+}
+
+// Transformer is a transformer registered by Name in the transform package and the params it is created with.
+type Transformer struct {
+	Name   string         `mapstructure:"Name"`
+	Params map[string]any `mapstructure:"Params"`
+}
+
+// Sink types. SinkTypeJSONL appends each product as a JSON line to a local file.
+const SinkTypeJSONL = "jsonl"
+
+// Default values of a sink.
+const (
+	DefaultSinkWorkers       = 1
+	DefaultSinkBatchSize     = 500
+	DefaultSinkFlushInterval = time.Second
+	DefaultSinkBuffer        = 10000
+)
+
+// Sink is a secondary destination of the products of an S3 object. Each sink has its own workers, batching and retry policy.
+// The products are handed to a sink without waiting, so a slow or failing sink never blocks the database writes.
+// If the buffer of a sink is full, the product is dropped for that sink.
+type Sink struct {
+	Name          string        `mapstructure:"Name"`
+	Type          string        `mapstructure:"Type"`
+	Path          string        `mapstructure:"Path"`
+	Workers       int           `mapstructure:"Workers"`
+	BatchSize     int           `mapstructure:"BatchSize"`
+	FlushInterval time.Duration `mapstructure:"FlushInterval"`
+	Buffer        int           `mapstructure:"Buffer"`
+	Retry         Retry         `mapstructure:"Retry"`
+}
+
+// Default values of a retry policy.
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBackoff     = 100 * time.Millisecond
+	DefaultRetryMaxBackoff  = 5 * time.Second
+)
+
+// Retry is the retry policy of a failed write. The backoff doubles after each attempt up to MaxBackoff.
+type Retry struct {
+	MaxAttempts int           `mapstructure:"MaxAttempts"`
+	Backoff     time.Duration `mapstructure:"Backoff"`
+	MaxBackoff  time.Duration `mapstructure:"MaxBackoff"`
+	// Jitter is the fraction of each backoff that is randomized, so the retries of concurrent writers are spread out.
+	Jitter float64 `mapstructure:"Jitter"`
+}
+
+// Default values of a write retry policy.
+const (
+	DefaultWriteRetryJitter = 0.5
+	DefaultWriteRetryBudget = 1000
+)
+
+// WriteRetry is the retry policy of the product writes of an S3 object.
+// Budget is the total number of product retries of the object, so a long outage cannot stall the load forever.
+// The products whose retries are used up are written to the dead-letter collection.
+type WriteRetry struct {
+	Retry  `mapstructure:",squash"`
+	Budget int `mapstructure:"Budget"`
+}
+
+// Promote strategies of an atomic load. PromoteMerge is the default strategy.
+const (
+	PromoteMerge  = "merge"
+	PromoteRename = "rename"
+)
+
+// Atomic loads the S3 object into a staging collection. The staging collection is promoted after the object is loaded
+// successfully and dropped if the load fails, so readers never see a partially loaded object.
+type Atomic struct {
+	Enabled bool `mapstructure:"Enabled"`
+	// Promote is merge to merge the staging collection into the product collection,
+	// or rename to replace the whole product collection with the staging collection.
+	Promote string `mapstructure:"Promote"`
+}
+
+// Formats of an S3 object. FormatProduct is the default format.
+// Each line of a FormatCDC object is an operation line with op and ts fields next to the product fields.
+const (
+	FormatProduct = "product"
+	FormatCDC     = "cdc"
+)
+
+// Delete modes of a snapshot. DeleteModeSoft is the default mode.
+const (
+	DeleteModeSoft = "soft"
+	DeleteModeHard = "hard"
+)
+
+// DefaultMaxDeleteRatio is the default maximum fraction of the source products that a snapshot can delete.
+const DefaultMaxDeleteRatio = 0.1
+
+// Snapshot marks the S3 object as a complete catalog of its source.
+// After the object is loaded successfully, the products of the source that are not in the object are deleted.
+// A product is in the object once it reaches the writers, so a product whose write failed keeps its stored version,
+// while the lines that are rejected or filtered out are not in the object and their products are deleted.
+type Snapshot struct {
+	Enabled    bool   `mapstructure:"Enabled"`
+	DeleteMode string `mapstructure:"DeleteMode"`
+	// MaxDeleteRatio guards against a truncated file. If the fraction of the source products to be deleted
+	// is greater than MaxDeleteRatio, nothing is deleted.
+	MaxDeleteRatio float64 `mapstructure:"MaxDeleteRatio"`
 }
 
 // LoadDatabase loads database configuration from environment variables.
 // It returns an error if any of the required environment variables are not set.
+// The collection variables name the tables if the driver is postgres.
 func (c *Config) LoadDatabase() error {
+	c.Database.Driver = os.Getenv("DB_DRIVER")
+	switch c.Database.Driver {
+	case "":
+		c.Database.Driver = DriverMongo
+	case DriverMongo, DriverPostgres, DriverSQLite:
+	default:
+		return errors.New("DB_DRIVER must be one of mongo, postgres, sqlite")
+	}
+	c.Database.SSLMode = os.Getenv("DB_SSLMODE")
+	if c.Database.SSLMode == "" {
+		c.Database.SSLMode = "disable"
+	}
 	c.Database.Name = os.Getenv("DB_NAME")
 	c.Database.Host = os.Getenv("DB_HOST")
 	c.Database.Pass = os.Getenv("DB_PASS")
@@ -43,7 +380,32 @@ func (c *Config) LoadDatabase() error {
 	c.Database.Port = os.Getenv("DB_PORT")
 	c.Database.ProductCollection = os.Getenv("DB_PRODUCT_COLLECTION")
 	c.Database.ObjectInfoCollection = os.Getenv("DB_OBJECTINFO_COLLECTION")
-	for _, env := range []string{"DB_NAME", "DB_HOST", "DB_PASS", "DB_USER", "DB_PORT", "DB_PRODUCT_COLLECTION", "DB_OBJECTINFO_COLLECTION"} {
+	c.Database.HistoryCollection = os.Getenv("DB_PRODUCT_HISTORY_COLLECTION")
+	if c.Database.HistoryCollection == "" {
+		c.Database.HistoryCollection = "product_history"
+	}
+	c.Database.DeadLetterCollection = os.Getenv("DB_DEADLETTER_COLLECTION")
+	if c.Database.DeadLetterCollection == "" {
+		c.Database.DeadLetterCollection = "dead_letters"
+	}
+	c.Database.MigrationCollection = os.Getenv("DB_MIGRATION_COLLECTION")
+	if c.Database.MigrationCollection == "" {
+		c.Database.MigrationCollection = "migrations"
+	}
+	c.Database.Path = os.Getenv("DB_PATH")
+	c.Database.URI = os.Getenv("DB_URI")
+	if err := c.Database.Mongo.load(); err != nil {
+		return err
+	}
+	required := []string{"DB_NAME", "DB_HOST", "DB_PASS", "DB_USER", "DB_PORT", "DB_PRODUCT_COLLECTION", "DB_OBJECTINFO_COLLECTION"}
+	switch {
+	case c.Database.Driver == DriverSQLite:
+		required = []string{"DB_PATH", "DB_PRODUCT_COLLECTION", "DB_OBJECTINFO_COLLECTION"}
+	case c.Database.URI != "":
+		// The database name of the mongo driver can be the default database of the connection string.
+		required = []string{"DB_PRODUCT_COLLECTION", "DB_OBJECTINFO_COLLECTION"}
+	}
+	for _, env := range required {
 		if os.Getenv(env) == "" {
 			return errors.New(env + " is required")
 		}
@@ -51,9 +413,67 @@ func (c *Config) LoadDatabase() error {
 	return nil
 }
 
+// Read preferences of the mongo driver.
+var readPreferences = []string{"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"}
+
+// load loads the mongo client options from environment variables.
+func (m *MongoOptions) load() error {
+	m.AuthSource = os.Getenv("DB_AUTH_SOURCE")
+	m.ReplicaSet = os.Getenv("DB_REPLICA_SET")
+	m.TLSCAFile = os.Getenv("DB_TLS_CA_FILE")
+	m.TLSCertificateKeyFile = os.Getenv("DB_TLS_CERT_KEY_FILE")
+	m.WriteConcern = os.Getenv("DB_WRITE_CONCERN")
+	m.ReadPreference = os.Getenv("DB_READ_PREFERENCE")
+	if tls := os.Getenv("DB_TLS"); tls != "" {
+		b, err := strconv.ParseBool(tls)
+		if err != nil {
+			return errors.New("DB_TLS must be a boolean")
+		}
+		m.TLS = b
+	}
+	m.TLS = m.TLS || m.TLSCAFile != "" || m.TLSCertificateKeyFile != ""
+	for env, value := range map[string]*uint64{
+		"DB_MAX_POOL_SIZE": &m.MaxPoolSize,
+		"DB_MIN_POOL_SIZE": &m.MinPoolSize,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return errors.New(env + " must be a non-negative number")
+			}
+			*value = n
+		}
+	}
+	for env, value := range map[string]*time.Duration{
+		"DB_CONNECT_TIMEOUT":          &m.ConnectTimeout,
+		"DB_SERVER_SELECTION_TIMEOUT": &m.ServerSelectionTimeout,
+		"DB_TIMEOUT":                  &m.Timeout,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return errors.New(env + " must be a positive duration")
+			}
+			*value = d
+		}
+	}
+	if m.MaxPoolSize > 0 && m.MinPoolSize > m.MaxPoolSize {
+		return errors.New("DB_MIN_POOL_SIZE must not be greater than DB_MAX_POOL_SIZE")
+	}
+	if m.ReadPreference != "" && !slices.Contains(readPreferences, m.ReadPreference) {
+		return errors.New("DB_READ_PREFERENCE must be one of " + strings.Join(readPreferences, ", "))
+	}
+	return nil
+}
+
 // LoadAws loads aws configuration from environment variables.
 // It returns an error if any of the required environment variables are not set.
+// The credentials are not required if AWS_LOCAL_DIR is set.
 func (c *Config) LoadAws() error {
+	c.Aws.LocalDir = os.Getenv("AWS_LOCAL_DIR")
+	if c.Aws.LocalDir != "" {
+		return nil
+	}
 	c.Aws.Region = os.Getenv("AWS_REGION")
 	c.Aws.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
 	c.Aws.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
@@ -65,6 +485,126 @@ func (c *Config) LoadAws() error {
 	return nil
 }
 
+// LoadOutbox loads outbox configuration from environment variables.
+// The outbox is disabled if OUTBOX_PUBLISHER is not set. It requires the mongo driver and does not support atomic loads.
+func (c *Config) LoadOutbox() error {
+	c.Outbox = Outbox{
+		Publisher:    os.Getenv("OUTBOX_PUBLISHER"),
+		Collection:   os.Getenv("OUTBOX_COLLECTION"),
+		FilePath:     os.Getenv("OUTBOX_FILE"),
+		NATSURL:      os.Getenv("OUTBOX_NATS_URL"),
+		NATSSubject:  os.Getenv("OUTBOX_NATS_SUBJECT"),
+		KafkaTopic:   os.Getenv("OUTBOX_KAFKA_TOPIC"),
+		PollInterval: DefaultOutboxPollInterval,
+		BatchSize:    DefaultOutboxBatchSize,
+	}
+	if !c.Outbox.Enabled() {
+		return nil
+	}
+	if brokers := os.Getenv("OUTBOX_KAFKA_BROKERS"); brokers != "" {
+		c.Outbox.KafkaBrokers = strings.Split(brokers, ",")
+	}
+	if c.Outbox.Collection == "" {
+		c.Outbox.Collection = DefaultOutboxCollection
+	}
+	if c.Outbox.NATSSubject == "" {
+		c.Outbox.NATSSubject = DefaultOutboxSubject
+	}
+	if c.Outbox.KafkaTopic == "" {
+		c.Outbox.KafkaTopic = DefaultOutboxSubject
+	}
+	if interval := os.Getenv("OUTBOX_POLL_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return errors.New("OUTBOX_POLL_INTERVAL must be a positive duration")
+		}
+		c.Outbox.PollInterval = d
+	}
+	if size := os.Getenv("OUTBOX_BATCH_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return errors.New("OUTBOX_BATCH_SIZE must be a positive number")
+		}
+		c.Outbox.BatchSize = n
+	}
+	switch c.Outbox.Publisher {
+	case PublisherStdout:
+	case PublisherFile:
+		if c.Outbox.FilePath == "" {
+			return errors.New("OUTBOX_FILE is required for the file publisher")
+		}
+	case PublisherNATS:
+		if c.Outbox.NATSURL == "" {
+			return errors.New("OUTBOX_NATS_URL is required for the nats publisher")
+		}
+	case PublisherKafka:
+		if len(c.Outbox.KafkaBrokers) == 0 {
+			return errors.New("OUTBOX_KAFKA_BROKERS is required for the kafka publisher")
+		}
+	default:
+		return errors.New("OUTBOX_PUBLISHER must be one of stdout, file, nats, kafka")
+	}
+	if c.Database.Driver != DriverMongo {
+		return errors.New("OUTBOX_PUBLISHER requires the mongo DB_DRIVER")
+	}
+	for _, s3 := range c.Aws.S3 {
+		if s3.Atomic.Enabled {
+			return errors.New("OUTBOX_PUBLISHER does not support Atomic of " + s3.ObjectKey)
+		}
+	}
+	return nil
+}
+
+// LoadThrottle loads the write throttle configuration from environment variables.
+// The writes are not throttled if none of the variables are set.
+func (c *Config) LoadThrottle() error {
+	c.Throttle = Throttle{
+		MinInFlight:   DefaultThrottleMinInFlight,
+		TargetLatency: DefaultThrottleTargetLatency,
+	}
+	if rate := os.Getenv("WRITE_RATE_LIMIT"); rate != "" {
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil || r < 0 {
+			return errors.New("WRITE_RATE_LIMIT must be a non-negative number")
+		}
+		c.Throttle.Rate = r
+	}
+	for env, value := range map[string]*int{
+		"WRITE_BURST":         &c.Throttle.Burst,
+		"WRITE_MAX_IN_FLIGHT": &c.Throttle.MaxInFlight,
+		"WRITE_MIN_IN_FLIGHT": &c.Throttle.MinInFlight,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return errors.New(env + " must be a non-negative number")
+			}
+			*value = n
+		}
+	}
+	if adaptive := os.Getenv("WRITE_ADAPTIVE"); adaptive != "" {
+		b, err := strconv.ParseBool(adaptive)
+		if err != nil {
+			return errors.New("WRITE_ADAPTIVE must be a boolean")
+		}
+		c.Throttle.Adaptive = b
+	}
+	if latency := os.Getenv("WRITE_TARGET_LATENCY"); latency != "" {
+		d, err := time.ParseDuration(latency)
+		if err != nil || d <= 0 {
+			return errors.New("WRITE_TARGET_LATENCY must be a positive duration")
+		}
+		c.Throttle.TargetLatency = d
+	}
+	if c.Throttle.Adaptive && c.Throttle.MaxInFlight == 0 {
+		return errors.New("WRITE_ADAPTIVE requires WRITE_MAX_IN_FLIGHT")
+	}
+	if c.Throttle.Adaptive && (c.Throttle.MinInFlight < 1 || c.Throttle.MinInFlight > c.Throttle.MaxInFlight) {
+		return errors.New("WRITE_MIN_IN_FLIGHT must be between 1 and WRITE_MAX_IN_FLIGHT")
+	}
+	return nil
+}
+
 // LoadS3Objects loads S3 objects from configuration file.
 // It returns an error if the S3 objects cannot be unmarshalled.
 func (c *Config) LoadS3Objects() error {
@@ -82,12 +622,246 @@ func (c *Config) LoadS3Objects() error {
 	if err := viper.Unmarshal(&c.Aws); err != nil {
 		return err
 	}
+	return c.ValidateS3Objects()
+}
+
+// ValidateS3Objects sets the default values of the S3 objects and checks the values.
+// An object promoted by rename replaces the whole product collection, so it must be the only S3 object.
+// Otherwise the rename would delete the products of the other objects, and concurrent renames would overwrite each other.
+func (c *Config) ValidateS3Objects() error {
+	for i := range c.Aws.S3 {
+		if err := c.Aws.S3[i].validate(); err != nil {
+			return err
+		}
+	}
+	for _, s3Object := range c.Aws.S3 {
+		if s3Object.Atomic.Enabled && s3Object.Atomic.Promote == PromoteRename && len(c.Aws.S3) > 1 {
+			return errors.New("rename Atomic.Promote of " + s3Object.ObjectKey + " requires it to be the only S3 object")
+		}
+	}
+	return nil
+}
+
+// validate sets the default values of the S3 object and checks the values.
+func (s *S3) validate() error {
+	switch s.WriteMode {
+	case "":
+		s.WriteMode = WriteModeInsert
+	case WriteModeInsert, WriteModeReplace, WriteModeMerge:
+	default:
+		return errors.New("WriteMode of " + s.ObjectKey + " must be one of insert, replace, merge")
+	}
+	if s.ChangeDetection && s.WriteMode == WriteModeInsert {
+		return errors.New("ChangeDetection of " + s.ObjectKey + " requires replace or merge WriteMode")
+	}
+	switch s.Format {
+	case "":
+		s.Format = FormatProduct
+	case FormatProduct, FormatCDC:
+	default:
+		return errors.New("Format of " + s.ObjectKey + " must be one of product, cdc")
+	}
+	if s.Format == FormatCDC && s.WriteMode == WriteModeInsert {
+		return errors.New("cdc Format of " + s.ObjectKey + " requires replace or merge WriteMode")
+	}
+	if s.Source == "" {
+		s.Source = s.BucketName + "/" + s.ObjectKey
+	}
+	names := make(map[string]bool, len(s.Sinks))
+	for i := range s.Sinks {
+		if err := s.Sinks[i].validate(s.ObjectKey, i); err != nil {
+			return err
+		}
+		if names[s.Sinks[i].Name] {
+			return errors.New("Sinks of " + s.ObjectKey + " must have unique names")
+		}
+		names[s.Sinks[i].Name] = true
+	}
+	if err := s.WriteRetry.validate(s.ObjectKey); err != nil {
+		return err
+	}
+	if s.Atomic.Enabled {
+		switch s.Atomic.Promote {
+		case "":
+			s.Atomic.Promote = PromoteMerge
+		case PromoteMerge, PromoteRename:
+		default:
+			return errors.New("Atomic.Promote of " + s.ObjectKey + " must be one of merge, rename")
+		}
+		if s.Format == FormatCDC {
+			return errors.New("Atomic of " + s.ObjectKey + " does not support cdc Format")
+		}
+		// the unchanged products are not written to the staging collection, so a rename would delete them.
+		if s.Atomic.Promote == PromoteRename && s.ChangeDetection {
+			return errors.New("rename Atomic.Promote of " + s.ObjectKey + " does not support ChangeDetection")
+		}
+	}
+	if s.Join.Enabled() {
+		if err := s.Join.validate(s.BucketName, s.ObjectKey); err != nil {
+			return err
+		}
+	}
+	if s.Dedup.Enabled() {
+		if err := s.Dedup.validate(s.ObjectKey, s.Format); err != nil {
+			return err
+		}
+	}
+	if !s.Snapshot.Enabled {
+		return nil
+	}
+	if s.WriteMode == WriteModeInsert {
+		return errors.New("Snapshot of " + s.ObjectKey + " requires replace or merge WriteMode")
+	}
+	switch s.Snapshot.DeleteMode {
+	case "":
+		s.Snapshot.DeleteMode = DeleteModeSoft
+	case DeleteModeSoft, DeleteModeHard:
+	default:
+		return errors.New("Snapshot.DeleteMode of " + s.ObjectKey + " must be one of soft, hard")
+	}
+	if s.Snapshot.MaxDeleteRatio == 0 {
+		s.Snapshot.MaxDeleteRatio = DefaultMaxDeleteRatio
+	}
+	if s.Snapshot.MaxDeleteRatio < 0 || s.Snapshot.MaxDeleteRatio > 1 {
+		return errors.New("Snapshot.MaxDeleteRatio of " + s.ObjectKey + " must be between 0 and 1")
+	}
 	return nil
 }
 
+// validate sets the default values of the join and checks the values.
+func (j *Join) validate(bucketName, objectKey string) error {
+	switch j.Type {
+	case "":
+		j.Type = JoinLeft
+	case JoinLeft, JoinInner:
+	default:
+		return errors.New("Join.Type of " + objectKey + " must be one of left, inner")
+	}
+	if j.MemoryRecords < 0 {
+		return errors.New("Join.MemoryRecords of " + objectKey + " must not be negative")
+	}
+	if j.MemoryRecords == 0 {
+		j.MemoryRecords = DefaultJoinMemoryRecords
+	}
+	for i := range j.Sources {
+		source := &j.Sources[i]
+		if source.ObjectKey == "" {
+			return errors.New("Join.Sources.ObjectKey of " + objectKey + " is required")
+		}
+		if source.BucketName == "" {
+			source.BucketName = bucketName
+		}
+		if source.Key == "" {
+			source.Key = DefaultJoinKey
+		}
+	}
+	return nil
+}
+
+// validate sets the default values of the dedup and checks the values.
+func (d *Dedup) validate(objectKey, format string) error {
+	switch d.Policy {
+	case DedupFirst, DedupLast:
+		if d.Field != "" {
+			return errors.New("Dedup.Field of " + objectKey + " requires max Policy")
+		}
+	case DedupMax:
+		if d.Field != DedupFieldTs && d.Field != DedupFieldPrice {
+			return errors.New("Dedup.Field of " + objectKey + " must be one of ts, price")
+		}
+		// the ts of a product line is not read, so every product would tie and the last one would win.
+		if d.Field == DedupFieldTs && format != FormatCDC {
+			return errors.New("ts Dedup.Field of " + objectKey + " requires cdc Format")
+		}
+	default:
+		return errors.New("Dedup.Policy of " + objectKey + " must be one of first, last, max")
+	}
+	if d.MemoryRecords < 0 {
+		return errors.New("Dedup.MemoryRecords of " + objectKey + " must not be negative")
+	}
+	if d.MemoryRecords == 0 {
+		d.MemoryRecords = DefaultDedupMemoryRecords
+	}
+	return nil
+}
+
+// validate sets the default values of the sink and checks the values.
+func (s *Sink) validate(objectKey string, index int) error {
+	if s.Type != SinkTypeJSONL {
+		return errors.New("Sinks.Type of " + objectKey + " must be jsonl")
+	}
+	if s.Name == "" {
+		s.Name = s.Type + "-" + strconv.Itoa(index)
+	}
+	if s.Path == "" {
+		return errors.New("Sinks.Path of " + objectKey + " is required")
+	}
+	if s.Workers <= 0 {
+		s.Workers = DefaultSinkWorkers
+	}
+	if s.BatchSize <= 0 {
+		s.BatchSize = DefaultSinkBatchSize
+	}
+	if s.FlushInterval <= 0 {
+		s.FlushInterval = DefaultSinkFlushInterval
+	}
+	if s.Buffer <= 0 {
+		s.Buffer = DefaultSinkBuffer
+	}
+	s.Retry.setDefaults()
+	if s.Retry.Jitter < 0 || s.Retry.Jitter > 1 {
+		return errors.New("Sinks.Retry.Jitter of " + objectKey + " must be between 0 and 1")
+	}
+	return nil
+}
+
+// validate sets the default values of the write retry policy and checks the values.
+func (w *WriteRetry) validate(objectKey string) error {
+	w.setDefaults()
+	if w.Jitter == 0 {
+		w.Jitter = DefaultWriteRetryJitter
+	}
+	if w.Jitter < 0 || w.Jitter > 1 {
+		return errors.New("WriteRetry.Jitter of " + objectKey + " must be between 0 and 1")
+	}
+	if w.Budget <= 0 {
+		w.Budget = DefaultWriteRetryBudget
+	}
+	return nil
+}
+
+func (r *Retry) setDefaults() {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = DefaultRetryBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultRetryMaxBackoff
+	}
+}
+
+// loadDryRun returns whether the job is a dry run. The dry-run flag overrides the DRY_RUN environment variable.
+func loadDryRun(flags Flags) (bool, error) {
+	if flags.DryRun {
+		return true, nil
+	}
+	v := os.Getenv("DRY_RUN")
+	if v == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("DRY_RUN must be a boolean")
+	}
+	return dryRun, nil
+}
+
 // LoadConfig loads configuration from file.
 // It sets initial values for database and aws configurations.
-func LoadConfig() (*Config, error) {
+// The flags override the pipeline sizing of the job config file and the environment variables.
+func LoadConfig(flags Flags) (*Config, error) {
 	var (
 		cfg Config
 		err error
@@ -101,5 +875,17 @@ func LoadConfig() (*Config, error) {
 	if err = cfg.LoadAws(); err != nil {
 		return nil, err
 	}
+	if err = cfg.LoadOutbox(); err != nil {
+		return nil, err
+	}
+	if err = cfg.LoadThrottle(); err != nil {
+		return nil, err
+	}
+	if err = cfg.LoadPipeline(flags); err != nil {
+		return nil, err
+	}
+	if cfg.DryRun, err = loadDryRun(flags); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,62 @@
+package config_test
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"testing"
+)
+
+func TestConfig_ValidateS3Objects(t *testing.T) {
+	rename := config.Atomic{Enabled: true, Promote: config.PromoteRename}
+	merge := config.Atomic{Enabled: true, Promote: config.PromoteMerge}
+	tests := []struct {
+		name    string
+		s3      []config.S3
+		wantErr bool
+	}{
+		{
+			name: "rename of the only object",
+			s3:   []config.S3{{BucketName: "bucket", ObjectKey: "a", Atomic: rename}},
+		},
+		{
+			name: "merge of several objects",
+			s3: []config.S3{
+				{BucketName: "bucket", ObjectKey: "a", Atomic: merge},
+				{BucketName: "bucket", ObjectKey: "b", Atomic: merge},
+			},
+		},
+		{
+			name: "rename next to another object",
+			s3: []config.S3{
+				{BucketName: "bucket", ObjectKey: "a", Atomic: rename},
+				{BucketName: "bucket", ObjectKey: "b"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "rename with change detection",
+			s3:      []config.S3{{BucketName: "bucket", ObjectKey: "a", WriteMode: config.WriteModeReplace, ChangeDetection: true, Atomic: rename}},
+			wantErr: true,
+		},
+		{
+			name:    "max ts dedup of product lines",
+			s3:      []config.S3{{BucketName: "bucket", ObjectKey: "a", Dedup: config.Dedup{Policy: config.DedupMax, Field: config.DedupFieldTs}}},
+			wantErr: true,
+		},
+		{
+			name: "max ts dedup of cdc lines",
+			s3:   []config.S3{{BucketName: "bucket", ObjectKey: "a", WriteMode: config.WriteModeReplace, Format: config.FormatCDC, Dedup: config.Dedup{Policy: config.DedupMax, Field: config.DedupFieldTs}}},
+		},
+		{
+			name: "merge with change detection",
+			s3:   []config.S3{{BucketName: "bucket", ObjectKey: "a", WriteMode: config.WriteModeReplace, ChangeDetection: true, Atomic: merge}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{Aws: config.Aws{S3: tt.s3}}
+			if err := cfg.ValidateS3Objects(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateS3Objects() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// DefaultJobConfigFile is the job config file that is read from the working directory if it exists.
+const DefaultJobConfigFile = "job.yml"
+
+// Default values of the shared worker pools.
+const (
+	DefaultWriterWorkers   = 50
+	DefaultObjectsInFlight = 4
+)
+
+// Default values of the pipeline sizing.
+const (
+	DefaultLineChannelSize    = 50
+	DefaultProductChannelSize = 50
+	DefaultBatchSize          = 500
+	DefaultBatchBytes         = 4 << 20
+	DefaultFlushInterval      = time.Second
+	DefaultFlushDeadline      = 10 * time.Second
+)
+
+// Workers sizes the parser and writer pools shared by all S3 objects and bounds the objects processed at once.
+// The workers of a pool are split evenly between the objects in flight, and each object gets at least one.
+type Workers struct {
+	Parsers         int `mapstructure:"Parsers"`
+	Writers         int `mapstructure:"Writers"`
+	ObjectsInFlight int `mapstructure:"ObjectsInFlight"`
+}
+
+// Pipeline sizes the channels, workers and batches of an S3 object. A zero value is not set and is inherited
+// from the lower precedence source.
+type Pipeline struct {
+	LineChannelSize    int `mapstructure:"LineChannelSize"`
+	ProductChannelSize int `mapstructure:"ProductChannelSize"`
+	// ParserWorkers and WriterWorkers are the workers of the shared pools that the S3 object can use at once.
+	// They default to an even split of the pools between the objects in flight.
+	ParserWorkers int           `mapstructure:"ParserWorkers"`
+	WriterWorkers int           `mapstructure:"WriterWorkers"`
+	BatchSize     int           `mapstructure:"BatchSize"`
+	BatchBytes    int           `mapstructure:"BatchBytes"`
+	FlushInterval time.Duration `mapstructure:"FlushInterval"`
+	// FlushDeadline is how long the batches in flight are written after a shutdown signal.
+	FlushDeadline time.Duration `mapstructure:"FlushDeadline"`
+}
+
+// Flags are the command line overrides of the job config file and the environment variables.
+type Flags struct {
+	ConfigFile string
+	// DryRun reads, parses and transforms the S3 objects without writing to the database.
+	DryRun   bool
+	Workers  Workers
+	Pipeline Pipeline
+}
+
+// jobFile is the content of the job config file.
+type jobFile struct {
+	Workers  Workers  `mapstructure:"Workers"`
+	Pipeline Pipeline `mapstructure:"Pipeline"`
+}
+
+// ParseFlags parses the command line flags of the job.
+func ParseFlags(args []string) (Flags, error) {
+	var f Flags
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.StringVar(&f.ConfigFile, "config", "", "path of the job config file (default "+DefaultJobConfigFile+" if it exists)")
+	fs.BoolVar(&f.DryRun, "dry-run", false, "report what would be written without writing to the database")
+	fs.IntVar(&f.Workers.Parsers, "parser-workers", 0, "size of the shared parser pool")
+	fs.IntVar(&f.Workers.Writers, "writer-workers", 0, "size of the shared writer pool")
+	fs.IntVar(&f.Workers.ObjectsInFlight, "max-objects-in-flight", 0, "maximum number of objects processed at once")
+	fs.IntVar(&f.Pipeline.LineChannelSize, "line-channel-size", 0, "buffer size of the line channel of an object")
+	fs.IntVar(&f.Pipeline.ProductChannelSize, "product-channel-size", 0, "buffer size of the product channel of an object")
+	fs.IntVar(&f.Pipeline.ParserWorkers, "object-parser-workers", 0, "parser workers an object can use at once")
+	fs.IntVar(&f.Pipeline.WriterWorkers, "object-writer-workers", 0, "writer workers an object can use at once")
+	fs.IntVar(&f.Pipeline.BatchSize, "batch-size", 0, "maximum number of products of a bulk write")
+	fs.IntVar(&f.Pipeline.BatchBytes, "batch-bytes", 0, "approximate maximum size in bytes of a bulk write")
+	fs.DurationVar(&f.Pipeline.FlushInterval, "flush-interval", 0, "interval of flushing a partial batch")
+	fs.DurationVar(&f.Pipeline.FlushDeadline, "flush-deadline", 0, "time to write the batches in flight after a shutdown signal")
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
+	if fs.NArg() > 0 {
+		return f, errors.New("unexpected arguments: " + fmt.Sprint(fs.Args()))
+	}
+	if err := f.Workers.check("flag"); err != nil {
+		return f, err
+	}
+	return f, f.Pipeline.check("flag")
+}
+
+// LoadPipeline loads the worker pools and the pipeline sizing. The values are taken from the defaults,
+// the job config file, the environment variables and the flags, each overriding the previous one.
+// The Pipeline of an S3 object overrides the global pipeline sizing for that object.
+func (c *Config) LoadPipeline(flags Flags) error {
+	c.Workers = Workers{
+		Parsers:         runtime.NumCPU(),
+		Writers:         DefaultWriterWorkers,
+		ObjectsInFlight: DefaultObjectsInFlight,
+	}
+	c.Pipeline = Pipeline{
+		LineChannelSize:    DefaultLineChannelSize,
+		ProductChannelSize: DefaultProductChannelSize,
+		BatchSize:          DefaultBatchSize,
+		BatchBytes:         DefaultBatchBytes,
+		FlushInterval:      DefaultFlushInterval,
+		FlushDeadline:      DefaultFlushDeadline,
+	}
+	file, err := loadJobFile(flags.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if err := file.Workers.check("job config file"); err != nil {
+		return err
+	}
+	if err := file.Pipeline.check("job config file"); err != nil {
+		return err
+	}
+	envWorkers, envPipeline, err := loadPipelineEnv()
+	if err != nil {
+		return err
+	}
+	c.Workers = c.Workers.Merge(file.Workers).Merge(envWorkers).Merge(flags.Workers)
+	c.Pipeline = c.Pipeline.Merge(file.Pipeline).Merge(envPipeline).Merge(flags.Pipeline)
+	if err := c.Pipeline.validate(c.Workers, "global pipeline"); err != nil {
+		return err
+	}
+	for i := range c.Aws.S3 {
+		s := &c.Aws.S3[i]
+		if err := s.Pipeline.check("Pipeline of " + s.ObjectKey); err != nil {
+			return err
+		}
+		s.Pipeline = c.Pipeline.Merge(s.Pipeline)
+		if err := s.Pipeline.validate(c.Workers, "Pipeline of "+s.ObjectKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadJobFile reads the job config file. The default file is optional, but a file given by the JOB_CONFIG
+// environment variable or the config flag must exist.
+func loadJobFile(path string) (jobFile, error) {
+	var file jobFile
+	if path == "" {
+		path = os.Getenv("JOB_CONFIG")
+	}
+	if path == "" {
+		if _, err := os.Stat(DefaultJobConfigFile); err != nil {
+			return file, nil
+		}
+		path = DefaultJobConfigFile
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return file, err
+	}
+	if err := v.Unmarshal(&file); err != nil {
+		return file, err
+	}
+	return file, nil
+}
+
+// loadPipelineEnv loads the worker pools and the pipeline sizing from environment variables.
+func loadPipelineEnv() (Workers, Pipeline, error) {
+	var (
+		w Workers
+		p Pipeline
+	)
+	for env, value := range map[string]*int{
+		"PARSER_WORKERS":        &w.Parsers,
+		"WRITER_WORKERS":        &w.Writers,
+		"MAX_OBJECTS_IN_FLIGHT": &w.ObjectsInFlight,
+		"LINE_CHANNEL_SIZE":     &p.LineChannelSize,
+		"PRODUCT_CHANNEL_SIZE":  &p.ProductChannelSize,
+		"OBJECT_PARSER_WORKERS": &p.ParserWorkers,
+		"OBJECT_WRITER_WORKERS": &p.WriterWorkers,
+		"BATCH_SIZE":            &p.BatchSize,
+		"BATCH_BYTES":           &p.BatchBytes,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return w, p, errors.New(env + " must be a positive number")
+			}
+			*value = n
+		}
+	}
+	for env, value := range map[string]*time.Duration{
+		"FLUSH_INTERVAL": &p.FlushInterval,
+		"FLUSH_DEADLINE": &p.FlushDeadline,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return w, p, errors.New(env + " must be a positive duration")
+			}
+			*value = d
+		}
+	}
+	return w, p, nil
+}
+
+// Merge returns the workers with the values that are set in o.
+func (w Workers) Merge(o Workers) Workers {
+	w.Parsers = mergeValue(w.Parsers, o.Parsers)
+	w.Writers = mergeValue(w.Writers, o.Writers)
+	w.ObjectsInFlight = mergeValue(w.ObjectsInFlight, o.ObjectsInFlight)
+	return w
+}
+
+// Merge returns the pipeline with the values that are set in o.
+func (p Pipeline) Merge(o Pipeline) Pipeline {
+	p.LineChannelSize = mergeValue(p.LineChannelSize, o.LineChannelSize)
+	p.ProductChannelSize = mergeValue(p.ProductChannelSize, o.ProductChannelSize)
+	p.ParserWorkers = mergeValue(p.ParserWorkers, o.ParserWorkers)
+	p.WriterWorkers = mergeValue(p.WriterWorkers, o.WriterWorkers)
+	p.BatchSize = mergeValue(p.BatchSize, o.BatchSize)
+	p.BatchBytes = mergeValue(p.BatchBytes, o.BatchBytes)
+	p.FlushInterval = mergeValue(p.FlushInterval, o.FlushInterval)
+	p.FlushDeadline = mergeValue(p.FlushDeadline, o.FlushDeadline)
+	return p
+}
+
+// ObjectWorkers returns the parser and writer workers the S3 object can use at once.
+// If they are not set, the pools are split evenly between the objects in flight.
+func (p Pipeline) ObjectWorkers(w Workers) (parsers, writers int) {
+	objectsInFlight := max(w.ObjectsInFlight, 1)
+	parsers, writers = p.ParserWorkers, p.WriterWorkers
+	if parsers == 0 {
+		parsers = max(w.Parsers/objectsInFlight, 1)
+	}
+	if writers == 0 {
+		writers = max(w.Writers/objectsInFlight, 1)
+	}
+	return parsers, writers
+}
+
+func mergeValue[T int | time.Duration](value, override T) T {
+	if override != 0 {
+		return override
+	}
+	return value
+}
+
+// check checks that the workers of a source are not negative.
+func (w Workers) check(source string) error {
+	if w.Parsers < 0 || w.Writers < 0 || w.ObjectsInFlight < 0 {
+		return errors.New("workers of " + source + " must not be negative")
+	}
+	return nil
+}
+
+// check checks that the pipeline sizing of a source is not negative.
+func (p Pipeline) check(source string) error {
+	if p.LineChannelSize < 0 || p.ProductChannelSize < 0 || p.ParserWorkers < 0 || p.WriterWorkers < 0 ||
+		p.BatchSize < 0 || p.BatchBytes < 0 || p.FlushInterval < 0 || p.FlushDeadline < 0 {
+		return errors.New("pipeline sizing of " + source + " must not be negative")
+	}
+	return nil
+}
+
+// validate checks the effective pipeline sizing against the worker pools.
+func (p Pipeline) validate(w Workers, name string) error {
+	if p.LineChannelSize < 1 || p.ProductChannelSize < 1 || p.BatchSize < 1 || p.FlushInterval <= 0 || p.FlushDeadline <= 0 {
+		return errors.New(name + ": channel sizes, batch size, flush interval and flush deadline must be positive")
+	}
+	if p.ParserWorkers > w.Parsers {
+		return fmt.Errorf("%s: ParserWorkers %d exceeds the %d parser workers", name, p.ParserWorkers, w.Parsers)
+	}
+	if p.WriterWorkers > w.Writers {
+		return fmt.Errorf("%s: WriterWorkers %d exceeds the %d writer workers", name, p.WriterWorkers, w.Writers)
+	}
+	return nil
+}
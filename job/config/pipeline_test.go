@@ -0,0 +1,90 @@
+package config_test
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig_LoadPipeline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "job.yml")
+	content := `Workers:
+  Parsers: 8
+  Writers: 20
+Pipeline:
+  LineChannelSize: 100
+  ProductChannelSize: 100
+  BatchSize: 200
+`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PRODUCT_CHANNEL_SIZE", "300")
+	t.Setenv("BATCH_SIZE", "300")
+	t.Setenv("WRITER_WORKERS", "40")
+	flags, err := config.ParseFlags([]string{"-config", file, "-batch-size", "400", "-flush-interval", "2s"})
+	if err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+	cfg := config.Config{Aws: config.Aws{S3: []config.S3{
+		{ObjectKey: "global"},
+		{ObjectKey: "override", Pipeline: config.Pipeline{BatchSize: 50, WriterWorkers: 10}},
+	}}}
+	if err := cfg.LoadPipeline(flags); err != nil {
+		t.Fatalf("LoadPipeline() error = %v", err)
+	}
+	wantWorkers := config.Workers{Parsers: 8, Writers: 40, ObjectsInFlight: config.DefaultObjectsInFlight}
+	if cfg.Workers != wantWorkers {
+		t.Errorf("Workers = %+v, want %+v", cfg.Workers, wantWorkers)
+	}
+	want := config.Pipeline{
+		LineChannelSize:    100,
+		ProductChannelSize: 300,
+		BatchSize:          400,
+		BatchBytes:         config.DefaultBatchBytes,
+		FlushInterval:      2 * time.Second,
+		FlushDeadline:      config.DefaultFlushDeadline,
+	}
+	if cfg.Aws.S3[0].Pipeline != want {
+		t.Errorf("Pipeline of global = %+v, want %+v", cfg.Aws.S3[0].Pipeline, want)
+	}
+	want.BatchSize, want.WriterWorkers = 50, 10
+	if cfg.Aws.S3[1].Pipeline != want {
+		t.Errorf("Pipeline of override = %+v, want %+v", cfg.Aws.S3[1].Pipeline, want)
+	}
+	if parsers, writers := cfg.Aws.S3[1].Pipeline.ObjectWorkers(cfg.Workers); parsers != 2 || writers != 10 {
+		t.Errorf("ObjectWorkers() = %d, %d, want 2, 10", parsers, writers)
+	}
+}
+
+func TestConfig_LoadPipeline_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		flags []string
+		s3    config.S3
+	}{
+		{name: "env not a number", env: map[string]string{"BATCH_SIZE": "many"}},
+		{name: "negative flag", flags: []string{"-line-channel-size", "-1"}},
+		{name: "negative object value", s3: config.S3{ObjectKey: "test", Pipeline: config.Pipeline{BatchSize: -1}}},
+		{name: "object workers exceed pool", s3: config.S3{ObjectKey: "test", Pipeline: config.Pipeline{ParserWorkers: 1000}}},
+		{name: "missing config file", flags: []string{"-config", filepath.Join(t.TempDir(), "missing.yml")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			flags, err := config.ParseFlags(tt.flags)
+			if err != nil {
+				return
+			}
+			cfg := config.Config{Aws: config.Aws{S3: []config.S3{tt.s3}}}
+			if err := cfg.LoadPipeline(flags); err == nil {
+				t.Errorf("LoadPipeline() error = nil, want error")
+			}
+		})
+	}
+}
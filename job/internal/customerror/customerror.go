@@ -12,6 +12,32 @@ var (
 	ErrCreateIndexFailed = New("failed to create index", true)
 	ErrCreateObjectInfo  = New("failed to create object info", true)
 	ErrCreateProduct     = New("failed to create product", true)
+	ErrUpdateObjectInfo  = New("failed to update object info", true)
+	ErrReplaceProduct    = New("failed to replace product", true)
+	ErrMergeProduct      = New("failed to merge product", true)
+	ErrFindProducts      = New("failed to find products", true)
+	ErrCreateHistory     = New("failed to create product history", true)
+	ErrDeleteProducts    = New("failed to delete products", true)
+	ErrSnapshotLimit     = New("snapshot delete limit exceeded", true)
+	ErrStaleEvent        = New("newer event already stored", false)
+	ErrInvalidOperation  = New("invalid operation", true)
+	ErrCreateStaging     = New("failed to create staging collection", true)
+	ErrPromoteStaging    = New("failed to promote staging collection", true)
+	ErrDropStaging       = New("failed to drop staging collection", true)
+	ErrOpenSink          = New("failed to open sink", true)
+	ErrWriteSink         = New("failed to write to sink", true)
+	ErrFindEvents        = New("failed to find outbox events", true)
+	ErrMarkEvents        = New("failed to mark outbox events as published", true)
+	ErrPublishEvents     = New("failed to publish outbox events", true)
+	ErrCreateDeadLetters = New("failed to create dead letters", true)
+	ErrWaitThrottle      = New("failed to wait for the write throttle", true)
+	ErrFindMigrations    = New("failed to find applied migrations", true)
+	ErrApplyMigration    = New("failed to apply migration", true)
+	ErrTransformProduct  = New("failed to transform product", true)
+	ErrFilterProduct     = New("failed to filter product", true)
+	ErrJoinObject        = New("failed to join object", true)
+	ErrDedupProducts     = New("failed to deduplicate products", true)
+	ErrObjectLoaded      = New("object is already loaded", false)
 )
 
 type CustomError interface {
@@ -0,0 +1,195 @@
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/spill"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"sort"
+	"sync"
+)
+
+// partitions is the number of partition files of a spilled index.
+const partitions = 16
+
+// entry is a product with the sequence number of its line in the S3 object.
+// Source is stored next to the product, since the product does not marshal it.
+type entry struct {
+	Seq     int64         `json:"seq"`
+	Source  string        `json:"source"`
+	Product model.Product `json:"product"`
+}
+
+// Index keeps the winning product of each product id by the policy of the dedup. The products are added
+// concurrently by the parser workers with the sequence number of their line, so the winner does not depend on
+// the order the products are added in. The products of the same line share the sequence number, and a later
+// product of the line wins a tie.
+//
+// The products are kept in memory up to the MemoryRecords of the dedup. Above it, the products are written to
+// partition files by product id, and Drain deduplicates the partitions one at a time.
+type Index struct {
+	dedup config.Dedup
+
+	mu         sync.Mutex
+	entries    map[int]entry
+	spilled    *spill.Partitions
+	duplicates int64
+}
+
+// New returns an index of the dedup. Close must be called to remove the spill files.
+func New(dedup config.Dedup) *Index {
+	return &Index{
+		dedup:   dedup,
+		entries: make(map[int]entry),
+	}
+}
+
+// Add adds a product of the line with the sequence number.
+func (i *Index) Add(seq int64, product model.Product) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	e := entry{Seq: seq, Source: product.Source, Product: product}
+	if i.spilled != nil {
+		return i.write(e)
+	}
+	i.keep(e)
+	if len(i.entries) > i.dedup.MemoryRecords {
+		return i.spill()
+	}
+	return nil
+}
+
+// Duplicates returns the number of products that lost to another product with the same product id.
+// The duplicates of a spilled index are counted by Drain.
+func (i *Index) Duplicates() int64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.duplicates
+}
+
+// Spilled reports whether the products exceeded the memory limit and the index was spilled to disk.
+func (i *Index) Spilled() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.spilled != nil
+}
+
+// Drain emits the winning products in the order of their lines. The products of a spilled index are emitted
+// one partition at a time, in the order of their lines within the partition.
+func (i *Index) Drain(ctx context.Context, emit func(product model.Product) error) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.spilled == nil {
+		return i.emit(ctx, emit)
+	}
+	for p := 0; p < i.spilled.Len(); p++ {
+		i.entries = make(map[int]entry)
+		err := i.spilled.Read(p, func(record string) error {
+			var e entry
+			if err := json.Unmarshal([]byte(record), &e); err != nil {
+				return fmt.Errorf("dedup: unmarshal partition record: %w", err)
+			}
+			i.keep(e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := i.emit(ctx, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the spill files.
+func (i *Index) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.spilled == nil {
+		return nil
+	}
+	return i.spilled.Close()
+}
+
+// emit emits the entries in memory in the order of their lines.
+func (i *Index) emit(ctx context.Context, emit func(product model.Product) error) error {
+	entries := make([]entry, 0, len(i.entries))
+	for _, e := range i.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Seq < entries[b].Seq
+	})
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		e.Product.Source = e.Source
+		if err := emit(e.Product); err != nil {
+			return err
+		}
+	}
+	i.entries = nil
+	return nil
+}
+
+// keep keeps the entry if it wins over the entry of the same product id in memory.
+func (i *Index) keep(e entry) {
+	current, ok := i.entries[e.Product.ID]
+	if ok {
+		i.duplicates++
+		if !i.wins(e, current) {
+			return
+		}
+	}
+	i.entries[e.Product.ID] = e
+}
+
+// wins reports whether the entry wins over the current entry of the same product id.
+func (i *Index) wins(e, current entry) bool {
+	switch i.dedup.Policy {
+	case config.DedupFirst:
+		return e.Seq < current.Seq
+	case config.DedupMax:
+		value, currentValue := i.value(e.Product), i.value(current.Product)
+		if value != currentValue {
+			return value > currentValue
+		}
+	}
+	return e.Seq >= current.Seq
+}
+
+// value returns the field of the product that is compared by the max policy.
+func (i *Index) value(product model.Product) float64 {
+	if i.dedup.Field == config.DedupFieldTs {
+		return float64(product.Ts)
+	}
+	return product.Price
+}
+
+// spill creates the partition files and writes the entries in memory to them.
+func (i *Index) spill() error {
+	spilled, err := spill.New(i.dedup.SpillDir, "dedup-*", partitions)
+	if err != nil {
+		return fmt.Errorf("dedup: %w", err)
+	}
+	i.spilled = spilled
+	for _, e := range i.entries {
+		if err := i.write(e); err != nil {
+			return err
+		}
+	}
+	i.entries = nil
+	return nil
+}
+
+func (i *Index) write(e entry) error {
+	record, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("dedup: marshal partition record: %w", err)
+	}
+	return i.spilled.Write(e.Product.ID, string(record))
+}
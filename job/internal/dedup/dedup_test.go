@@ -0,0 +1,115 @@
+package dedup_test
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/dedup"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	// the products are in the order of their lines, and the sequence number of a product is its index.
+	products := []model.Product{
+		{ID: 1, Title: "first", Price: 10, Ts: 3, Source: "test"},
+		{ID: 2, Title: "first", Price: 5, Ts: 1, Source: "test"},
+		{ID: 1, Title: "second", Price: 30, Ts: 1, Source: "test"},
+		{ID: 3, Title: "first", Price: 1, Ts: 1, Source: "test"},
+		{ID: 1, Title: "third", Price: 20, Ts: 2, Source: "test"},
+		{ID: 2, Title: "second", Price: 5, Ts: 1, Source: "test"},
+	}
+	tests := []struct {
+		name   string
+		dedup  config.Dedup
+		titles map[int]string
+	}{
+		{name: "first", dedup: config.Dedup{Policy: config.DedupFirst}, titles: map[int]string{1: "first", 2: "first", 3: "first"}},
+		{name: "last", dedup: config.Dedup{Policy: config.DedupLast}, titles: map[int]string{1: "third", 2: "second", 3: "first"}},
+		{name: "max price", dedup: config.Dedup{Policy: config.DedupMax, Field: config.DedupFieldPrice}, titles: map[int]string{1: "second", 2: "second", 3: "first"}},
+		{name: "max ts", dedup: config.Dedup{Policy: config.DedupMax, Field: config.DedupFieldTs}, titles: map[int]string{1: "first", 2: "second", 3: "first"}},
+	}
+	for _, tt := range tests {
+		for _, memoryRecords := range []int{100, 1} {
+			tt.dedup.MemoryRecords = memoryRecords
+			tt.dedup.SpillDir = t.TempDir()
+			t.Run(tt.name, func(t *testing.T) {
+				index := dedup.New(tt.dedup)
+				defer index.Close()
+				// the products are added concurrently in a random order, like the parser workers add them.
+				wg := sync.WaitGroup{}
+				for _, seq := range rand.Perm(len(products)) {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := index.Add(int64(seq), products[seq]); err != nil {
+							t.Errorf("Add() error = %v", err)
+						}
+					}()
+				}
+				wg.Wait()
+				titles := make(map[int]string)
+				var seqs []int
+				err := index.Drain(context.Background(), func(product model.Product) error {
+					titles[product.ID] = product.Title
+					for seq, p := range products {
+						if p.ID == product.ID && p.Title == product.Title {
+							seqs = append(seqs, seq)
+						}
+					}
+					if product.Source != "test" {
+						t.Errorf("source of %d = %q, want test", product.ID, product.Source)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("Drain() error = %v", err)
+				}
+				if !reflect.DeepEqual(titles, tt.titles) {
+					t.Errorf("winners = %v, want %v", titles, tt.titles)
+				}
+				if memoryRecords > 1 && !sort.IntsAreSorted(seqs) {
+					t.Errorf("emitted lines = %v, want the order of the lines", seqs)
+				}
+				if got := index.Duplicates(); got != 3 {
+					t.Errorf("Duplicates() = %d, want 3", got)
+				}
+				if got := index.Spilled(); got != (memoryRecords == 1) {
+					t.Errorf("Spilled() = %v, want %v", got, memoryRecords == 1)
+				}
+			})
+		}
+	}
+}
+
+func TestIndex_NegativeIDs(t *testing.T) {
+	products := []model.Product{
+		{ID: -1, Title: "first"},
+		{ID: math.MinInt, Title: "first"},
+		{ID: -1, Title: "second"},
+		{ID: math.MinInt, Title: "second"},
+	}
+	// a single record in memory spills the index, so the negative ids are written to the partition files.
+	index := dedup.New(config.Dedup{Policy: config.DedupLast, MemoryRecords: 1, SpillDir: t.TempDir()})
+	defer index.Close()
+	for seq, product := range products {
+		if err := index.Add(int64(seq), product); err != nil {
+			t.Fatalf("Add(%d) error = %v", product.ID, err)
+		}
+	}
+	titles := make(map[int]string)
+	if err := index.Drain(context.Background(), func(product model.Product) error {
+		titles[product.ID] = product.Title
+		return nil
+	}); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	want := map[int]string{-1: "second", math.MinInt: "second"}
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("winners = %v, want %v", titles, want)
+	}
+}
@@ -0,0 +1,75 @@
+package errclass
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net"
+)
+
+// Class is the class of a write error. It decides whether the write is retried.
+type Class string
+
+const (
+	// Retryable is a server error that the server labels as safe to retry, such as a write during a replica set election.
+	Retryable Class = "retryable"
+	// Network is a connection error between the job and the database.
+	Network Class = "network"
+	// Timeout is a write that did not finish in time.
+	Timeout Class = "timeout"
+	// Duplicate is a write of an ID that already exists or of an event older than the stored one.
+	Duplicate Class = "duplicate"
+	// Validation is a document that the schema validation of the collection rejects.
+	Validation Class = "validation"
+	// Permanent is any other error. Writing the same document again fails the same way.
+	Permanent Class = "permanent"
+)
+
+// Server labels and codes of the retryable and validation errors.
+const (
+	labelRetryableWrite       = "RetryableWriteError"
+	labelTransientTransaction = "TransientTransactionError"
+	codeDocumentValidation    = 121
+)
+
+// Retryable reports whether a write that failed with an error of the class can succeed if it is retried.
+func (c Class) Retryable() bool {
+	return c == Retryable || c == Network || c == Timeout
+}
+
+// Classify returns the class of the write error. It looks through the wrapped errors,
+// so the custom errors of the storages are classified by the database error they wrap.
+func Classify(err error) Class {
+	var ce *customerror.Error
+	if errors.As(err, &ce) && (ce.Message == constant.ErrIDExists || ce.Message == constant.ErrStaleEvent) {
+		return Duplicate
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return Duplicate
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		if se.HasErrorCode(codeDocumentValidation) {
+			return Validation
+		}
+		if se.HasErrorLabel(labelRetryableWrite) || se.HasErrorLabel(labelTransientTransaction) {
+			return Retryable
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+		return Timeout
+	}
+	if mongo.IsNetworkError(err) {
+		return Network
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return Timeout
+		}
+		return Network
+	}
+	return Permanent
+}
@@ -0,0 +1,93 @@
+package errclass_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/errclass"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	wrap := func(err error) error {
+		return customerror.New(constant.ErrCreateProduct, true).Wrap(fmt.Errorf("productstorage: failed to write batch: %w", err))
+	}
+	tests := []struct {
+		name          string
+		err           error
+		want          errclass.Class
+		wantRetryable bool
+	}{
+		{
+			name:          "retryable write label",
+			err:           wrap(mongo.CommandError{Code: 189, Labels: []string{"RetryableWriteError"}}),
+			want:          errclass.Retryable,
+			wantRetryable: true,
+		},
+		{
+			name:          "transient transaction label",
+			err:           wrap(mongo.CommandError{Code: 112, Labels: []string{"TransientTransactionError"}}),
+			want:          errclass.Retryable,
+			wantRetryable: true,
+		},
+		{
+			name:          "network error",
+			err:           wrap(mongo.CommandError{Labels: []string{"NetworkError"}}),
+			want:          errclass.Network,
+			wantRetryable: true,
+		},
+		{
+			name:          "connection refused",
+			err:           wrap(&net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+			want:          errclass.Network,
+			wantRetryable: true,
+		},
+		{
+			name:          "timeout",
+			err:           wrap(context.DeadlineExceeded),
+			want:          errclass.Timeout,
+			wantRetryable: true,
+		},
+		{
+			name: "duplicate key",
+			err: wrap(mongo.WriteException{WriteErrors: mongo.WriteErrors{
+				{Code: 11000, Message: "E11000 duplicate key error"},
+			}}),
+			want: errclass.Duplicate,
+		},
+		{
+			name: "id exists",
+			err:  customerror.New(constant.ErrIDExists, false),
+			want: errclass.Duplicate,
+		},
+		{
+			name: "stale event",
+			err:  customerror.New(constant.ErrStaleEvent, false),
+			want: errclass.Duplicate,
+		},
+		{
+			name: "document validation",
+			err: wrap(mongo.BulkWriteError{WriteError: mongo.WriteError{
+				Code: 121, Message: "Document failed validation",
+			}}),
+			want: errclass.Validation,
+		},
+		{
+			name: "other error",
+			err:  wrap(errors.New("unknown error")),
+			want: errclass.Permanent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errclass.Classify(tt.err)
+			if got != tt.want || got.Retryable() != tt.wantRetryable {
+				t.Errorf("Classify() = %v (retryable %v), want %v (retryable %v)", got, got.Retryable(), tt.want, tt.wantRetryable)
+			}
+		})
+	}
+}
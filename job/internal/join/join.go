@@ -0,0 +1,263 @@
+package join
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/spill"
+	"strconv"
+	"strings"
+)
+
+// partitions is the number of partition files of each side of a spilled join.
+const partitions = 16
+
+// productKey is the field of the primary lines that holds the product id.
+const productKey = "id"
+
+// Stats are the counters of a join.
+type Stats struct {
+	// Unmatched is the number of primary lines without a match in every source.
+	// They are emitted as they are by a left join and dropped by an inner join.
+	Unmatched int64
+	// Rejected is the number of secondary lines without a valid key. They are not joined.
+	Rejected int64
+	// Spilled reports whether the secondary lines exceeded the memory limit and the join was spilled to disk.
+	Spilled bool
+}
+
+// Joiner joins the lines of the primary object with the lines of the secondary objects by product id.
+// The secondary lines are added first, then the primary lines are probed. The fields of the secondary lines
+// are merged into the primary line, and the fields of a later source override the fields of an earlier one.
+// If a source has more than one line for a product id, the last line is joined.
+//
+// The secondary lines are kept in memory up to the MemoryRecords of the join. Above it, the secondary lines and
+// then the primary lines are written to partition files by product id, and Drain joins the partitions one at a time.
+// A spilled join emits the primary lines grouped by partition, so only the lines of the same product keep their order.
+// A Joiner is not safe for concurrent use.
+type Joiner struct {
+	join  config.Join
+	stats Stats
+
+	// records are the fields of the secondary lines of each product id, indexed by source.
+	records map[int][]json.RawMessage
+	count   int
+
+	secondary *spill.Partitions
+	primary   *spill.Partitions
+}
+
+// New returns a joiner of the join. Close must be called to remove the spill files.
+func New(join config.Join) *Joiner {
+	return &Joiner{
+		join:    join,
+		records: make(map[int][]json.RawMessage),
+	}
+}
+
+// Stats returns the counters of the join.
+func (j *Joiner) Stats() Stats {
+	return j.stats
+}
+
+// Add adds a line of the secondary object at the source index of the join.
+// A line without a valid key is counted as rejected and not joined.
+func (j *Joiner) Add(source int, line string) error {
+	id, fields, err := parse(line, j.join.Sources[source].Key)
+	if err != nil {
+		j.stats.Rejected++
+		return nil
+	}
+	// the key fields of the secondary line are not merged, so they cannot change the product id of the primary line.
+	delete(fields, j.join.Sources[source].Key)
+	delete(fields, productKey)
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("join: marshal line of %s: %w", j.join.Sources[source].ObjectKey, err)
+	}
+	if j.secondary != nil {
+		return j.secondary.Write(id, strconv.Itoa(source)+"\t"+strconv.Itoa(id)+"\t"+string(value))
+	}
+	j.store(source, id, value)
+	if j.count > j.join.MemoryRecords {
+		return j.spill()
+	}
+	return nil
+}
+
+// Probe joins a line of the primary object and emits the joined line. The line is emitted as it is if it is not
+// a JSON object, so it is rejected by the parser. If the join is spilled, the line is written to its partition
+// and emitted by Drain.
+func (j *Joiner) Probe(line string, emit func(line string) error) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return emit(line)
+	}
+	id, ok := key(fields, productKey)
+	if ok && j.primary != nil {
+		return j.primary.Write(id, strconv.Itoa(id)+"\t"+line)
+	}
+	return j.probe(id, ok, line, fields, emit)
+}
+
+// Drain emits the joined lines of a spilled join one partition at a time. It does nothing if the join is not spilled.
+func (j *Joiner) Drain(ctx context.Context, emit func(line string) error) error {
+	if j.primary == nil {
+		return nil
+	}
+	for p := 0; p < partitions; p++ {
+		j.records = make(map[int][]json.RawMessage)
+		err := j.secondary.Read(p, func(record string) error {
+			parts := strings.SplitN(record, "\t", 3)
+			if len(parts) != 3 {
+				return errors.New("join: corrupt secondary partition record")
+			}
+			source, _ := strconv.Atoi(parts[0])
+			id, _ := strconv.Atoi(parts[1])
+			j.store(source, id, json.RawMessage(parts[2]))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = j.primary.Read(p, func(record string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			idPart, line, ok := strings.Cut(record, "\t")
+			if !ok {
+				return errors.New("join: corrupt primary partition record")
+			}
+			id, _ := strconv.Atoi(idPart)
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(line), &fields); err != nil {
+				return fmt.Errorf("join: unmarshal primary partition record: %w", err)
+			}
+			return j.probe(id, true, line, fields, emit)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	j.records = nil
+	return nil
+}
+
+// Close closes and removes the spill files.
+func (j *Joiner) Close() error {
+	var errs []error
+	if j.secondary != nil {
+		errs = append(errs, j.secondary.Close())
+	}
+	if j.primary != nil {
+		errs = append(errs, j.primary.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// probe merges the fields of the secondary lines of the product id into the fields of the primary line.
+func (j *Joiner) probe(id int, ok bool, line string, fields map[string]json.RawMessage, emit func(line string) error) error {
+	var records []json.RawMessage
+	if ok {
+		records = j.records[id]
+	}
+	matched := len(records) > 0
+	for _, record := range records {
+		if record == nil {
+			matched = false
+		}
+	}
+	if !matched {
+		j.stats.Unmatched++
+		if j.join.Type == config.JoinInner {
+			return nil
+		}
+		if records == nil {
+			return emit(line)
+		}
+	}
+	for _, record := range records {
+		var sourceFields map[string]json.RawMessage
+		if record == nil || json.Unmarshal(record, &sourceFields) != nil {
+			continue
+		}
+		for k, v := range sourceFields {
+			fields[k] = v
+		}
+	}
+	joined, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("join: marshal joined line: %w", err)
+	}
+	return emit(string(joined))
+}
+
+// store keeps the fields of a secondary line in memory.
+func (j *Joiner) store(source, id int, value json.RawMessage) {
+	records, ok := j.records[id]
+	if !ok {
+		records = make([]json.RawMessage, len(j.join.Sources))
+		j.records[id] = records
+	}
+	if records[source] == nil {
+		j.count++
+	}
+	records[source] = value
+}
+
+// spill creates the partition files and writes the secondary lines in memory to them.
+func (j *Joiner) spill() error {
+	var err error
+	if j.secondary, err = spill.New(j.join.SpillDir, "join-secondary-*", partitions); err != nil {
+		return fmt.Errorf("join: %w", err)
+	}
+	if j.primary, err = spill.New(j.join.SpillDir, "join-primary-*", partitions); err != nil {
+		return fmt.Errorf("join: %w", err)
+	}
+	j.stats.Spilled = true
+	for id, records := range j.records {
+		for source, value := range records {
+			if value == nil {
+				continue
+			}
+			if err := j.secondary.Write(id, strconv.Itoa(source)+"\t"+strconv.Itoa(id)+"\t"+string(value)); err != nil {
+				return err
+			}
+		}
+	}
+	j.records, j.count = nil, 0
+	return nil
+}
+
+// parse returns the product id in the key field of a line and the fields of the line.
+func parse(line, field string) (int, map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return 0, nil, err
+	}
+	id, ok := key(fields, field)
+	if !ok {
+		return 0, nil, errors.New("join: invalid key " + field)
+	}
+	return id, fields, nil
+}
+
+// key returns the product id in the field. The id can be a number or a string of a number.
+func key(fields map[string]json.RawMessage, field string) (int, bool) {
+	raw, ok := fields[field]
+	if !ok {
+		return 0, false
+	}
+	var id int
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id, true
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(s)
+	return id, err == nil
+}
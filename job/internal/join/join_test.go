@@ -0,0 +1,188 @@
+package join_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/join"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestJoiner(t *testing.T) {
+	primary := []string{
+		`{"id":1,"title":"Phone","price":10}`,
+		`{"id":2,"title":"Laptop"}`,
+		`{"id":3,"title":"Tablet"}`,
+		`not json`,
+	}
+	prices := []string{
+		`{"product_id":1,"price":12}`,
+		`{"product_id":"2","price":20}`,
+		`{"product_id":2,"price":25}`,
+		`{"price":30}`,
+	}
+	stock := []string{
+		`{"id":1,"brand":"acme"}`,
+		`{"id":2,"brand":"globex"}`,
+		`{"id":3,"brand":"initech"}`,
+	}
+	tests := []struct {
+		name          string
+		joinType      string
+		memoryRecords int
+		want          []string
+		wantUnmatched int64
+		wantSpilled   bool
+	}{
+		{
+			name:          "left",
+			joinType:      config.JoinLeft,
+			memoryRecords: 100,
+			want: []string{
+				`not json`,
+				`{"brand":"acme","id":1,"price":12,"title":"Phone"}`,
+				`{"brand":"globex","id":2,"price":25,"title":"Laptop"}`,
+				`{"brand":"initech","id":3,"title":"Tablet"}`,
+			},
+			wantUnmatched: 1,
+		},
+		{
+			name:          "inner",
+			joinType:      config.JoinInner,
+			memoryRecords: 100,
+			want: []string{
+				`not json`,
+				`{"brand":"acme","id":1,"price":12,"title":"Phone"}`,
+				`{"brand":"globex","id":2,"price":25,"title":"Laptop"}`,
+			},
+			wantUnmatched: 1,
+		},
+		{
+			name:          "spilled left",
+			joinType:      config.JoinLeft,
+			memoryRecords: 1,
+			want: []string{
+				`not json`,
+				`{"brand":"acme","id":1,"price":12,"title":"Phone"}`,
+				`{"brand":"globex","id":2,"price":25,"title":"Laptop"}`,
+				`{"brand":"initech","id":3,"title":"Tablet"}`,
+			},
+			wantUnmatched: 1,
+			wantSpilled:   true,
+		},
+		{
+			name:          "spilled inner",
+			joinType:      config.JoinInner,
+			memoryRecords: 1,
+			want: []string{
+				`not json`,
+				`{"brand":"acme","id":1,"price":12,"title":"Phone"}`,
+				`{"brand":"globex","id":2,"price":25,"title":"Laptop"}`,
+			},
+			wantUnmatched: 1,
+			wantSpilled:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spillDir := t.TempDir()
+			joiner := join.New(config.Join{
+				Type: tt.joinType,
+				Sources: []config.JoinSource{
+					{ObjectKey: "prices.jsonl", Key: "product_id"},
+					{ObjectKey: "stock.jsonl", Key: "id"},
+				},
+				MemoryRecords: tt.memoryRecords,
+				SpillDir:      spillDir,
+			})
+			for source, lines := range [][]string{prices, stock} {
+				for _, line := range lines {
+					if err := joiner.Add(source, line); err != nil {
+						t.Fatalf("Add() error = %v", err)
+					}
+				}
+			}
+			var got []string
+			emit := func(line string) error {
+				got = append(got, line)
+				return nil
+			}
+			for _, line := range primary {
+				if err := joiner.Probe(line, emit); err != nil {
+					t.Fatalf("Probe() error = %v", err)
+				}
+			}
+			if err := joiner.Drain(context.Background(), emit); err != nil {
+				t.Fatalf("Drain() error = %v", err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("joined lines = %v, want %v", got, tt.want)
+			}
+			want := join.Stats{Unmatched: tt.wantUnmatched, Rejected: 1, Spilled: tt.wantSpilled}
+			if stats := joiner.Stats(); stats != want {
+				t.Errorf("Stats() = %+v, want %+v", stats, want)
+			}
+			if err := joiner.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+				t.Errorf("spill files = %d, want 0 after Close()", len(entries))
+			}
+		})
+	}
+}
+
+func TestJoiner_NegativeIDs(t *testing.T) {
+	primary := []string{
+		`{"id":-1,"title":"Phone"}`,
+		`{"id":-9223372036854775808,"title":"Laptop"}`,
+	}
+	prices := []string{
+		`{"product_id":-1,"price":10}`,
+		`{"product_id":"-9223372036854775808","price":20}`,
+	}
+	want := []string{
+		`{"id":-1,"price":10,"title":"Phone"}`,
+		`{"id":-9223372036854775808,"price":20,"title":"Laptop"}`,
+	}
+	for _, memoryRecords := range []int{100, 1} {
+		t.Run(fmt.Sprintf("memory records %d", memoryRecords), func(t *testing.T) {
+			joiner := join.New(config.Join{
+				Type:          config.JoinInner,
+				Sources:       []config.JoinSource{{ObjectKey: "prices.jsonl", Key: "product_id"}},
+				MemoryRecords: memoryRecords,
+				SpillDir:      t.TempDir(),
+			})
+			defer joiner.Close()
+			for _, line := range prices {
+				if err := joiner.Add(0, line); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			var got []string
+			emit := func(line string) error {
+				got = append(got, line)
+				return nil
+			}
+			for _, line := range primary {
+				if err := joiner.Probe(line, emit); err != nil {
+					t.Fatalf("Probe() error = %v", err)
+				}
+			}
+			if err := joiner.Drain(context.Background(), emit); err != nil {
+				t.Fatalf("Drain() error = %v", err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("joined lines = %v, want %v", got, want)
+			}
+			if stats := joiner.Stats(); stats.Unmatched != 0 || stats.Rejected != 0 {
+				t.Errorf("Stats() = %+v, want the negative ids matched", stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Default returns the migrations of the collections of the database config.
+// A new schema change is a new migration with the next version. An applied migration must not be changed.
+func Default(dbConfig config.Database) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create product id, product source, object info etag and history indexes",
+			Steps: []Step{
+				CreateIndexes(dbConfig.ProductCollection,
+					mongo.IndexModel{Keys: bson.M{"id": 1}, Options: options.Index().SetUnique(true)},
+					mongo.IndexModel{Keys: bson.M{"source": 1}},
+				),
+				CreateIndexes(dbConfig.ObjectInfoCollection,
+					mongo.IndexModel{Keys: bson.M{"etag": 1}, Options: options.Index().SetUnique(true)},
+				),
+				CreateIndexes(dbConfig.HistoryCollection,
+					mongo.IndexModel{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "archived_at", Value: 1}}},
+				),
+			},
+		},
+		{
+			Version:     2,
+			Description: "create product category and text indexes",
+			Steps: []Step{
+				CreateIndexes(dbConfig.ProductCollection,
+					mongo.IndexModel{Keys: bson.M{"category": 1}},
+					mongo.IndexModel{
+						Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}},
+						Options: options.Index().SetName("product_text"),
+					},
+				),
+			},
+		},
+		{
+			Version:     3,
+			Description: "set product collection validator",
+			Steps: []Step{
+				SetValidator(dbConfig.ProductCollection, productSchema),
+			},
+		},
+		{
+			Version:     4,
+			Description: "backfill product content hash",
+			Steps: []Step{
+				Backfill(dbConfig.ProductCollection,
+					bson.M{"hash": bson.M{"$exists": false}},
+					func(document bson.Raw) (bson.M, error) {
+						var product model.Product
+						if err := bson.Unmarshal(document, &product); err != nil {
+							return nil, err
+						}
+						return bson.M{"hash": product.ContentHash()}, nil
+					},
+				),
+			},
+		},
+	}
+}
+
+// productSchema is the $jsonSchema of the product collection. Only the ID is required, since a merge writes
+// only the non-empty fields of a new product.
+var productSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"id"},
+	"properties": bson.M{
+		"id":          bson.M{"bsonType": bson.A{"int", "long"}},
+		"title":       bson.M{"bsonType": "string"},
+		"price":       bson.M{"bsonType": bson.A{"double", "int", "long", "decimal"}, "minimum": 0},
+		"category":    bson.M{"bsonType": "string"},
+		"brand":       bson.M{"bsonType": "string"},
+		"url":         bson.M{"bsonType": "string"},
+		"description": bson.M{"bsonType": "string"},
+		"hash":        bson.M{"bsonType": "string"},
+		"ts":          bson.M{"bsonType": bson.A{"int", "long"}},
+		"source":      bson.M{"bsonType": "string"},
+		"deleted_at":  bson.M{"bsonType": "date"},
+	},
+}
@@ -0,0 +1,23 @@
+package migration_test
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/migration"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	migrations := migration.Default(config.Database{
+		ProductCollection:    "products",
+		ObjectInfoCollection: "objectinfo",
+		HistoryCollection:    "product_history",
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("version of migration %d = %d, want %d", i, m.Version, i+1)
+		}
+		if m.Description == "" || len(m.Steps) == 0 {
+			t.Errorf("migration %d has no description or steps", m.Version)
+		}
+	}
+}
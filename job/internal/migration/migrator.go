@@ -0,0 +1,173 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// DefaultCollection is the default collection of the applied migrations.
+const DefaultCollection = "migrations"
+
+// Step is a schema change of a migration. Steps must be idempotent, since a migration whose record
+// could not be written is applied again by the next run.
+type Step func(ctx context.Context, db *mongo.Database) error
+
+// Migration is a versioned list of steps. The migrations are applied in the order of their versions.
+type Migration struct {
+	Version     int
+	Description string
+	Steps       []Step
+}
+
+// record is the document of an applied migration in the migrations collection.
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Status is the state of a migration. AppliedAt is zero if the migration is pending.
+type Status struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+}
+
+// Applied reports whether the migration is applied.
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// Migrator applies the migrations to a database and records the applied versions in the migrations collection.
+type Migrator struct {
+	db             *mongo.Database
+	collectionName string
+	migrations     []Migration
+	logger         *slog.Logger
+}
+
+type Option func(*Migrator)
+
+func WithDB(db *mongo.Database) Option {
+	return func(m *Migrator) {
+		m.db = db
+	}
+}
+
+func WithCollection(collection string) Option {
+	return func(m *Migrator) {
+		m.collectionName = collection
+	}
+}
+
+func WithMigrations(migrations []Migration) Option {
+	return func(m *Migrator) {
+		m.migrations = migrations
+	}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Migrator) {
+		m.logger = logger
+	}
+}
+
+func New(opts ...Option) *Migrator {
+	m := &Migrator{
+		collectionName: DefaultCollection,
+		logger:         slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return m
+}
+
+// Up method applies the pending migrations in the order of their versions and returns the applied versions.
+// It stops at the first failed migration, so a later migration never runs without the earlier ones.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.apply(ctx, migration); err != nil {
+			return versions, err
+		}
+		versions = append(versions, migration.Version)
+		m.logger.Info(fmt.Sprintf("Applied migration %d: %s", migration.Version, migration.Description))
+	}
+	return versions, nil
+}
+
+// Status method returns the state of each migration in the order of their versions.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   applied[migration.Version].AppliedAt,
+		}
+	}
+	return statuses, nil
+}
+
+// applied returns the records of the applied migrations by version.
+func (m *Migrator) applied(ctx context.Context) (map[int]record, error) {
+	cursor, err := m.db.Collection(m.collectionName).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, customerror.New(constant.ErrFindMigrations, true).
+			Wrap(fmt.Errorf("migration: failed to find migrations: %w", err)).AddData("err: " + err.Error())
+	}
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, customerror.New(constant.ErrFindMigrations, true).
+			Wrap(fmt.Errorf("migration: failed to decode migrations: %w", err)).AddData("err: " + err.Error())
+	}
+	applied := make(map[int]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// apply runs the steps of the migration and records it as applied.
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	for i, step := range migration.Steps {
+		if err := step(ctx, m.db); err != nil {
+			return customerror.New(constant.ErrApplyMigration, true).
+				Wrap(fmt.Errorf("migration: failed to apply step %d of migration %d: %w", i+1, migration.Version, err)).
+				AddData(fmt.Sprintf("version: %d, err: %s", migration.Version, err.Error()))
+		}
+	}
+	if _, err := m.db.Collection(m.collectionName).ReplaceOne(ctx,
+		bson.M{"_id": migration.Version},
+		record{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()},
+		options.Replace().SetUpsert(true),
+	); err != nil {
+		return customerror.New(constant.ErrApplyMigration, true).
+			Wrap(fmt.Errorf("migration: failed to record migration %d: %w", migration.Version, err)).
+			AddData(fmt.Sprintf("version: %d, err: %s", migration.Version, err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package migration_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/migration"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+// countingStep returns a step that counts its calls and fails with err.
+func countingStep(calls *int, err error) migration.Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		*calls++
+		return err
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success Up", func(mt *mtest.T) {
+		var first, second, third int
+		migrator := migration.New(
+			migration.WithDB(mt.DB),
+			migration.WithMigrations([]migration.Migration{
+				{Version: 3, Description: "third", Steps: []migration.Step{countingStep(&third, nil)}},
+				{Version: 1, Description: "first", Steps: []migration.Step{countingStep(&first, nil)}},
+				{Version: 2, Description: "second", Steps: []migration.Step{countingStep(&second, nil)}},
+			}),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.migrations", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: 1}, {Key: "description", Value: "first"}, {Key: "applied_at", Value: time.Now()}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: 2}}}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: 3}}}}),
+		)
+		versions, err := migrator.Up(context.TODO())
+		assert.Nil(t, err)
+		assert.Equal(t, []int{2, 3}, versions)
+		assert.Equal(t, []int{0, 1, 1}, []int{first, second, third})
+	})
+
+	mt.Run("Case Step Error", func(mt *mtest.T) {
+		var first, second int
+		migrator := migration.New(
+			migration.WithDB(mt.DB),
+			migration.WithMigrations([]migration.Migration{
+				{Version: 1, Description: "first", Steps: []migration.Step{countingStep(&first, errors.New("step error"))}},
+				{Version: 2, Description: "second", Steps: []migration.Step{countingStep(&second, nil)}},
+			}),
+		)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.migrations", mtest.FirstBatch))
+		versions, err := migrator.Up(context.TODO())
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrApplyMigration, ce.Message)
+		}
+		assert.Empty(t, versions)
+		assert.Equal(t, 0, second, "a migration should not run after a failed migration")
+	})
+
+	mt.Run("Case Find Error", func(mt *mtest.T) {
+		migrator := migration.New(migration.WithDB(mt.DB))
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		_, err := migrator.Up(context.TODO())
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrFindMigrations, ce.Message)
+		}
+	})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success Status", func(mt *mtest.T) {
+		migrator := migration.New(
+			migration.WithDB(mt.DB),
+			migration.WithMigrations([]migration.Migration{
+				{Version: 1, Description: "first"},
+				{Version: 2, Description: "second"},
+			}),
+		)
+		appliedAt := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.migrations", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: 1}, {Key: "description", Value: "first"}, {Key: "applied_at", Value: appliedAt}},
+		))
+		statuses, err := migrator.Status(context.TODO())
+		assert.Nil(t, err)
+		if assert.Len(t, statuses, 2) {
+			assert.True(t, statuses[0].Applied())
+			assert.Equal(t, appliedAt, statuses[0].AppliedAt.UTC())
+			assert.False(t, statuses[1].Applied())
+			assert.Equal(t, "second", statuses[1].Description)
+		}
+	})
+}
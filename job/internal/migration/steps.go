@@ -0,0 +1,90 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// codeNamespaceNotFound is the server error code of a collection that does not exist.
+const codeNamespaceNotFound = 26
+
+// backfillBatchSize is the number of documents updated with a single bulk write of a backfill.
+const backfillBatchSize = 500
+
+// CreateIndexes returns a step that creates the indexes on the collection. Creating an existing index is a no-op.
+func CreateIndexes(collection string, models ...mongo.IndexModel) Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+		return err
+	}
+}
+
+// SetValidator returns a step that sets the $jsonSchema validator of the collection. If the collection does not exist,
+// it is created with the validator. The validation level is moderate, so the existing documents that do not match
+// the schema can still be updated, while every insert and every update of a valid document is validated.
+func SetValidator(collection string, schema bson.M) Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		validator := bson.M{"$jsonSchema": schema}
+		err := db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection},
+			{Key: "validator", Value: validator},
+			{Key: "validationLevel", Value: "moderate"},
+			{Key: "validationAction", Value: "error"},
+		}).Err()
+		var ce mongo.CommandError
+		if errors.As(err, &ce) && ce.Code == codeNamespaceNotFound {
+			return db.CreateCollection(ctx, collection, options.CreateCollection().
+				SetValidator(validator).
+				SetValidationLevel("moderate").
+				SetValidationAction("error"))
+		}
+		return err
+	}
+}
+
+// Backfill returns a step that sets the fields returned by fn on each document of the collection that matches the filter.
+// The filter must not match the backfilled documents, so the step can be applied again after a failure.
+// If fn returns no fields, the document is not updated.
+func Backfill(collection string, filter bson.M, fn func(document bson.Raw) (bson.M, error)) Step {
+	return func(ctx context.Context, db *mongo.Database) error {
+		coll := db.Collection(collection)
+		cursor, err := coll.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		models := make([]mongo.WriteModel, 0, backfillBatchSize)
+		flush := func() error {
+			if len(models) == 0 {
+				return nil
+			}
+			_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+			models = models[:0]
+			return err
+		}
+		for cursor.Next(ctx) {
+			fields, err := fn(cursor.Current)
+			if err != nil {
+				return err
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": cursor.Current.Lookup("_id")}).
+				SetUpdate(bson.M{"$set": fields}))
+			if len(models) == backfillBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return err
+		}
+		return flush()
+	}
+}
@@ -0,0 +1,66 @@
+package migration_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/migration"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func TestSetValidator(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	schema := bson.M{"bsonType": "object", "required": bson.A{"id"}}
+
+	mt.Run("Case Existing Collection", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := migration.SetValidator("products", schema)(context.TODO(), mt.DB)
+		assert.Nil(t, err)
+		event := mt.GetStartedEvent()
+		assert.Equal(t, "collMod", event.CommandName)
+		assert.Equal(t, "moderate", event.Command.Lookup("validationLevel").StringValue())
+	})
+
+	mt.Run("Case Missing Collection", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 26, Name: "NamespaceNotFound", Message: "ns does not exist"}),
+			mtest.CreateSuccessResponse(),
+		)
+		err := migration.SetValidator("products", schema)(context.TODO(), mt.DB)
+		assert.Nil(t, err)
+		mt.GetStartedEvent()
+		event := mt.GetStartedEvent()
+		assert.Equal(t, "create", event.CommandName)
+		assert.NotNil(t, event.Command.Lookup("validator", "$jsonSchema"))
+	})
+}
+
+func TestBackfill(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success Backfill", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: 1}, {Key: "id", Value: 1}, {Key: "title", Value: "a"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "id", Value: 2}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "id", Value: 3}, {Key: "title", Value: "c"}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+		)
+		step := migration.Backfill("products", bson.M{"upper": bson.M{"$exists": false}}, func(document bson.Raw) (bson.M, error) {
+			title, ok := document.Lookup("title").StringValueOK()
+			if !ok {
+				return nil, nil
+			}
+			return bson.M{"upper": title + title}, nil
+		})
+		err := step(context.TODO(), mt.DB)
+		assert.Nil(t, err)
+		mt.GetStartedEvent()
+		event := mt.GetStartedEvent()
+		assert.Equal(t, "update", event.CommandName)
+		updates, _ := event.Command.Lookup("updates").Array().Values()
+		assert.Len(t, updates, 2)
+	})
+}
@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"strconv"
+	"time"
+)
+
+// kafkaPublisher publishes the events keyed by product ID, so the events of a product go to the same partition in order.
+// The writer waits for all in-sync replicas to acknowledge the events.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(brokers []string, topic string) *kafkaPublisher {
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+// Publish method writes the events with a single call. The event ID is sent in the event-id header.
+func (p *kafkaPublisher) Publish(ctx context.Context, events []model.OutboxEvent) error {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("outbox: failed to encode event: %w", err)
+		}
+		messages[i] = kafka.Message{
+			Key:     []byte(strconv.Itoa(event.ProductID)),
+			Value:   data,
+			Headers: []kafka.Header{{Key: "event-id", Value: []byte(event.UID.Hex())}},
+		}
+	}
+	if err := p.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("outbox: failed to publish events to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close method flushes and closes the writer.
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"strconv"
+)
+
+// natsPublisher publishes each event to the subject of its product on JetStream and waits for the acknowledgement,
+// so an event is published only after the previous events are stored. The event ID is the message ID,
+// so the stream drops the events that are published again within its duplicate window.
+type natsPublisher struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject string
+}
+
+func newNATSPublisher(url, subject string) (*natsPublisher, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: failed to connect to nats: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("outbox: failed to create jetstream context: %w", err)
+	}
+	return &natsPublisher{conn: conn, js: js, subject: subject}, nil
+}
+
+// Publish method publishes the events to the <subject>.<product id> subjects in order.
+func (p *natsPublisher) Publish(ctx context.Context, events []model.OutboxEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("outbox: failed to encode event: %w", err)
+		}
+		subject := p.subject + "." + strconv.Itoa(event.ProductID)
+		if _, err := p.js.Publish(ctx, subject, data, jetstream.WithMsgID(event.UID.Hex())); err != nil {
+			return fmt.Errorf("outbox: failed to publish event to nats: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close method drains the connection.
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
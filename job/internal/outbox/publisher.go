@@ -0,0 +1,36 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"os"
+)
+
+// Publisher publishes the outbox events to a broker. The events of a call must be delivered in the given order
+// for each product ID. Publish returns an error unless all events are accepted by the broker.
+type Publisher interface {
+	Publish(ctx context.Context, events []model.OutboxEvent) error
+	Close() error
+}
+
+// NewPublisher returns the publisher of the outbox config.
+func NewPublisher(cfg config.Outbox) (Publisher, error) {
+	switch cfg.Publisher {
+	case config.PublisherStdout:
+		return newWriterPublisher(os.Stdout, nil), nil
+	case config.PublisherFile:
+		file, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("outbox: failed to open file: %w", err)
+		}
+		return newWriterPublisher(file, file), nil
+	case config.PublisherNATS:
+		return newNATSPublisher(cfg.NATSURL, cfg.NATSSubject)
+	case config.PublisherKafka:
+		return newKafkaPublisher(cfg.KafkaBrokers, cfg.KafkaTopic), nil
+	default:
+		return nil, fmt.Errorf("outbox: unknown publisher %q", cfg.Publisher)
+	}
+}
@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/outboxstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"time"
+)
+
+// Relay publishes the unpublished outbox events in the order they are written and marks them as published.
+// An event is marked only after it is published, so the delivery is at-least-once. If a batch fails,
+// none of its events are marked and the batch is published again, so the events of a product are never reordered.
+// A single relay must run for an outbox collection.
+type Relay struct {
+	storage      outboxstorage.OutboxStorer
+	publisher    Publisher
+	logger       *slog.Logger
+	pollInterval time.Duration
+	batchSize    int
+}
+
+type Option func(*Relay)
+
+func WithStorage(storage outboxstorage.OutboxStorer) Option {
+	return func(r *Relay) {
+		r.storage = storage
+	}
+}
+
+func WithPublisher(publisher Publisher) Option {
+	return func(r *Relay) {
+		r.publisher = publisher
+	}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Relay) {
+		r.logger = logger
+	}
+}
+
+// WithPollInterval sets the interval between the polls of the outbox when there is nothing to publish.
+func WithPollInterval(interval time.Duration) Option {
+	return func(r *Relay) {
+		r.pollInterval = interval
+	}
+}
+
+// WithBatchSize sets the maximum number of events published at once.
+func WithBatchSize(size int) Option {
+	return func(r *Relay) {
+		r.batchSize = size
+	}
+}
+
+func NewRelay(opts ...Option) *Relay {
+	r := &Relay{
+		logger:       slog.Default(),
+		pollInterval: time.Second,
+		batchSize:    100,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run method relays the events until the context is done. A full batch is followed by the next batch immediately,
+// otherwise the relay waits for the poll interval. Errors are logged and the batch is retried after the poll interval.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error(err.Error())
+		}
+		if err == nil && n == r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// Drain method relays the events until the outbox is empty or an error occurs.
+func (r *Relay) Drain(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			return err
+		}
+		if n < r.batchSize {
+			return nil
+		}
+	}
+}
+
+// RelayOnce method publishes a batch of unpublished events and marks them as published. It returns the number of published events.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	events, err := r.storage.FindUnpublished(ctx, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if err := r.publisher.Publish(ctx, events); err != nil {
+		return 0, customerror.New(constant.ErrPublishEvents, true).
+			Wrap(fmt.Errorf("outbox: failed to publish events: %w", err)).AddData("err: " + err.Error())
+	}
+	ids := make([]primitive.ObjectID, len(events))
+	for i, event := range events {
+		ids[i] = event.UID
+	}
+	if err := r.storage.MarkPublished(ctx, ids); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
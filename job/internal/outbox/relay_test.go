@@ -0,0 +1,104 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/outbox"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"testing"
+)
+
+type mockOutboxStorage struct {
+	mu        sync.Mutex
+	events    []model.OutboxEvent
+	published map[primitive.ObjectID]bool
+}
+
+func newMockOutboxStorage(n int) *mockOutboxStorage {
+	m := &mockOutboxStorage{published: make(map[primitive.ObjectID]bool)}
+	for i := 0; i < n; i++ {
+		m.events = append(m.events, model.OutboxEvent{UID: primitive.NewObjectID(), Type: model.EventProductUpserted, ProductID: i % 2})
+	}
+	return m
+}
+
+func (m *mockOutboxStorage) CreateIndex(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockOutboxStorage) FindUnpublished(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []model.OutboxEvent
+	for _, event := range m.events {
+		if len(events) == limit {
+			break
+		}
+		if !m.published[event.UID] {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *mockOutboxStorage) MarkPublished(ctx context.Context, ids []primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		m.published[id] = true
+	}
+	return nil
+}
+
+type mockPublisher struct {
+	published []model.OutboxEvent
+	failures  int
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, events []model.OutboxEvent) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("broker unavailable")
+	}
+	m.published = append(m.published, events...)
+	return nil
+}
+
+func (m *mockPublisher) Close() error {
+	return nil
+}
+
+func TestRelay_Drain(t *testing.T) {
+	storage := newMockOutboxStorage(5)
+	publisher := &mockPublisher{}
+	relay := outbox.NewRelay(
+		outbox.WithStorage(storage),
+		outbox.WithPublisher(publisher),
+		outbox.WithBatchSize(2),
+	)
+	assert.Nil(t, relay.Drain(context.TODO()))
+	assert.Equal(t, storage.events, publisher.published, "events are published once in the order they are written")
+	assert.Len(t, storage.published, 5)
+}
+
+func TestRelay_RelayOnce_PublishError(t *testing.T) {
+	storage := newMockOutboxStorage(3)
+	publisher := &mockPublisher{failures: 1}
+	relay := outbox.NewRelay(
+		outbox.WithStorage(storage),
+		outbox.WithPublisher(publisher),
+		outbox.WithBatchSize(10),
+	)
+	n, err := relay.RelayOnce(context.TODO())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Empty(t, storage.published, "failed events are not marked")
+
+	n, err = relay.RelayOnce(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, storage.events, publisher.published)
+}
@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"io"
+	"sync"
+)
+
+// writerPublisher writes each event as a JSON line. It is used to test the consumers of the events without a broker.
+type writerPublisher struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func newWriterPublisher(w io.Writer, closer io.Closer) *writerPublisher {
+	return &writerPublisher{w: w, closer: closer}
+}
+
+// Publish method writes the events with a single write.
+func (p *writerPublisher) Publish(ctx context.Context, events []model.OutboxEvent) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("outbox: failed to encode event: %w", err)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("outbox: failed to write events: %w", err)
+	}
+	return nil
+}
+
+// Close method closes the underlying writer if it is closable.
+func (p *writerPublisher) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
@@ -0,0 +1,42 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/outbox"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	publisher, err := outbox.NewPublisher(config.Outbox{Publisher: config.PublisherFile, FilePath: path})
+	if !assert.Nil(t, err) {
+		return
+	}
+	id := primitive.NewObjectID()
+	err = publisher.Publish(context.TODO(), []model.OutboxEvent{
+		{UID: id, Type: model.EventProductUpserted, ProductID: 1, Product: &model.Product{ID: 1, Title: "a"}},
+		{UID: primitive.NewObjectID(), Type: model.EventProductDeleted, ProductID: 2},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, publisher.Close())
+
+	content, err := os.ReadFile(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 2) {
+		var event map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(lines[0]), &event))
+		assert.Equal(t, id.Hex(), event["id"])
+		assert.Equal(t, "product.upserted", event["type"])
+	}
+}
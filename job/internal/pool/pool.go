@@ -0,0 +1,106 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Pool is a fixed number of workers that run the tasks of all the objects that share it,
+// so the number of goroutines does not grow with the number of objects in flight.
+type Pool struct {
+	tasks chan func()
+	wg    sync.WaitGroup
+	size  int
+}
+
+// New starts a pool of size workers. A size less than one starts one worker.
+func New(size int) *Pool {
+	p := &Pool{
+		tasks: make(chan func()),
+		size:  max(size, 1),
+	}
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Size method returns the number of workers of the pool.
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Close method stops the workers after the submitted tasks are done. No task can be submitted after Close.
+func (p *Pool) Close() {
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+// Group is the tasks of a single object in a pool. It limits the tasks of the object running at once,
+// tracks their completion and keeps the first error. A panicking task fails only its own group,
+// so a failing object neither stops the workers nor the other objects.
+type Group struct {
+	pool *Pool
+	sem  chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+}
+
+// Group method returns a group whose tasks run at most limit at once. A limit less than one is one.
+func (p *Pool) Group(limit int) *Group {
+	return &Group{
+		pool: p,
+		sem:  make(chan struct{}, max(limit, 1)),
+	}
+}
+
+// Go method submits the task to the pool. It waits until the group is below its limit and a worker is free.
+// It returns the error of the context if the context is done first, the task is not run then.
+func (g *Group) Go(ctx context.Context, fn func() error) error {
+	select {
+	case g.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	g.wg.Add(1)
+	task := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				g.setErr(fmt.Errorf("pool: task panicked: %v", r))
+			}
+			<-g.sem
+			g.wg.Done()
+		}()
+		if err := fn(); err != nil {
+			g.setErr(err)
+		}
+	}
+	select {
+	case g.pool.tasks <- task:
+		return nil
+	case <-ctx.Done():
+		<-g.sem
+		g.wg.Done()
+		return ctx.Err()
+	}
+}
+
+// Wait method waits until the submitted tasks of the group are done and returns the first error of them.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	return g.err
+}
+
+func (g *Group) setErr(err error) {
+	g.once.Do(func() {
+		g.err = err
+	})
+}
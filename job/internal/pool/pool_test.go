@@ -0,0 +1,113 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroup_Limit(t *testing.T) {
+	p := pool.New(8)
+	defer p.Close()
+	tests := []struct {
+		name  string
+		limit int
+		want  int64
+	}{
+		{name: "limited by group", limit: 2, want: 2},
+		{name: "limited by pool", limit: 20, want: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := p.Group(tt.limit)
+			var running, peak atomic.Int64
+			for i := 0; i < 40; i++ {
+				if err := g.Go(context.Background(), func() error {
+					n := running.Add(1)
+					for {
+						old := peak.Load()
+						if n <= old || peak.CompareAndSwap(old, n) {
+							break
+						}
+					}
+					time.Sleep(2 * time.Millisecond)
+					running.Add(-1)
+					return nil
+				}); err != nil {
+					t.Fatalf("Go() error = %v", err)
+				}
+			}
+			if err := g.Wait(); err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+			if peak.Load() != tt.want {
+				t.Errorf("peak running tasks = %d, want %d", peak.Load(), tt.want)
+			}
+		})
+	}
+}
+
+func TestGroup_ErrorIsolation(t *testing.T) {
+	p := pool.New(2)
+	defer p.Close()
+	errTask := errors.New("task error")
+	failed, panicked, healthy := p.Group(2), p.Group(2), p.Group(2)
+	var done atomic.Int64
+	wg := sync.WaitGroup{}
+	for _, g := range []*pool.Group{failed, panicked, healthy} {
+		wg.Add(1)
+		go func(g *pool.Group) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_ = g.Go(context.Background(), func() error {
+					switch {
+					case g == failed && i == 3:
+						return errTask
+					case g == panicked && i == 5:
+						panic("bad line")
+					case g == healthy:
+						done.Add(1)
+					}
+					return nil
+				})
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := failed.Wait(); !errors.Is(err, errTask) {
+		t.Errorf("failed.Wait() = %v, want %v", err, errTask)
+	}
+	if err := panicked.Wait(); err == nil || !strings.Contains(err.Error(), "bad line") {
+		t.Errorf("panicked.Wait() = %v, want panic error", err)
+	}
+	if err := healthy.Wait(); err != nil || done.Load() != 10 {
+		t.Errorf("healthy.Wait() = %v with %d tasks done, want nil with 10 tasks done", err, done.Load())
+	}
+}
+
+func TestGroup_ContextDone(t *testing.T) {
+	p := pool.New(1)
+	defer p.Close()
+	g := p.Group(1)
+	block := make(chan struct{})
+	if err := g.Go(context.Background(), func() error {
+		<-block
+		return nil
+	}); err != nil {
+		t.Fatalf("Go() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Go(ctx, func() error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Go() = %v, want %v", err, context.Canceled)
+	}
+	close(block)
+	if err := g.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package retry
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"math/rand/v2"
+	"time"
+)
+
+// Do calls fn until it succeeds, the attempts of the policy are used up or the context is done.
+// The backoff between the attempts doubles after each attempt up to the MaxBackoff of the policy.
+// It returns the number of retries and the error of the last attempt.
+func Do(ctx context.Context, policy config.Retry, fn func(ctx context.Context) error) (int, error) {
+	var err error
+	for attempt := 0; attempt < max(policy.MaxAttempts, 1); attempt++ {
+		if attempt > 0 {
+			if Sleep(ctx, Backoff(policy, attempt)) != nil {
+				return attempt - 1, err
+			}
+		}
+		if err = fn(ctx); err == nil {
+			return attempt, nil
+		}
+	}
+	return max(policy.MaxAttempts, 1) - 1, err
+}
+
+// Backoff returns the wait before the given retry of the policy. The first retry waits the Backoff of the policy
+// and each retry doubles it up to MaxBackoff. The Jitter fraction of the wait is randomized.
+func Backoff(policy config.Retry, retry int) time.Duration {
+	backoff := policy.Backoff
+	for i := 1; i < retry && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 {
+		backoff = min(backoff, policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 && backoff > 0 {
+		spread := time.Duration(float64(backoff) * min(policy.Jitter, 1))
+		backoff -= time.Duration(rand.Int64N(int64(spread) + 1))
+	}
+	return backoff
+}
+
+// Sleep waits for the duration. It returns the error of the context if the context is done first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
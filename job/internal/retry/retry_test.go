@@ -0,0 +1,81 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/retry"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	errWrite := errors.New("write error")
+	policy := config.Retry{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	tests := []struct {
+		name        string
+		failures    int
+		wantRetries int
+		wantErr     error
+		wantCalls   int
+	}{
+		{name: "first attempt succeeds", failures: 0, wantRetries: 0, wantCalls: 1},
+		{name: "succeeds after retries", failures: 2, wantRetries: 2, wantCalls: 3},
+		{name: "attempts are used up", failures: 5, wantRetries: 2, wantErr: errWrite, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			retries, err := retry.Do(context.Background(), policy, func(ctx context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return errWrite
+				}
+				return nil
+			})
+			if retries != tt.wantRetries || !errors.Is(err, tt.wantErr) || calls != tt.wantCalls {
+				t.Errorf("Do() = %d, %v with %d calls, want %d, %v with %d calls", retries, err, calls, tt.wantRetries, tt.wantErr, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDo_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errWrite := errors.New("write error")
+	calls := 0
+	_, err := retry.Do(ctx, config.Retry{MaxAttempts: 3, Backoff: time.Hour, MaxBackoff: time.Hour}, func(ctx context.Context) error {
+		calls++
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) || calls != 1 {
+		t.Errorf("Do() = %v with %d calls, want %v with 1 call", err, calls, errWrite)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := config.Retry{Backoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+	tests := []struct {
+		name   string
+		retry  int
+		jitter float64
+		min    time.Duration
+		max    time.Duration
+	}{
+		{name: "first retry", retry: 1, min: 10 * time.Millisecond, max: 10 * time.Millisecond},
+		{name: "doubles", retry: 2, min: 20 * time.Millisecond, max: 20 * time.Millisecond},
+		{name: "capped", retry: 5, min: 40 * time.Millisecond, max: 40 * time.Millisecond},
+		{name: "jitter", retry: 2, jitter: 0.5, min: 10 * time.Millisecond, max: 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy.Jitter = tt.jitter
+			for i := 0; i < 100; i++ {
+				if got := retry.Backoff(policy, tt.retry); got < tt.min || got > tt.max {
+					t.Fatalf("Backoff() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
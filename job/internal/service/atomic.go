@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"strconv"
+	"time"
+)
+
+// writer returns the storage that the products are written to.
+// It is the staging storage if the S3 object is loaded atomically, otherwise the product storage.
+// Reads like change detection and snapshot sync always use the product storage.
+func (s *service) writer() productstorage.ProductStorer {
+	if s.stagingStorage != nil {
+		return s.stagingStorage
+	}
+	return s.productStorage
+}
+
+// prepareStaging creates the staging storage of the run.
+func (s *service) prepareStaging(ctx context.Context) error {
+	s.runID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	staging, err := s.productStorage.Staging(ctx, s.runID)
+	if err != nil {
+		return err
+	}
+	s.stagingStorage = staging
+	return nil
+}
+
+// finishStaging promotes the staging collection if the run succeeded, otherwise drops it.
+// The history of the changed products is written after the promotion, so a dropped load leaves no history.
+// It returns the error of the run, or the promote or history error if the run succeeded.
+func (s *service) finishStaging(ctx context.Context, runErr error) error {
+	if runErr != nil {
+		if err := s.productStorage.DropStaging(ctx, s.runID); err != nil {
+			s.logError(err)
+		}
+		return runErr
+	}
+	start := time.Now()
+	defer func() {
+		s.report.stageDone("promote", time.Since(start))
+	}()
+	var err error
+	if s.s3Data.Atomic.Promote == config.PromoteRename {
+		err = s.productStorage.RenameStaging(ctx, s.runID)
+	} else {
+		err = s.productStorage.MergeStaging(ctx, s.runID, whenMatched(s.s3Data.WriteMode))
+	}
+	if err != nil {
+		if dropErr := s.productStorage.DropStaging(ctx, s.runID); dropErr != nil {
+			s.logError(dropErr)
+		}
+		return err
+	}
+	s.logger.Info(fmt.Sprintf("Promoted staging collection of %s with %s", s.s3Data.ObjectKey, s.s3Data.Atomic.Promote))
+	return s.writeStagedHistory(ctx)
+}
+
+// createStaged inserts the products of an atomic load into the staging collection. The promotion keeps the products
+// that are already in the product collection, so the products whose ID is stored are not staged. They get ErrIDExists
+// and are counted as duplicates, like the products of an insert into the product collection.
+func (s *service) createStaged(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	ids := make([]int, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	stored, err := s.productStorage.FindByIDs(ctx, ids)
+	if err != nil {
+		result := productstorage.BatchResult{Errors: make(map[int]error, len(products))}
+		for i := range products {
+			result.Errors[i] = err
+		}
+		return result
+	}
+	errs := make(map[int]error)
+	staged := make([]model.Product, 0, len(products))
+	indexes := make([]int, 0, len(products))
+	for i, product := range products {
+		if _, ok := stored[product.ID]; ok {
+			errs[i] = customerror.New(constant.ErrIDExists, false).
+				Wrap(fmt.Errorf("service.createStaged: product %d exists", product.ID)).AddData(product.ID)
+			continue
+		}
+		staged = append(staged, product)
+		indexes = append(indexes, i)
+	}
+	if len(staged) == 0 {
+		return productstorage.BatchResult{Errors: errs}
+	}
+	result := s.stagingStorage.CreateBatch(ctx, staged)
+	// the errors of the staged products are keyed by their index in the products.
+	for i, err := range result.Errors {
+		errs[indexes[i]] = err
+	}
+	result.Errors = errs
+	return result
+}
+
+// whenMatched returns how a stored product is changed by the staged product of the write mode.
+func whenMatched(writeMode string) string {
+	switch writeMode {
+	case config.WriteModeReplace:
+		return productstorage.WhenMatchedReplace
+	case config.WriteModeMerge:
+		return productstorage.WhenMatchedMerge
+	default:
+		return productstorage.WhenMatchedKeepExisting
+	}
+}
@@ -4,14 +4,24 @@ import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/dedup"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/deadletterstorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/throttle"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/filter"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/transform"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Service interface {
-	Run() error
+	Run(ctx context.Context) error
+	Report() model.Report
 	CheckIfBucketExists(ctx context.Context) error
 	CheckIfObjectExists(ctx context.Context) error
 	CheckObjectDuplicateAndCreate(ctx context.Context, out *s3.GetObjectOutput) error
@@ -32,12 +42,36 @@ type service struct {
 	s3Client               S3Client
 	logger                 *slog.Logger
 	productStorage         productstorage.ProductStorer
+	stagingStorage         productstorage.ProductStorer
+	runID                  string
 	objectInfoStorage      objectinfostorage.ObjectInfoStorer
 	s3OutChan              chan *s3.GetObjectOutput
 	lineChan               chan string
 	productChan            chan model.Product
 	lineHandlerWorkerCount int
 	dbWriteWorkerCount     int
+	batchSize              int
+	batchBytes             int
+	flushInterval          time.Duration
+	flushDeadline          time.Duration
+	dryRun                 bool
+	report                 *reportCollector
+	seen                   *seenIDs
+	etag                   string
+	sinks                  []*secondary
+	deadLetterStorage      deadletterstorage.DeadLetterStorer
+	retriesUsed            atomic.Int64
+	limiter                *throttle.Limiter
+	parserPool             *pool.Pool
+	writerPool             *pool.Pool
+	transformer            transform.Transformer
+	filter                 *filter.Filter
+	// dedup is the dedup index of the run. It is nil if the object has no dedup.
+	dedup *dedup.Index
+	// stagedHistory is the product history of an atomic load. It is written after the staging collection is promoted,
+	// so a dropped load does not record changes that never reached the product collection.
+	historyMu     sync.Mutex
+	stagedHistory []model.ProductHistory
 }
 
 type Option func(*service)
@@ -96,6 +130,91 @@ func WithDBWriteWorkerCount(count int) Option {
 	}
 }
 
+// WithFlushDeadline sets how long the batches in flight are written after the run is cancelled.
+func WithFlushDeadline(d time.Duration) Option {
+	return func(s *service) {
+		s.flushDeadline = d
+	}
+}
+
+// WithDryRun runs the object without writing its object info record. The product storage of a dry run
+// must only simulate the writes, so the report shows what a real run would write.
+func WithDryRun(dryRun bool) Option {
+	return func(s *service) {
+		s.dryRun = dryRun
+	}
+}
+
+// WithParserPool sets the pool that parses the lines of the object. The pool is shared with the other objects,
+// and lineHandlerWorkerCount limits the parse tasks of this object running at once.
+// If it is not set, the object parses its lines with a private pool of lineHandlerWorkerCount workers.
+func WithParserPool(p *pool.Pool) Option {
+	return func(s *service) {
+		s.parserPool = p
+	}
+}
+
+// WithWriterPool sets the pool that writes the batches of the object. The pool is shared with the other objects,
+// and dbWriteWorkerCount limits the batch writes of this object running at once.
+// If it is not set, the object writes its batches with a private pool of dbWriteWorkerCount workers.
+func WithWriterPool(p *pool.Pool) Option {
+	return func(s *service) {
+		s.writerPool = p
+	}
+}
+
+// WithTransformer sets the transformer that is applied to each decoded product before it is written.
+// The transformer is called by the parser workers, so it is applied concurrently.
+func WithTransformer(t transform.Transformer) Option {
+	return func(s *service) {
+		s.transformer = t
+	}
+}
+
+// WithFilter sets the filter expression that a decoded product must match to be loaded.
+// The products that do not match are counted as filtered in the report.
+func WithFilter(f *filter.Filter) Option {
+	return func(s *service) {
+		s.filter = f
+	}
+}
+
+// WithBatchSize sets the maximum number of products written with a single bulk write.
+func WithBatchSize(size int) Option {
+	return func(s *service) {
+		s.batchSize = size
+	}
+}
+
+// WithBatchBytes sets the approximate maximum size in bytes of a single bulk write.
+func WithBatchBytes(size int) Option {
+	return func(s *service) {
+		s.batchBytes = size
+	}
+}
+
+// WithFlushInterval sets the interval after which a non-empty batch is written even if it is not full.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *service) {
+		s.flushInterval = interval
+	}
+}
+
+// WithDeadLetterStorage sets the storage of the products that could not be written.
+// Without it, the failed products are only logged and counted in the report.
+func WithDeadLetterStorage(deadLetterStorage deadletterstorage.DeadLetterStorer) Option {
+	return func(s *service) {
+		s.deadLetterStorage = deadLetterStorage
+	}
+}
+
+// WithLimiter sets the limiter of the product writes. The limiter is shared by the services of all S3 objects.
+func WithLimiter(limiter *throttle.Limiter) Option {
+	return func(s *service) {
+		s.limiter = limiter
+	}
+}
+
 func WithLogger(logger *slog.Logger) Option {
 	return func(s *service) {
 		s.logger = logger
@@ -103,7 +222,10 @@ func WithLogger(logger *slog.Logger) Option {
 }
 
 func New(opts ...Option) Service {
-	s := &service{}
+	s := &service{
+		report: newReportCollector(),
+		seen:   newSeenIDs(),
+	}
 	for _, opt := range opts {
 		opt(s)
 	}
@@ -3,8 +3,22 @@ package service_test
 import (
 	"context"
 	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/service"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -12,12 +26,40 @@ var (
 	errProductStorageCreateIndex    = errors.New("product storage create index error")
 	errObjectInfoStorageCreate      = errors.New("object info storage create error")
 	errObjectInfoStorageCreateIndex = errors.New("object info storage create index error")
+	errRetryableWrite               = mongo.CommandError{Code: 189, Message: "primary stepped down", Labels: []string{"RetryableWriteError"}}
 )
 
 type mockProductStorage struct {
 	createIndexErr error
 	createErr      error
 	createBatchErr error
+	// transientFailures is the number of batch writes that fail with a retryable error before the writes succeed.
+	transientFailures int
+	batchCalls        int
+	// writeDelay is the latency of each batch write. The peak of the concurrent batch writes is recorded.
+	writeDelay   time.Duration
+	inFlight     int
+	peakInFlight int
+
+	mu         sync.Mutex
+	batchSizes []int
+	modes      []string
+	written    []model.Product
+
+	stored           map[int]model.Product
+	findErr          error
+	createHistoryErr error
+	history          []model.ProductHistory
+
+	sourceIDs  []int
+	deletedIDs []int
+	hardDelete bool
+	tombstones []model.Product
+	staleIDs   map[int]bool
+
+	staging  *mockProductStorage
+	promoted string
+	dropped  bool
 }
 
 func (m *mockProductStorage) CreateIndex(ctx context.Context) error {
@@ -28,9 +70,123 @@ func (m *mockProductStorage) Create(ctx context.Context, product model.Product)
 	return m.createErr
 }
 
+func (m *mockProductStorage) CreateBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return m.batch(config.WriteModeInsert, products)
+}
+
+func (m *mockProductStorage) ReplaceBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return m.batch(config.WriteModeReplace, products)
+}
+
+func (m *mockProductStorage) MergeBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return m.batch(config.WriteModeMerge, products)
+}
+
+func (m *mockProductStorage) batch(mode string, products []model.Product) productstorage.BatchResult {
+	m.mu.Lock()
+	m.batchSizes = append(m.batchSizes, len(products))
+	m.modes = append(m.modes, mode)
+	m.batchCalls++
+	transient := m.batchCalls <= m.transientFailures
+	m.inFlight++
+	m.peakInFlight = max(m.peakInFlight, m.inFlight)
+	m.mu.Unlock()
+	time.Sleep(m.writeDelay)
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+	result := productstorage.BatchResult{Errors: make(map[int]error)}
+	for i, product := range products {
+		if transient {
+			result.Errors[i] = customerror.New(constant.ErrCreateProduct, true).Wrap(errRetryableWrite)
+			continue
+		}
+		m.mu.Lock()
+		m.written = append(m.written, product)
+		m.mu.Unlock()
+		if m.createBatchErr != nil {
+			result.Errors[i] = m.createBatchErr
+			continue
+		}
+		if m.staleIDs[product.ID] {
+			result.Errors[i] = customerror.New(constant.ErrStaleEvent, false)
+			continue
+		}
+		result.Inserted++
+	}
+	return result
+}
+
+func (m *mockProductStorage) DeleteBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	m.mu.Lock()
+	m.tombstones = append(m.tombstones, products...)
+	m.mu.Unlock()
+	return productstorage.BatchResult{Deleted: int64(len(products)), Errors: make(map[int]error)}
+}
+
+func (m *mockProductStorage) FindByIDs(ctx context.Context, ids []int) (map[int]model.Product, error) {
+	if m.findErr != nil {
+		return nil, m.findErr
+	}
+	products := make(map[int]model.Product)
+	for _, id := range ids {
+		if product, ok := m.stored[id]; ok {
+			products[id] = product
+		}
+	}
+	return products, nil
+}
+
+func (m *mockProductStorage) CreateHistory(ctx context.Context, history []model.ProductHistory) error {
+	if m.createHistoryErr != nil {
+		return m.createHistoryErr
+	}
+	m.mu.Lock()
+	m.history = append(m.history, history...)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *mockProductStorage) FindIDsBySource(ctx context.Context, source string) ([]int, error) {
+	return m.sourceIDs, nil
+}
+
+func (m *mockProductStorage) DeleteByIDs(ctx context.Context, ids []int, hard bool) (int64, error) {
+	m.deletedIDs = append(m.deletedIDs, ids...)
+	m.hardDelete = hard
+	return int64(len(ids)), nil
+}
+
+func (m *mockProductStorage) Staging(ctx context.Context, runID string) (productstorage.ProductStorer, error) {
+	m.staging = &mockProductStorage{}
+	return m.staging, nil
+}
+
+func (m *mockProductStorage) MergeStaging(ctx context.Context, runID string, whenMatched string) error {
+	m.promoted = config.PromoteMerge + ":" + whenMatched
+	return nil
+}
+
+func (m *mockProductStorage) RenameStaging(ctx context.Context, runID string) error {
+	m.promoted = config.PromoteRename
+	return nil
+}
+
+func (m *mockProductStorage) DropStaging(ctx context.Context, runID string) error {
+	m.dropped = true
+	return nil
+}
+
 type mockObjectInfoStorage struct {
-	createIndexErr error
-	createErr      error
+	createIndexErr  error
+	createErr       error
+	updateReportErr error
+	report          model.Report
+	status          string
+	// interrupted makes Resume resume the object once.
+	interrupted bool
 }
 
 func (m *mockObjectInfoStorage) CreateIndex(ctx context.Context) error {
@@ -41,6 +197,34 @@ func (m *mockObjectInfoStorage) Create(ctx context.Context, objectPartition mode
 	return m.createErr
 }
 
+func (m *mockObjectInfoStorage) Finish(ctx context.Context, etag string, status string, report model.Report) error {
+	m.status = status
+	m.report = report
+	return m.updateReportErr
+}
+
+func (m *mockObjectInfoStorage) Resume(ctx context.Context, etag string) (bool, error) {
+	resumed := m.interrupted
+	m.interrupted = false
+	return resumed, nil
+}
+
+type mockDeadLetterStorage struct {
+	mu          sync.Mutex
+	deadLetters []model.DeadLetter
+}
+
+func (m *mockDeadLetterStorage) CreateIndex(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockDeadLetterStorage) CreateBatch(ctx context.Context, deadLetters []model.DeadLetter) error {
+	m.mu.Lock()
+	m.deadLetters = append(m.deadLetters, deadLetters...)
+	m.mu.Unlock()
+	return nil
+}
+
 type mockS3Client struct {
 	mockHeadBucket func(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
 	mockHeadObject func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
@@ -58,3 +242,63 @@ func (m *mockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInpu
 func (m *mockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
 	return m.mockGetObject(ctx, params)
 }
+
+type mockSink struct {
+	mu       sync.Mutex
+	written  []model.Product
+	failures int
+	calls    int
+	release  chan struct{}
+}
+
+func (m *mockSink) Write(ctx context.Context, products []model.Product) error {
+	if m.release != nil {
+		<-m.release
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if m.calls <= m.failures {
+		return errors.New("sink write error")
+	}
+	m.written = append(m.written, products...)
+	return nil
+}
+
+func (m *mockSink) Close() error {
+	return nil
+}
+
+// newRunService returns a service that reads the body as the content of the S3 object.
+// The options are applied after the default options of the test service.
+func newRunService(body string, s3Data config.S3, productStorage productstorage.ProductStorer, objectInfoStorage objectinfostorage.ObjectInfoStorer, opts ...service.Option) service.Service {
+	return service.New(append([]service.Option{
+		service.WithS3Data(s3Data),
+		service.WithS3Client(&mockS3Client{
+			mockHeadBucket: func(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+				return &s3.HeadBucketOutput{}, nil
+			},
+			mockHeadObject: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+				return &s3.HeadObjectOutput{}, nil
+			},
+			mockGetObject: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+				return &s3.GetObjectOutput{
+					Body:          io.NopCloser(strings.NewReader(body)),
+					ContentType:   new(string),
+					ContentLength: new(int64),
+					ETag:          aws.String("etag"),
+				}, nil
+			},
+		}),
+		service.WithProductStorage(productStorage),
+		service.WithObjectInfoStorage(objectInfoStorage),
+		service.WithS3OutChan(make(chan *s3.GetObjectOutput, 1)),
+		service.WithLineChannel(make(chan string, 10)),
+		service.WithProductChannel(make(chan model.Product, 10)),
+		service.WithLineHandlerWorkerCount(2),
+		service.WithDBWriteWorkerCount(2),
+		service.WithLogger(slog.New(
+			slog.NewJSONHandler(os.Stdout, nil),
+		)),
+	}, opts...)...)
+}
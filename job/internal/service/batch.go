@@ -0,0 +1,47 @@
+package service
+
+import "github.com/yigithankarabulut/asyncs3todbloader/job/model"
+
+// productOverhead is the approximate BSON size of a product without its string fields.
+const productOverhead = 64
+
+// batch accumulates products until it reaches its count or byte limit.
+// A limit that is less than or equal to zero is ignored.
+type batch struct {
+	products []model.Product
+	size     int
+	maxCount int
+	maxBytes int
+}
+
+func newBatch(maxCount, maxBytes int) *batch {
+	if maxCount < 1 {
+		maxCount = 1
+	}
+	return &batch{
+		products: make([]model.Product, 0, maxCount),
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+	}
+}
+
+// add appends the product to the batch and reports whether the batch is full.
+func (b *batch) add(product model.Product) bool {
+	b.products = append(b.products, product)
+	b.size += estimateSize(product)
+	return len(b.products) >= b.maxCount || (b.maxBytes > 0 && b.size >= b.maxBytes)
+}
+
+// take returns the accumulated products and resets the batch.
+func (b *batch) take() []model.Product {
+	products := b.products
+	b.products = make([]model.Product, 0, b.maxCount)
+	b.size = 0
+	return products
+}
+
+// estimateSize returns the approximate BSON size of the product.
+func estimateSize(product model.Product) int {
+	return productOverhead + len(product.Title) + len(product.Category) + len(product.Brand) +
+		len(product.Url) + len(product.Description)
+}
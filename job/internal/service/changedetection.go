@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"time"
+)
+
+// detectChanges compares the content hash of each product with the stored one and returns only the changed products.
+// Unchanged products are counted in the report. The previous version of each changed product is appended to the history collection.
+// The history of an atomic load is kept until the staging collection is promoted.
+// If the stored products cannot be read or the history cannot be appended, the affected products are counted as write errors and dead-lettered.
+func (s *service) detectChanges(ctx context.Context, products []model.Product) []model.Product {
+	ids := make([]int, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	stored, err := s.productStorage.FindByIDs(ctx, ids)
+	if err != nil {
+		s.failWrites(ctx, products, err)
+		return nil
+	}
+
+	now := time.Now()
+	changed := make([]model.Product, 0, len(products))
+	created := make([]model.Product, 0, len(products))
+	history := make([]model.ProductHistory, 0, len(products))
+	for _, product := range products {
+		previous, exists := stored[product.ID]
+		next := product
+		if exists && s.s3Data.WriteMode == config.WriteModeMerge {
+			next = previous.Merge(product)
+		}
+		product.Hash = next.ContentHash()
+		if !exists {
+			created = append(created, product)
+			continue
+		}
+		previousHash := previous.Hash
+		if previousHash == "" {
+			previousHash = previous.ContentHash()
+		}
+		if previousHash == product.Hash && previous.DeletedAt == nil {
+			s.report.unchanged.Add(1)
+			continue
+		}
+		changed = append(changed, product)
+		history = append(history, model.ProductHistory{
+			ProductID:    previous.ID,
+			Product:      previous,
+			SourceBucket: s.s3Data.BucketName,
+			SourceObject: s.s3Data.ObjectKey,
+			ArchivedAt:   now,
+		})
+	}
+	if s.stagingStorage != nil {
+		s.historyMu.Lock()
+		s.stagedHistory = append(s.stagedHistory, history...)
+		s.historyMu.Unlock()
+		return append(created, changed...)
+	}
+	if err := s.productStorage.CreateHistory(ctx, history); err != nil {
+		s.failWrites(ctx, changed, err)
+		return created
+	}
+	s.report.historyAppended.Add(int64(len(history)))
+	return append(created, changed...)
+}
+
+// writeStagedHistory appends the history of an atomic load to the history collection once the staging collection is promoted.
+func (s *service) writeStagedHistory(ctx context.Context) error {
+	s.historyMu.Lock()
+	history := s.stagedHistory
+	s.stagedHistory = nil
+	s.historyMu.Unlock()
+	if len(history) == 0 {
+		return nil
+	}
+	if err := s.productStorage.CreateHistory(ctx, history); err != nil {
+		return err
+	}
+	s.report.historyAppended.Add(int64(len(history)))
+	return nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+)
+
+// drainDedup sends the winning products of the dedup index to the productChan channel in the order of their lines.
+func (s *service) drainDedup(ctx context.Context) error {
+	err := s.dedup.Drain(ctx, func(product model.Product) error {
+		return s.sendProduct(ctx, product)
+	})
+	if err != nil && ctx.Err() == nil {
+		return s.dedupError(err)
+	}
+	return err
+}
+
+// closeDedup records the duplicates of the dedup index in the report and removes its spill files.
+func (s *service) closeDedup() {
+	s.report.deduplicated.Add(s.dedup.Duplicates())
+	if s.dedup.Spilled() {
+		s.logger.Info(fmt.Sprintf("Dedup of %s is spilled to disk", s.s3Data.ObjectKey))
+	}
+	if err := s.dedup.Close(); err != nil {
+		s.logger.Error(fmt.Sprintf("service.closeDedup: %v", err))
+	}
+}
+
+func (s *service) dedupError(err error) error {
+	return customerror.New(constant.ErrDedupProducts, true).
+		Wrap(fmt.Errorf("service.HandleLines: %w", err)).
+		AddData(fmt.Sprintf("bucketname: %s objectkey: %s err: %s", s.s3Data.BucketName, s.s3Data.ObjectKey, err))
+}
@@ -0,0 +1,121 @@
+package service_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/service"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqlobjectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqlproductstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/locals3"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/sqlite"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+// newSQLiteService returns a service that loads the fixture object from the testdata directory into the SQLite storages.
+func newSQLiteService(s3Data config.S3, productStorage productstorage.ProductStorer, objectInfoStorage objectinfostorage.ObjectInfoStorer) service.Service {
+	return service.New(
+		service.WithS3Data(s3Data),
+		service.WithS3Client(locals3.New(filepath.Join("..", "..", "testdata"))),
+		service.WithProductStorage(productStorage),
+		service.WithObjectInfoStorage(objectInfoStorage),
+		service.WithS3OutChan(make(chan *s3.GetObjectOutput, 1)),
+		service.WithLineChannel(make(chan string, 10)),
+		service.WithProductChannel(make(chan model.Product, 10)),
+		service.WithLineHandlerWorkerCount(2),
+		service.WithDBWriteWorkerCount(2),
+		service.WithBatchSize(2),
+		service.WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil))),
+	)
+}
+
+func TestService_Run_SQLite(t *testing.T) {
+	db, err := sqlite.ConnectSQLite(config.Database{Path: filepath.Join(t.TempDir(), "job.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	productStorage := sqlproductstorage.New(
+		sqlproductstorage.WithDB(db),
+		sqlproductstorage.WithDialect(sqldialect.SQLite),
+		sqlproductstorage.WithProductTable("products"),
+		sqlproductstorage.WithHistoryTable("product_history"),
+	)
+	objectInfoStorage := sqlobjectinfostorage.New(
+		sqlobjectinfostorage.WithDB(db),
+		sqlobjectinfostorage.WithDialect(sqldialect.SQLite),
+		sqlobjectinfostorage.WithObjectTable("objectinfo"),
+	)
+	ctx := context.TODO()
+	if err := productStorage.CreateIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := objectInfoStorage.CreateIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s3Data := config.S3{BucketName: "fixtures", ObjectKey: "products.jsonl", WriteMode: config.WriteModeInsert, Format: config.FormatProduct}
+
+	svc := newSQLiteService(s3Data, productStorage, objectInfoStorage)
+	if !assert.Nil(t, svc.Run(context.Background())) {
+		return
+	}
+	report := svc.Report()
+	assert.Equal(t, int64(5), report.LinesRead)
+	assert.Equal(t, int64(4), report.Parsed)
+	assert.Equal(t, int64(1), report.Rejected)
+	assert.Equal(t, int64(3), report.Inserted)
+	assert.Equal(t, int64(1), report.DuplicatesSkipped)
+
+	products, err := productStorage.FindByIDs(ctx, []int{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Len(t, products, 3)
+	assert.Equal(t, "Mechanical Keyboard", products[2].Title)
+
+	var stored int
+	assert.Nil(t, db.QueryRow(`SELECT count(*) FROM objectinfo WHERE report IS NOT NULL`).Scan(&stored))
+	assert.Equal(t, 1, stored)
+
+	// the same object is skipped by its ETag.
+	assert.NotNil(t, newSQLiteService(s3Data, productStorage, objectInfoStorage).Run(context.Background()))
+}
+
+func TestService_Run_SQLite_AtomicRename(t *testing.T) {
+	db, err := sqlite.ConnectSQLite(config.Database{Path: filepath.Join(t.TempDir(), "job.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	productStorage := sqlproductstorage.New(
+		sqlproductstorage.WithDB(db),
+		sqlproductstorage.WithDialect(sqldialect.SQLite),
+		sqlproductstorage.WithProductTable("products"),
+		sqlproductstorage.WithHistoryTable("product_history"),
+	)
+	if err := productStorage.CreateIndex(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	s3Data := config.S3{
+		BucketName: "fixtures",
+		ObjectKey:  "products.jsonl",
+		WriteMode:  config.WriteModeReplace,
+		Format:     config.FormatProduct,
+		Atomic:     config.Atomic{Enabled: true, Promote: config.PromoteRename},
+	}
+	// a re-load of the same file renames a staging table with every product of the file, not only the changed ones.
+	for run := 1; run <= 2; run++ {
+		if !assert.Nil(t, newSQLiteService(s3Data, productStorage, &mockObjectInfoStorage{}).Run(context.Background())) {
+			return
+		}
+		var count int
+		assert.Nil(t, db.QueryRow(`SELECT count(*) FROM products`).Scan(&count))
+		assert.Equal(t, 3, count, "products after run %d", run)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/join"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"strings"
+)
+
+// joinETag returns the ETag of the object combined with the ETags of the secondary objects of the join,
+// so the object is loaded again when only a secondary object changes.
+func (s *service) joinETag(ctx context.Context, etag string) (string, error) {
+	etags := []string{etag}
+	for _, source := range s.s3Data.Join.Sources {
+		out, err := s.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: &source.BucketName,
+			Key:    &source.ObjectKey,
+		})
+		if err != nil {
+			return "", customerror.New(constant.ErrObjectNotFound, true).
+				Wrap(fmt.Errorf("service.joinETag: %v", err)).
+				AddData(fmt.Sprintf("bucketname: %s objectkey: %s", source.BucketName, source.ObjectKey))
+		}
+		if out.ETag != nil {
+			etags = append(etags, *out.ETag)
+		}
+	}
+	return strings.Join(etags, "+"), nil
+}
+
+// newJoiner reads the lines of the secondary objects of the join into a joiner.
+func (s *service) newJoiner(ctx context.Context) (*join.Joiner, error) {
+	joiner := join.New(s.s3Data.Join)
+	for i, source := range s.s3Data.Join.Sources {
+		if err := s.addJoinSource(ctx, joiner, i, source); err != nil {
+			s.closeJoiner(joiner)
+			return nil, err
+		}
+	}
+	return joiner, nil
+}
+
+// addJoinSource adds the lines of a secondary object to the joiner.
+func (s *service) addJoinSource(ctx context.Context, joiner *join.Joiner, index int, source config.JoinSource) error {
+	out, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &source.BucketName,
+		Key:    &source.ObjectKey,
+	})
+	if err != nil {
+		return customerror.New(constant.ErrGetObjectFailed, true).
+			Wrap(fmt.Errorf("service.addJoinSource: %v", err)).
+			AddData(fmt.Sprintf("bucketname: %s objectkey: %s err: %s", source.BucketName, source.ObjectKey, err))
+	}
+	defer func() {
+		if err := out.Body.Close(); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}()
+	s.logger.Info(fmt.Sprintf("Start reading join source %s of %s", source.ObjectKey, s.s3Data.ObjectKey))
+	scanner := bufio.NewScanner(out.Body)
+	for scanner.Scan() {
+		if err := joiner.Add(index, scanner.Text()); err != nil {
+			return s.joinError(ctx, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return customerror.New(constant.ErrFileScanFailed, true).
+			Wrap(fmt.Errorf("service.addJoinSource: %v", err)).
+			AddData(fmt.Sprintf("bucketname: %s objectkey: %s line: %s", source.BucketName, source.ObjectKey, scanner.Text()))
+	}
+	return nil
+}
+
+// closeJoiner records the counters of the join in the report and removes its spill files.
+// The secondary lines without a valid key are counted as rejected.
+func (s *service) closeJoiner(joiner *join.Joiner) {
+	stats := joiner.Stats()
+	s.report.unmatched.Add(stats.Unmatched)
+	s.report.rejected.Add(stats.Rejected)
+	if stats.Spilled {
+		s.logger.Info(fmt.Sprintf("Join of %s is spilled to disk", s.s3Data.ObjectKey))
+	}
+	if err := joiner.Close(); err != nil {
+		s.logger.Error(fmt.Sprintf("service.closeJoiner: %v", err))
+	}
+}
+
+// joinError returns the error of the context if it is done, so an interrupted join is not reported as a failure.
+func (s *service) joinError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return customerror.New(constant.ErrJoinObject, true).
+		Wrap(fmt.Errorf("service.ReadDataFromS3Object: %w", err)).
+		AddData(fmt.Sprintf("bucketname: %s objectkey: %s err: %s", s.s3Data.BucketName, s.s3Data.ObjectKey, err))
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"sync"
+	"time"
+)
+
+// turn orders the products of the parsed chunks. A chunk sends its products after the previous chunk is done,
+// so the products reach the productChan channel in the order of the lines while the chunks are parsed concurrently.
+type turn struct {
+	prev <-chan struct{}
+	done chan struct{}
+}
+
+// wait waits until the previous chunk sent its products.
+func (t *turn) wait(ctx context.Context) error {
+	if t == nil || t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lane is a partition of the products by product id. It writes its batches one at a time in the order they are queued.
+type lane struct {
+	batch *batch
+	// ids are the product ids in the batch. A product whose id is already in the batch starts a new batch,
+	// since the products of a bulk write are not written in order.
+	ids   map[int]struct{}
+	queue chan []model.Product
+}
+
+// writeOrdered batches the products by partition and writes the batches of each partition one at a time,
+// so the products of the same product id are written in the order they are received.
+// The partitions are the writer workers of the object, so the batches of different partitions are written concurrently.
+func (s *service) writeOrdered(ctx, writeCtx context.Context, group *pool.Group, startProduct model.Product) error {
+	lanes := make([]*lane, max(s.dbWriteWorkerCount, 1))
+	wg := sync.WaitGroup{}
+	for i := range lanes {
+		l := &lane{
+			batch: newBatch(s.batchSize, s.batchBytes),
+			ids:   make(map[int]struct{}),
+			queue: make(chan []model.Product, 1),
+		}
+		lanes[i] = l
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for products := range l.queue {
+				s.flushInOrder(writeCtx, group, products)
+			}
+		}()
+	}
+	enqueue := func(l *lane) {
+		if products := l.batch.take(); len(products) > 0 {
+			clear(l.ids)
+			l.queue <- products
+		}
+	}
+	add := func(product model.Product) {
+		l := lanes[partition(product.ID, len(lanes))]
+		if _, ok := l.ids[product.ID]; ok {
+			enqueue(l)
+		}
+		l.ids[product.ID] = struct{}{}
+		if l.batch.add(product) {
+			enqueue(l)
+		}
+	}
+	finish := func() error {
+		for _, l := range lanes {
+			enqueue(l)
+			close(l.queue)
+		}
+		wg.Wait()
+		return group.Wait()
+	}
+
+	var tick <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	add(startProduct)
+	for {
+		select {
+		case product, ok := <-s.productChan:
+			if !ok {
+				return finish()
+			}
+			add(product)
+		case <-tick:
+			for _, l := range lanes {
+				enqueue(l)
+			}
+		case <-ctx.Done():
+			// the pending batches of the partitions are written within the flush deadline, in order.
+			// The products still in productChan are not received, they are written by the run that resumes the object.
+			if err := finish(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// flushInOrder writes the products with a writer of the pool and waits until they are written.
+func (s *service) flushInOrder(ctx context.Context, group *pool.Group, products []model.Product) {
+	done := make(chan struct{})
+	if err := group.Go(ctx, func() error {
+		defer close(done)
+		s.flush(ctx, products)
+		return nil
+	}); err != nil {
+		s.failWrites(ctx, products, err)
+		return
+	}
+	<-done
+}
+
+// partition returns the partition of the product id among n partitions.
+// The id is mixed first, so ids with a common stride are spread over the partitions.
+func partition(id, n int) int {
+	h := uint64(id) * 0x9e3779b97f4a7c15
+	return int((h >> 32) % uint64(n))
+}
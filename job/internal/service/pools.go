@@ -0,0 +1,16 @@
+package service
+
+import "github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
+
+// lineChunkSize is the number of lines parsed by a single task of the parser pool.
+const lineChunkSize = 256
+
+// ownPool returns the shared pool if it is set. Otherwise, it starts a private pool of size workers for a single stage.
+// The returned func closes the private pool and does nothing for the shared pool, which is closed by its owner.
+func ownPool(shared *pool.Pool, size int) (*pool.Pool, func()) {
+	if shared != nil {
+		return shared, func() {}
+	}
+	p := pool.New(size)
+	return p, p.Close
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reportCollector gathers the counters of a single run. Stage workers update it concurrently.
+type reportCollector struct {
+	linesRead         atomic.Int64
+	bytesRead         atomic.Int64
+	parsed            atomic.Int64
+	rejected          atomic.Int64
+	filtered          atomic.Int64
+	unmatched         atomic.Int64
+	deduplicated      atomic.Int64
+	inserted          atomic.Int64
+	updated           atomic.Int64
+	unchanged         atomic.Int64
+	duplicatesSkipped atomic.Int64
+	historyAppended   atomic.Int64
+	deleted           atomic.Int64
+	staleSkipped      atomic.Int64
+	writeErrors       atomic.Int64
+	writeRetries      atomic.Int64
+	deadLettered      atomic.Int64
+
+	mu             sync.Mutex
+	firstLineAt    time.Time
+	lastLineAt     time.Time
+	stageDurations map[string]time.Duration
+	startedAt      time.Time
+	finishedAt     time.Time
+}
+
+func newReportCollector() *reportCollector {
+	return &reportCollector{
+		stageDurations: make(map[string]time.Duration),
+	}
+}
+
+// lineRead records a line read from the object body.
+func (r *reportCollector) lineRead(size int) {
+	now := time.Now()
+	r.linesRead.Add(1)
+	r.bytesRead.Add(int64(size))
+	r.mu.Lock()
+	if r.firstLineAt.IsZero() {
+		r.firstLineAt = now
+	}
+	r.lastLineAt = now
+	r.mu.Unlock()
+}
+
+func (r *reportCollector) stageDone(name string, d time.Duration) {
+	r.mu.Lock()
+	r.stageDurations[name] = d
+	r.mu.Unlock()
+}
+
+func (r *reportCollector) start() {
+	r.mu.Lock()
+	r.startedAt = time.Now()
+	r.mu.Unlock()
+}
+
+func (r *reportCollector) finish() {
+	r.mu.Lock()
+	r.finishedAt = time.Now()
+	r.mu.Unlock()
+}
+
+// snapshot returns the current state of the collector as a model.Report.
+func (r *reportCollector) snapshot() model.Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	durations := make(map[string]int64, len(r.stageDurations))
+	for name, d := range r.stageDurations {
+		durations[name] = d.Milliseconds()
+	}
+	return model.Report{
+		LinesRead:         r.linesRead.Load(),
+		BytesRead:         r.bytesRead.Load(),
+		Parsed:            r.parsed.Load(),
+		Rejected:          r.rejected.Load(),
+		Filtered:          r.filtered.Load(),
+		Unmatched:         r.unmatched.Load(),
+		Deduplicated:      r.deduplicated.Load(),
+		Inserted:          r.inserted.Load(),
+		Updated:           r.updated.Load(),
+		Unchanged:         r.unchanged.Load(),
+		DuplicatesSkipped: r.duplicatesSkipped.Load(),
+		HistoryAppended:   r.historyAppended.Load(),
+		Deleted:           r.deleted.Load(),
+		StaleSkipped:      r.staleSkipped.Load(),
+		WriteErrors:       r.writeErrors.Load(),
+		WriteRetries:      r.writeRetries.Load(),
+		DeadLettered:      r.deadLettered.Load(),
+		FirstLineAt:       r.firstLineAt,
+		LastLineAt:        r.lastLineAt,
+		StageDurations:    durations,
+		StartedAt:         r.startedAt,
+		FinishedAt:        r.finishedAt,
+	}
+}
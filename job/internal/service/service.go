@@ -7,11 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/dedup"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/join"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
 	"golang.org/x/sync/errgroup"
-	"sync"
+	"log/slog"
+	"time"
 )
 
 // CheckIfBucketExists method checks if the bucket exists. If the bucket does not exist, it returns an error.
@@ -42,6 +48,7 @@ func (s *service) CheckIfObjectExists(ctx context.Context) error {
 }
 
 // CheckObjectDuplicateAndCreate method checks if the object is duplicate in the database. If the object is duplicate, it returns an error.
+// An object that was interrupted by a shutdown is not a duplicate and is resumed. A dry run does not create the object info record.
 // It's looking for ContentType, ContentLength, ETag fields of the object. ETag is the MD5 hash of the object and must be unique.
 func (s *service) CheckObjectDuplicateAndCreate(ctx context.Context, out *s3.GetObjectOutput) error {
 	if out.ContentType == nil {
@@ -57,14 +64,40 @@ func (s *service) CheckObjectDuplicateAndCreate(ctx context.Context, out *s3.Get
 			AddData(fmt.Sprintf("bucketname: %s objectkey: %s out.ETag is nil", s.s3Data.BucketName, s.s3Data.ObjectKey))
 	}
 	var objectDetails model.ObjectInfo
+	objectDetails.BucketName = s.s3Data.BucketName
+	objectDetails.ObjectKey = s.s3Data.ObjectKey
 	objectDetails.ContentType = *out.ContentType
 	objectDetails.ContentLength = *out.ContentLength
 	objectDetails.ETag = *out.ETag
+	objectDetails.Status = model.ObjectStatusRunning
+	if s.dryRun {
+		s.logger.Info(fmt.Sprintf("Dry run: the object info of %s is not created", s.s3Data.ObjectKey))
+		s.etag = objectDetails.ETag
+		return nil
+	}
 	if err := s.objectInfoStorage.Create(ctx, objectDetails); err != nil {
+		// an interrupted object is not a duplicate, it is processed again by this run.
+		var ce *customerror.Error
+		if errors.As(err, &ce) && ce.Message == constant.ErrETagExists {
+			resumed, resumeErr := s.objectInfoStorage.Resume(ctx, objectDetails.ETag)
+			if resumeErr != nil {
+				return resumeErr
+			}
+			if resumed {
+				s.logger.Info(fmt.Sprintf("Resuming interrupted %s", s.s3Data.ObjectKey))
+				s.etag = objectDetails.ETag
+				return nil
+			}
+			// a done or failed object is skipped, it is not a failure of this run.
+			return customerror.New(constant.ErrObjectLoaded, false).
+				Wrap(fmt.Errorf("service.CheckObjectDuplicateAndCreate: %v", err)).
+				AddData(fmt.Sprintf("bucketname: %s objectkey: %s", s.s3Data.BucketName, s.s3Data.ObjectKey))
+		}
 		return customerror.New(constant.ErrCreateObjectInfo, true).
 			Wrap(fmt.Errorf("service.CheckObjectDuplicateAndCreate: %v", err)).
 			AddData(fmt.Sprintf("bucketname: %s objectkey: %s", s.s3Data.BucketName, s.s3Data.ObjectKey))
 	}
+	s.etag = objectDetails.ETag
 	return nil
 }
 
@@ -87,17 +120,44 @@ func (s *service) GetObjectFromS3(ctx context.Context) error {
 			Wrap(fmt.Errorf("service.GetObjectFromS3: %v", err)).
 			AddData(fmt.Sprintf("bucketname: %s objectkey: %s err: %s", s.s3Data.BucketName, s.s3Data.ObjectKey, err))
 	}
+	if s.s3Data.Join.Enabled() && out.ETag != nil {
+		etag, err := s.joinETag(ctx, *out.ETag)
+		if err != nil {
+			if closeErr := out.Body.Close(); closeErr != nil {
+				s.logger.Error(closeErr.Error())
+			}
+			return err
+		}
+		out.ETag = &etag
+	}
 	if err := s.CheckObjectDuplicateAndCreate(ctx, out); err != nil {
 		return err
 	}
-	s.s3OutChan <- out
+	select {
+	case s.s3OutChan <- out:
+	case <-ctx.Done():
+		if err := out.Body.Close(); err != nil {
+			s.logger.Error(err.Error())
+		}
+		return ctx.Err()
+	}
 	return nil
 }
 
 // ReadDataFromS3Object method reads the object from the s3OutChan channel and sends the lines to the lineChan channel.
+// If the object has a join, the secondary objects are read first and the joined lines are sent instead.
 // If an error occurs, closes the lineChan channel and returns the error.
 func (s *service) ReadDataFromS3Object(ctx context.Context) error {
-	out, ok := <-s.s3OutChan
+	var (
+		out *s3.GetObjectOutput
+		ok  bool
+	)
+	select {
+	case out, ok = <-s.s3OutChan:
+	case <-ctx.Done():
+		close(s.lineChan)
+		return ctx.Err()
+	}
 	defer func() {
 		close(s.lineChan)
 		if out != nil {
@@ -111,131 +171,558 @@ func (s *service) ReadDataFromS3Object(ctx context.Context) error {
 			Wrap(fmt.Errorf("service.ReadDataFromS3Object: %v", constant.ErrChannelClosed)).
 			AddData("s3OutChan is closed")
 	}
+	var joiner *join.Joiner
+	if s.s3Data.Join.Enabled() {
+		var err error
+		if joiner, err = s.newJoiner(ctx); err != nil {
+			return err
+		}
+		defer s.closeJoiner(joiner)
+	}
+	send := func(line string) error {
+		select {
+		case s.lineChan <- line:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	s.logger.Info(fmt.Sprintf("Start reading data from %s", s.s3Data.ObjectKey))
 	scanner := bufio.NewScanner(out.Body)
 	for scanner.Scan() {
-		s.lineChan <- scanner.Text()
+		line := scanner.Text()
+		// +1 for the newline stripped by the scanner.
+		s.report.lineRead(len(line) + 1)
+		if joiner == nil {
+			if err := send(line); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := joiner.Probe(line, send); err != nil {
+			return s.joinError(ctx, err)
+		}
 	}
 	if err := scanner.Err(); err != nil {
+		// the body of the object is closed when the context is done.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return customerror.New(constant.ErrFileScanFailed, true).
 			Wrap(fmt.Errorf("service.ReadDataFromS3Object: %v", err)).
 			AddData(fmt.Sprintf("bucketname: %s objectkey: %s line: %s", s.s3Data.BucketName, s.s3Data.ObjectKey, scanner.Text()))
 	}
+	if joiner != nil {
+		if err := joiner.Drain(ctx, send); err != nil {
+			return s.joinError(ctx, err)
+		}
+	}
 	return nil
 }
 
 // HandleLines method reads the lines from the lineChan channel and converts them to the product model.
-// Service has a lineHandlerWorkerCount field that determines how many goroutines will be created to handle the lines.
-// After that, it sends the product to the productChan channel to be written to the database.
+// The lines are parsed in chunks by the parser pool, and lineHandlerWorkerCount limits the chunks of this object
+// parsed at once. A chunk is parsed when it is full or when no line is ready. After that, it sends the product to the productChan channel to be written to the database.
+// If the object has a dedup, the products are kept in the dedup index and the winners are sent after all lines are parsed.
 // If an error occurs, closes the productChan channel and returns the error.
 func (s *service) HandleLines(ctx context.Context) error {
-	wg := sync.WaitGroup{}
 	defer close(s.productChan)
+	defer s.closeSinks()
 
-	startLine, ok := <-s.lineChan
+	var (
+		startLine string
+		ok        bool
+	)
+	select {
+	case startLine, ok = <-s.lineChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if !ok {
 		return customerror.New(constant.ErrChannelClosed, true).
 			Wrap(fmt.Errorf("service.HandleLines: %v", constant.ErrChannelClosed)).
 			AddData("lineChan is closed")
 	}
-	var product model.Product
-	if err := json.Unmarshal([]byte(startLine), &product); err != nil {
-		s.logger.Error(fmt.Sprintf("service.HandleLines unmarshal err: %v", err))
+	parsers, release := ownPool(s.parserPool, s.lineHandlerWorkerCount)
+	defer release()
+	group := parsers.Group(s.lineHandlerWorkerCount)
+	if s.s3Data.Dedup.Enabled() {
+		s.dedup = dedup.New(s.s3Data.Dedup)
+		defer s.closeDedup()
 	}
-	s.productChan <- product
-	for i := 0; i < s.lineHandlerWorkerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for line := range s.lineChan {
-				var product model.Product
-				if err := json.Unmarshal([]byte(line), &product); err != nil {
-					s.logger.Error(fmt.Sprintf("service.HandleLines unmarshal err: %v", err))
+
+	var (
+		err error
+		// seq is the sequence number of the first line of the next chunk.
+		seq int64
+	)
+	// prev is closed when the previous chunk sent its products. The chunks send their products in order
+	// if the object has ordered writes.
+	var prev chan struct{}
+	parse := func(chunk []string) error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		start := seq
+		seq += int64(len(chunk))
+		var t *turn
+		if s.s3Data.OrderedWrites && s.dedup == nil {
+			t = &turn{prev: prev, done: make(chan struct{})}
+			prev = t.done
+		}
+		return s.parseChunk(ctx, group, start, chunk, t)
+	}
+	chunk := append(make([]string, 0, lineChunkSize), startLine)
+loop:
+	for {
+		var line string
+		select {
+		case line, ok = <-s.lineChan:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		default:
+			// no line is ready, so the partial chunk is parsed instead of waiting for the chunk to fill up.
+			if err = parse(chunk); err != nil {
+				break loop
+			}
+			chunk = make([]string, 0, lineChunkSize)
+			select {
+			case line, ok = <-s.lineChan:
+			case <-ctx.Done():
+				err = ctx.Err()
+				break loop
+			}
+		}
+		if !ok {
+			break
+		}
+		chunk = append(chunk, line)
+		if len(chunk) < lineChunkSize {
+			continue
+		}
+		if err = parse(chunk); err != nil {
+			break
+		}
+		chunk = make([]string, 0, lineChunkSize)
+	}
+	if err == nil {
+		err = parse(chunk)
+	}
+	if waitErr := group.Wait(); err == nil {
+		err = waitErr
+	}
+	if err == nil && s.dedup != nil {
+		err = s.drainDedup(ctx)
+	}
+	return err
+}
+
+// parseChunk submits the lines to the parser pool. seq is the sequence number of the first line of the chunk.
+// The products of the lines are sent to the productChan channel, or added to the dedup index if the object has a dedup.
+// If t is set, the products are sent in its turn after all lines of the chunk are parsed.
+func (s *service) parseChunk(ctx context.Context, group *pool.Group, seq int64, lines []string, t *turn) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	if t != nil {
+		err := group.Go(ctx, func() error {
+			defer close(t.done)
+			var products []model.Product
+			for _, line := range lines {
+				products = append(products, s.handleLine(ctx, line)...)
+			}
+			if err := t.wait(ctx); err != nil {
+				return err
+			}
+			for _, product := range products {
+				if err := s.sendProduct(ctx, product); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			close(t.done)
+		}
+		return err
+	}
+	return group.Go(ctx, func() error {
+		for i, line := range lines {
+			for _, product := range s.handleLine(ctx, line) {
+				if s.dedup != nil {
+					if err := s.dedup.Add(seq+int64(i), product); err != nil {
+						return s.dedupError(err)
+					}
 					continue
 				}
-				s.productChan <- product
+				if err := s.sendProduct(ctx, product); err != nil {
+					return err
+				}
 			}
-		}()
+		}
+		return nil
+	})
+}
+
+// sendProduct hands the product to the secondary sinks and sends it to the productChan channel.
+func (s *service) sendProduct(ctx context.Context, product model.Product) error {
+	s.fanOut(product)
+	select {
+	case s.productChan <- product:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	wg.Wait()
-	return nil
 }
 
-// WriteDataToDb method reads the products from the productChan channel and writes them to the database.
+// handleLine converts a line to the product model, applies the filter and then the transformer.
+// It returns no product if the line is rejected, filtered out or dropped by the transformer.
+func (s *service) handleLine(ctx context.Context, line string) []model.Product {
+	var product model.Product
+	if err := json.Unmarshal([]byte(line), &product); err != nil {
+		s.report.rejected.Add(1)
+		s.logger.Error(fmt.Sprintf("service.HandleLines unmarshal err: %v", err))
+		return nil
+	}
+	if s.s3Data.Format != config.FormatCDC {
+		product.Op, product.Ts = "", 0
+	} else if product.Op == "" {
+		product.Op = model.OpUpsert
+	} else if product.Op != model.OpUpsert && product.Op != model.OpDelete {
+		s.report.rejected.Add(1)
+		s.logError(customerror.New(constant.ErrInvalidOperation, true).
+			AddData(fmt.Sprintf("objectkey: %s id: %d op: %s", s.s3Data.ObjectKey, product.ID, product.Op)))
+		return nil
+	}
+	s.report.parsed.Add(1)
+	product.Source = s.s3Data.Source
+	if s.filter != nil && product.Op != model.OpDelete {
+		match, err := s.filter.Match(product)
+		if err != nil {
+			s.report.rejected.Add(1)
+			s.logError(customerror.New(constant.ErrFilterProduct, true).
+				Wrap(fmt.Errorf("service.handleLine: %w", err)).
+				AddData(fmt.Sprintf("objectkey: %s id: %d err: %s", s.s3Data.ObjectKey, product.ID, err)))
+			return nil
+		}
+		if !match {
+			s.report.filtered.Add(1)
+			return nil
+		}
+	}
+	products, err := s.transform(ctx, product)
+	if err != nil {
+		s.report.rejected.Add(1)
+		s.logError(customerror.New(constant.ErrTransformProduct, true).
+			Wrap(fmt.Errorf("service.handleLine: %w", err)).
+			AddData(fmt.Sprintf("objectkey: %s id: %d err: %s", s.s3Data.ObjectKey, product.ID, err)))
+		return nil
+	}
+	for i := range products {
+		products[i].Source = s.s3Data.Source
+	}
+	return products
+}
+
+// transform applies the transformer to the product. A panic of the transformer is an error, so a faulty transformer
+// rejects the product instead of failing the object.
+func (s *service) transform(ctx context.Context, product model.Product) (products []model.Product, err error) {
+	if s.transformer == nil {
+		return []model.Product{product}, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			products, err = nil, fmt.Errorf("transformer panicked: %v", r)
+		}
+	}()
+	return s.transformer.Transform(ctx, product)
+}
+
+// WriteDataToDb method reads the products from the productChan channel and writes them to the database in batches.
 // If the channel is closed, to avoid running workers unnecessarily and to log this situation.
 // Naturally, we process the first data manually because if there is only 1 data, the channel is closed.
 // Same situation have to be handled in HandleLines method.
-// Service has a dbWriteWorkerCount field that determines how many goroutines will be created to write the products to the database.
+// The products are accumulated into a batch that is flushed when it reaches batchSize or batchBytes, or when
+// flushInterval elapses. The batches are written by the writer pool, and dbWriteWorkerCount limits the batches
+// of this object written at once.
 // If an error occurs, returns the error. If the product not written to the database, logs the error.
 func (s *service) WriteDataToDb(ctx context.Context) error {
-	startProduct, ok := <-s.productChan
+	return s.writeDataToDb(ctx, ctx)
+}
+
+// writeDataToDb reads the products until ctx is done and writes the batches with writeCtx.
+// writeCtx outlives ctx by the flush deadline, so the batch in flight is written when the run is cancelled.
+func (s *service) writeDataToDb(ctx, writeCtx context.Context) error {
+	var (
+		startProduct model.Product
+		ok           bool
+	)
+	select {
+	case startProduct, ok = <-s.productChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if !ok {
 		return customerror.New(constant.ErrChannelClosed, true).
 			Wrap(fmt.Errorf("service.WriteDataToDb: %v", constant.ErrChannelClosed)).
 			AddData("productChan is closed")
 	}
 	s.logger.Info(fmt.Sprintf("Start writing data to db"))
-	if err := s.productStorage.Create(ctx, startProduct); err != nil {
-		var ce *customerror.Error
-		if errors.As(err, &ce) {
-			message := ce.Message
-			if ce.Data != nil {
-				data, ok := ce.Data.(string)
-				if ok {
-					message += ", " + data
-				}
-				if ce.Loggable {
-					s.logger.Error(message)
-				}
+	writers, release := ownPool(s.writerPool, s.dbWriteWorkerCount)
+	defer release()
+	group := writers.Group(s.dbWriteWorkerCount)
+	if s.s3Data.OrderedWrites {
+		return s.writeOrdered(ctx, writeCtx, group, startProduct)
+	}
+
+	b := newBatch(s.batchSize, s.batchBytes)
+	full := b.add(startProduct)
+	var tick <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		if full {
+			s.submitFlush(writeCtx, group, b.take())
+		}
+		select {
+		case product, ok := <-s.productChan:
+			if !ok {
+				s.submitFlush(writeCtx, group, b.take())
+				return group.Wait()
+			}
+			full = b.add(product)
+		case <-tick:
+			full = true
+		case <-ctx.Done():
+			// the products that are not batched yet are written by the run that resumes the object.
+			s.submitFlush(writeCtx, group, b.take())
+			if err := group.Wait(); err != nil {
+				return err
 			}
+			return ctx.Err()
 		}
 	}
-	wg := sync.WaitGroup{}
-	for i := 0; i < s.dbWriteWorkerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for product := range s.productChan {
-				if err := s.productStorage.Create(ctx, product); err != nil {
-					var ce *customerror.Error
-					if errors.As(err, &ce) {
-						message := ce.Message
-						if ce.Data != nil {
-							data, ok := ce.Data.(string)
-							if ok {
-								message += ", " + data
-							}
-							if ce.Loggable {
-								s.logger.Error(message)
-							}
-						}
-					}
-				}
-			}
-		}()
+}
+
+// submitFlush submits the flush of the products to the writer pool. If the context is done before a writer is free,
+// the products are not written and handled as failed writes.
+func (s *service) submitFlush(ctx context.Context, group *pool.Group, products []model.Product) {
+	if len(products) == 0 {
+		return
 	}
-	wg.Wait()
-	return nil
+	if err := group.Go(ctx, func() error {
+		s.flush(ctx, products)
+		return nil
+	}); err != nil {
+		s.failWrites(ctx, products, err)
+	}
+}
+
+// flush writes the products of the batch to the database and handles the result of each product.
+func (s *service) flush(ctx context.Context, products []model.Product) {
+	// the products are seen before they are written, so a failed write keeps the stored product instead of deleting it.
+	if s.s3Data.Snapshot.Enabled {
+		s.seen.see(products)
+	}
+	products, deletes := splitDeletes(products)
+	if s.s3Data.ChangeDetection {
+		products = s.detectChanges(ctx, products)
+	}
+	if len(products) > 0 {
+		s.writeWithRetry(ctx, products, s.writeBatch)
+	}
+	if len(deletes) > 0 {
+		s.writeWithRetry(ctx, deletes, s.writer().DeleteBatch)
+	}
+}
+
+// splitDeletes splits the delete operations of a CDC formatted object from the products to be written.
+func splitDeletes(products []model.Product) ([]model.Product, []model.Product) {
+	var deletes []model.Product
+	upserts := products[:0]
+	for _, product := range products {
+		if product.Op == model.OpDelete {
+			deletes = append(deletes, product)
+			continue
+		}
+		upserts = append(upserts, product)
+	}
+	return upserts, deletes
+}
+
+// handleBatchResult updates the report with the written products of a batch write.
+// The errors of the failed products are handled by the caller.
+func (s *service) handleBatchResult(result productstorage.BatchResult) {
+	s.report.inserted.Add(result.Inserted)
+	s.report.updated.Add(result.Updated)
+	s.report.unchanged.Add(result.Unchanged)
+	s.report.deleted.Add(result.Deleted)
+}
+
+// writeBatch writes the products with the storage method of the write mode of the S3 object.
+func (s *service) writeBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	switch s.s3Data.WriteMode {
+	case config.WriteModeReplace:
+		return s.writer().ReplaceBatch(ctx, products)
+	case config.WriteModeMerge:
+		return s.writer().MergeBatch(ctx, products)
+	default:
+		if s.stagingStorage != nil {
+			return s.createStaged(ctx, products)
+		}
+		return s.writer().CreateBatch(ctx, products)
+	}
+}
+
+// handleWriteError updates the report with the failed product write and logs the error.
+// It reports whether the write failed. Duplicate IDs and stale events are skipped, not failed.
+func (s *service) handleWriteError(err error) bool {
+	var ce *customerror.Error
+	if errors.As(err, &ce) && ce.Message == constant.ErrIDExists {
+		s.report.duplicatesSkipped.Add(1)
+		return false
+	}
+	if errors.As(err, &ce) && ce.Message == constant.ErrStaleEvent {
+		s.report.staleSkipped.Add(1)
+		return false
+	}
+	s.report.writeErrors.Add(1)
+	s.logError(err)
+	return true
+}
+
+// logError logs the error with the data of its custom error, whatever the type of the data is.
+func (s *service) logError(err error) {
+	message := err.Error()
+	var ce *customerror.Error
+	if errors.As(err, &ce) && ce.Data != nil {
+		message += fmt.Sprintf(", %v", ce.Data)
+	}
+	s.logger.Error(message)
+}
+
+// Report method returns the ingestion report of the run with the outcome of each secondary sink.
+func (s *service) Report() model.Report {
+	report := s.report.snapshot()
+	report.Sinks = s.sinkReports()
+	report.DryRun = s.dryRun
+	return report
 }
 
 // For each S3 object to be read, a goroutine comes to the Run method and runs the methods in funcArr concurrently.
-func (s *service) Run() error {
+// Each stage duration is recorded in the report. After all stages are finished, the report is stored alongside the object info record.
+// If the S3 object is loaded atomically, the products are written to a staging collection that is promoted after all stages succeed.
+// The secondary sinks are written next to the stages and the run waits for them before the report is finished.
+// If ctx is cancelled, the stages stop, the batches in flight are written within the flush deadline
+// and the object is recorded as interrupted, so the next run resumes it.
+func (s *service) Run(ctx context.Context) error {
 	s.logger.Info(fmt.Sprintf("Start processing %s", s.s3Data.ObjectKey))
-	funcArr := []func(ctx context.Context) error{
-		s.GetObjectFromS3,
-		s.ReadDataFromS3Object,
-		s.HandleLines,
-		s.WriteDataToDb,
-	}
-	g, ctx := errgroup.WithContext(context.Background())
-	for _, f := range funcArr {
-		f := f
+	s.report.start()
+	writeCtx, cancelWrites := withFlushDeadline(ctx, s.flushDeadline)
+	defer cancelWrites()
+	if s.s3Data.Atomic.Enabled {
+		if err := s.prepareStaging(writeCtx); err != nil {
+			s.report.finish()
+			return err
+		}
+	}
+	funcArr := []struct {
+		name string
+		f    func(ctx context.Context) error
+	}{
+		{name: "get_object", f: s.GetObjectFromS3},
+		{name: "read", f: s.ReadDataFromS3Object},
+		{name: "handle_lines", f: s.HandleLines},
+		{name: "write", f: func(ctx context.Context) error {
+			return s.writeDataToDb(ctx, writeCtx)
+		}},
+	}
+	s.startSinks(writeCtx)
+	g, stageCtx := errgroup.WithContext(ctx)
+	for _, stage := range funcArr {
+		stage := stage
 		g.Go(func() error {
-			return f(ctx)
+			start := time.Now()
+			defer func() {
+				s.report.stageDone(stage.name, time.Since(start))
+			}()
+			return stage.f(stageCtx)
 		})
 	}
-	if err := g.Wait(); err != nil {
-		return err
+	err := g.Wait()
+	s.waitSinks()
+	if s.s3Data.Atomic.Enabled {
+		err = s.finishStaging(context.WithoutCancel(ctx), err)
+	}
+	if err == nil && s.s3Data.Snapshot.Enabled {
+		err = s.syncSnapshot(writeCtx)
+	}
+	status := model.ObjectStatusDone
+	if err != nil {
+		status = model.ObjectStatusFailed
+		if ctx.Err() != nil {
+			status = model.ObjectStatusInterrupted
+			s.logger.Info(fmt.Sprintf("Interrupted processing %s", s.s3Data.ObjectKey))
+		}
+	}
+	s.report.finish()
+	s.saveReport(status)
+	return err
+}
+
+// withFlushDeadline returns a context that is cancelled the flush deadline after ctx is done.
+func withFlushDeadline(ctx context.Context, deadline time.Duration) (context.Context, context.CancelFunc) {
+	flushCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-flushCtx.Done():
+		}
+	})
+	return flushCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// saveReport stores the status and the report alongside the object info record.
+// If the object info is not created, there is nothing to update.
+func (s *service) saveReport(status string) {
+	if s.etag == "" {
+		return
+	}
+	report := s.Report()
+	s.logger.Info(fmt.Sprintf("Report of %s", s.s3Data.ObjectKey),
+		slog.String("status", status),
+		slog.Bool("dry_run", s.dryRun),
+		slog.Int64("lines_read", report.LinesRead),
+		slog.Int64("parsed", report.Parsed),
+		slog.Int64("rejected", report.Rejected),
+		slog.Int64("filtered", report.Filtered),
+		slog.Int64("unmatched", report.Unmatched),
+		slog.Int64("deduplicated", report.Deduplicated),
+		slog.Int64("inserted", report.Inserted),
+		slog.Int64("updated", report.Updated),
+		slog.Int64("unchanged", report.Unchanged),
+		slog.Int64("duplicates_skipped", report.DuplicatesSkipped),
+		slog.Int64("history_appended", report.HistoryAppended),
+		slog.Int64("deleted", report.Deleted),
+		slog.Int64("stale_skipped", report.StaleSkipped),
+		slog.Int64("write_errors", report.WriteErrors),
+		slog.Int64("write_retries", report.WriteRetries),
+		slog.Int64("dead_lettered", report.DeadLettered),
+	)
+	if s.dryRun {
+		return
+	}
+	if err := s.objectInfoStorage.Finish(context.Background(), s.etag, status, report); err != nil {
+		s.logError(err)
 	}
-	return nil
 }
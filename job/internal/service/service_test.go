@@ -1,20 +1,33 @@
 package service_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/pool"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/service"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/throttle"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/filter"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/transform"
 	"io"
 	"log/slog"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestService_CheckIfBucketExists(t *testing.T) {
@@ -651,7 +664,7 @@ func TestService_WriteDataToDb(t *testing.T) {
 			fields: fields{
 				productChan: make(chan model.Product, 10),
 				productStorage: &mockProductStorage{
-					createErr: customerror.ErrCreateProduct,
+					createBatchErr: customerror.ErrCreateProduct,
 				},
 			},
 			args:    args{ctx: context.Background()},
@@ -684,3 +697,1046 @@ func TestService_WriteDataToDb(t *testing.T) {
 		})
 	}
 }
+
+func TestService_WriteDataToDb_LogsErrorData(t *testing.T) {
+	var buf bytes.Buffer
+	productChan := make(chan model.Product, 1)
+	s := service.New(
+		service.WithProductChannel(productChan),
+		service.WithDBWriteWorkerCount(1),
+		service.WithProductStorage(&mockProductStorage{
+			createBatchErr: customerror.New(constant.ErrCreateProduct, false).AddData(42),
+		}),
+		service.WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+	)
+	productChan <- model.Product{ID: 42}
+	close(productChan)
+	if err := s.WriteDataToDb(context.Background()); err != nil {
+		t.Fatalf("WriteDataToDb() error = %v", err)
+	}
+	if log := buf.String(); !strings.Contains(log, constant.ErrCreateProduct+", 42") {
+		t.Errorf("log = %s, want the error with its data 42", log)
+	}
+}
+
+func TestService_Run_Report(t *testing.T) {
+	body := `{"id":1,"title":"first"}
+not a json
+{"id":2,"title":"second"}
+{"id":3,"title":"third"}
+`
+	objectInfoStorage := &mockObjectInfoStorage{}
+	s := newRunService(body, config.S3{BucketName: "test", ObjectKey: "test"}, &mockProductStorage{}, objectInfoStorage)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	report := s.Report()
+	if report.LinesRead != 4 {
+		t.Errorf("LinesRead = %d, want 4", report.LinesRead)
+	}
+	if report.BytesRead != int64(len(body)) {
+		t.Errorf("BytesRead = %d, want %d", report.BytesRead, len(body))
+	}
+	if report.Parsed != 3 || report.Rejected != 1 {
+		t.Errorf("Parsed = %d Rejected = %d, want 3 and 1", report.Parsed, report.Rejected)
+	}
+	if report.Inserted != 3 {
+		t.Errorf("Inserted = %d, want 3", report.Inserted)
+	}
+	if len(report.StageDurations) != 4 {
+		t.Errorf("StageDurations = %v, want 4 stages", report.StageDurations)
+	}
+	if objectInfoStorage.report.LinesRead != report.LinesRead {
+		t.Errorf("stored report LinesRead = %d, want %d", objectInfoStorage.report.LinesRead, report.LinesRead)
+	}
+}
+
+func TestService_WriteDataToDb_Batches(t *testing.T) {
+	productStorage := &mockProductStorage{}
+	productChan := make(chan model.Product, 10)
+	s := service.New(
+		service.WithProductChannel(productChan),
+		service.WithDBWriteWorkerCount(1),
+		service.WithBatchSize(2),
+		service.WithProductStorage(productStorage),
+		service.WithLogger(slog.New(
+			slog.NewJSONHandler(os.Stdout, nil),
+		)),
+	)
+	for i := 1; i <= 5; i++ {
+		productChan <- model.Product{ID: i}
+	}
+	close(productChan)
+	if err := s.WriteDataToDb(context.Background()); err != nil {
+		t.Fatalf("WriteDataToDb() error = %v", err)
+	}
+	wantSizes := []int{2, 2, 1}
+	if len(productStorage.batchSizes) != len(wantSizes) {
+		t.Fatalf("batch sizes = %v, want %v", productStorage.batchSizes, wantSizes)
+	}
+	for i, size := range wantSizes {
+		if productStorage.batchSizes[i] != size {
+			t.Errorf("batch sizes = %v, want %v", productStorage.batchSizes, wantSizes)
+		}
+	}
+	if report := s.Report(); report.Inserted != 5 {
+		t.Errorf("Inserted = %d, want 5", report.Inserted)
+	}
+}
+
+func TestService_WriteDataToDb_WriteMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		writeMode string
+		wantMode  string
+	}{
+		{name: "empty write mode should insert", writeMode: "", wantMode: config.WriteModeInsert},
+		{name: "insert write mode should insert", writeMode: config.WriteModeInsert, wantMode: config.WriteModeInsert},
+		{name: "replace write mode should replace", writeMode: config.WriteModeReplace, wantMode: config.WriteModeReplace},
+		{name: "merge write mode should merge", writeMode: config.WriteModeMerge, wantMode: config.WriteModeMerge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{}
+			productChan := make(chan model.Product, 1)
+			s := service.New(
+				service.WithS3Data(config.S3{WriteMode: tt.writeMode}),
+				service.WithProductChannel(productChan),
+				service.WithDBWriteWorkerCount(1),
+				service.WithProductStorage(productStorage),
+				service.WithLogger(slog.New(
+					slog.NewJSONHandler(os.Stdout, nil),
+				)),
+			)
+			productChan <- model.Product{ID: 1}
+			close(productChan)
+			if err := s.WriteDataToDb(context.Background()); err != nil {
+				t.Fatalf("WriteDataToDb() error = %v", err)
+			}
+			if len(productStorage.modes) != 1 || productStorage.modes[0] != tt.wantMode {
+				t.Errorf("modes = %v, want %s", productStorage.modes, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestService_WriteDataToDb_ChangeDetection(t *testing.T) {
+	unchanged := model.Product{ID: 1, Title: "same"}
+	unchanged.Hash = unchanged.ContentHash()
+	tests := []struct {
+		name             string
+		writeMode        string
+		stored           map[int]model.Product
+		findErr          error
+		createHistoryErr error
+		products         []model.Product
+		wantWritten      []int
+		wantHistory      []int
+		wantUnchanged    int64
+		wantWriteErrors  int64
+	}{
+		{
+			name:      "only new and changed products should be written",
+			writeMode: config.WriteModeReplace,
+			stored: map[int]model.Product{
+				1: unchanged,
+				2: {ID: 2, Title: "old"},
+			},
+			products:      []model.Product{{ID: 1, Title: "same"}, {ID: 2, Title: "new"}, {ID: 3, Title: "created"}},
+			wantWritten:   []int{3, 2},
+			wantHistory:   []int{2},
+			wantUnchanged: 1,
+		},
+		{
+			name:      "merge with only empty fields changed should be unchanged",
+			writeMode: config.WriteModeMerge,
+			stored: map[int]model.Product{
+				1: {ID: 1, Title: "same", Price: 10},
+			},
+			products:      []model.Product{{ID: 1, Price: 10}},
+			wantUnchanged: 1,
+		},
+		{
+			name:            "find error should count write errors",
+			writeMode:       config.WriteModeReplace,
+			findErr:         customerror.ErrFindProducts,
+			products:        []model.Product{{ID: 1}, {ID: 2}},
+			wantWriteErrors: 2,
+		},
+		{
+			name:      "history error should skip changed products",
+			writeMode: config.WriteModeReplace,
+			stored: map[int]model.Product{
+				1: {ID: 1, Title: "old"},
+			},
+			createHistoryErr: customerror.ErrCreateHistory,
+			products:         []model.Product{{ID: 1, Title: "new"}, {ID: 2, Title: "created"}},
+			wantWritten:      []int{2},
+			wantWriteErrors:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{
+				stored:           tt.stored,
+				findErr:          tt.findErr,
+				createHistoryErr: tt.createHistoryErr,
+			}
+			productChan := make(chan model.Product, len(tt.products))
+			s := service.New(
+				service.WithS3Data(config.S3{WriteMode: tt.writeMode, ChangeDetection: true}),
+				service.WithProductChannel(productChan),
+				service.WithDBWriteWorkerCount(1),
+				service.WithBatchSize(len(tt.products)),
+				service.WithProductStorage(productStorage),
+				service.WithLogger(slog.New(
+					slog.NewJSONHandler(os.Stdout, nil),
+				)),
+			)
+			for _, product := range tt.products {
+				productChan <- product
+			}
+			close(productChan)
+			if err := s.WriteDataToDb(context.Background()); err != nil {
+				t.Fatalf("WriteDataToDb() error = %v", err)
+			}
+			var written []int
+			for _, product := range productStorage.written {
+				if product.Hash == "" {
+					t.Errorf("written product %d has no hash", product.ID)
+				}
+				written = append(written, product.ID)
+			}
+			if fmt.Sprint(written) != fmt.Sprint(tt.wantWritten) {
+				t.Errorf("written = %v, want %v", written, tt.wantWritten)
+			}
+			var history []int
+			for _, h := range productStorage.history {
+				history = append(history, h.ProductID)
+			}
+			if fmt.Sprint(history) != fmt.Sprint(tt.wantHistory) {
+				t.Errorf("history = %v, want %v", history, tt.wantHistory)
+			}
+			report := s.Report()
+			if report.Unchanged != tt.wantUnchanged {
+				t.Errorf("Unchanged = %d, want %d", report.Unchanged, tt.wantUnchanged)
+			}
+			if report.WriteErrors != tt.wantWriteErrors {
+				t.Errorf("WriteErrors = %d, want %d", report.WriteErrors, tt.wantWriteErrors)
+			}
+		})
+	}
+}
+
+func TestService_Run_Snapshot(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		snapshot    config.Snapshot
+		sourceIDs   []int
+		wantErr     bool
+		wantDeleted []int
+		wantHard    bool
+	}{
+		{
+			name:        "missing products should be soft deleted",
+			body:        "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n",
+			snapshot:    config.Snapshot{Enabled: true, DeleteMode: config.DeleteModeSoft, MaxDeleteRatio: 0.5},
+			sourceIDs:   []int{1, 2, 3, 4},
+			wantDeleted: []int{4},
+		},
+		{
+			name:        "missing products should be hard deleted",
+			body:        "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n",
+			snapshot:    config.Snapshot{Enabled: true, DeleteMode: config.DeleteModeHard, MaxDeleteRatio: 0.5},
+			sourceIDs:   []int{1, 2, 3, 4},
+			wantDeleted: []int{4},
+			wantHard:    true,
+		},
+		{
+			name:      "delete ratio over the limit should return error",
+			body:      "{\"id\":1}\n",
+			snapshot:  config.Snapshot{Enabled: true, DeleteMode: config.DeleteModeSoft, MaxDeleteRatio: 0.5},
+			sourceIDs: []int{1, 2, 3, 4},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{sourceIDs: tt.sourceIDs}
+			s := newRunService(tt.body, config.S3{
+				BucketName: "test",
+				ObjectKey:  "test",
+				WriteMode:  config.WriteModeReplace,
+				Source:     "test/test",
+				Snapshot:   tt.snapshot,
+			}, productStorage, &mockObjectInfoStorage{})
+			err := s.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(productStorage.deletedIDs) != fmt.Sprint(tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", productStorage.deletedIDs, tt.wantDeleted)
+			}
+			if productStorage.hardDelete != tt.wantHard {
+				t.Errorf("hard delete = %v, want %v", productStorage.hardDelete, tt.wantHard)
+			}
+			if s.Report().Deleted != int64(len(tt.wantDeleted)) {
+				t.Errorf("Deleted = %d, want %d", s.Report().Deleted, len(tt.wantDeleted))
+			}
+		})
+	}
+}
+
+func TestService_Run_CDC(t *testing.T) {
+	body := `{"op":"upsert","ts":10,"id":1,"title":"first"}
+{"op":"delete","ts":11,"id":2}
+{"op":"upsert","ts":5,"id":3,"title":"stale"}
+{"op":"unknown","ts":12,"id":4}
+{"ts":13,"id":5,"title":"default upsert"}
+`
+	productStorage := &mockProductStorage{staleIDs: map[int]bool{3: true}}
+	s := newRunService(body, config.S3{
+		BucketName: "test",
+		ObjectKey:  "test",
+		WriteMode:  config.WriteModeReplace,
+		Format:     config.FormatCDC,
+	}, productStorage, &mockObjectInfoStorage{})
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(productStorage.tombstones) != 1 || productStorage.tombstones[0].ID != 2 || productStorage.tombstones[0].Ts != 11 {
+		t.Errorf("tombstones = %v, want product 2 with ts 11", productStorage.tombstones)
+	}
+	for _, product := range productStorage.written {
+		if product.Op == model.OpDelete {
+			t.Errorf("delete operation of product %d should not be written as upsert", product.ID)
+		}
+	}
+	report := s.Report()
+	if report.Rejected != 1 {
+		t.Errorf("Rejected = %d, want 1", report.Rejected)
+	}
+	if report.Inserted != 2 || report.Deleted != 1 || report.StaleSkipped != 1 {
+		t.Errorf("Inserted = %d Deleted = %d StaleSkipped = %d, want 2, 1 and 1", report.Inserted, report.Deleted, report.StaleSkipped)
+	}
+}
+
+func TestService_HandleLines_ProductFormatIgnoresOperation(t *testing.T) {
+	lineChan := make(chan string, 1)
+	productChan := make(chan model.Product, 1)
+	s := service.New(
+		service.WithLineChannel(lineChan),
+		service.WithProductChannel(productChan),
+		service.WithLineHandlerWorkerCount(1),
+		service.WithLogger(slog.New(
+			slog.NewJSONHandler(os.Stdout, nil),
+		)),
+	)
+	lineChan <- `{"op":"delete","ts":10,"id":1}`
+	close(lineChan)
+	if err := s.HandleLines(context.Background()); err != nil {
+		t.Fatalf("HandleLines() error = %v", err)
+	}
+	product := <-productChan
+	if product.Op != "" || product.Ts != 0 {
+		t.Errorf("product op = %q ts = %d, want empty", product.Op, product.Ts)
+	}
+}
+
+func TestService_Run_Atomic(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		writeMode    string
+		promote      string
+		wantErr      bool
+		wantPromoted string
+		wantDropped  bool
+	}{
+		{
+			name:         "merge promote should merge staging into products",
+			body:         "{\"id\":1}\n{\"id\":2}\n",
+			writeMode:    config.WriteModeReplace,
+			promote:      config.PromoteMerge,
+			wantPromoted: config.PromoteMerge + ":" + productstorage.WhenMatchedReplace,
+		},
+		{
+			name:         "rename promote should rename staging to products",
+			body:         "{\"id\":1}\n{\"id\":2}\n",
+			writeMode:    config.WriteModeInsert,
+			promote:      config.PromoteRename,
+			wantPromoted: config.PromoteRename,
+		},
+		{
+			name:        "failed load should drop staging",
+			body:        "{\"id\":1}\n" + strings.Repeat("x", 70*1024) + "\n",
+			writeMode:   config.WriteModeInsert,
+			promote:     config.PromoteMerge,
+			wantErr:     true,
+			wantDropped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{}
+			s := newRunService(tt.body, config.S3{
+				BucketName: "test",
+				ObjectKey:  "test",
+				WriteMode:  tt.writeMode,
+				Atomic:     config.Atomic{Enabled: true, Promote: tt.promote},
+			}, productStorage, &mockObjectInfoStorage{})
+			err := s.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if productStorage.staging == nil {
+				t.Fatalf("staging storage is not created")
+			}
+			if len(productStorage.written) != 0 {
+				t.Errorf("products written to the product storage = %d, want 0", len(productStorage.written))
+			}
+			if !tt.wantErr && len(productStorage.staging.written) != 2 {
+				t.Errorf("products written to the staging storage = %d, want 2", len(productStorage.staging.written))
+			}
+			if productStorage.promoted != tt.wantPromoted {
+				t.Errorf("promoted = %q, want %q", productStorage.promoted, tt.wantPromoted)
+			}
+			if productStorage.dropped != tt.wantDropped {
+				t.Errorf("dropped = %v, want %v", productStorage.dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestService_Run_AtomicInsertExisting(t *testing.T) {
+	productStorage := &mockProductStorage{stored: map[int]model.Product{1: {ID: 1, Title: "stored"}}}
+	s := newRunService("{\"id\":1}\n{\"id\":2}\n", config.S3{
+		BucketName: "test",
+		ObjectKey:  "test",
+		WriteMode:  config.WriteModeInsert,
+		Atomic:     config.Atomic{Enabled: true, Promote: config.PromoteMerge},
+	}, productStorage, &mockObjectInfoStorage{})
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(productStorage.staging.written) != 1 || productStorage.staging.written[0].ID != 2 {
+		t.Errorf("products written to the staging storage = %v, want only product 2", productStorage.staging.written)
+	}
+	report := s.Report()
+	if report.Inserted != 1 || report.DuplicatesSkipped != 1 {
+		t.Errorf("Inserted = %d DuplicatesSkipped = %d, want 1 and 1", report.Inserted, report.DuplicatesSkipped)
+	}
+}
+
+func TestService_Run_AtomicHistory(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantErr     bool
+		wantHistory int
+	}{
+		{name: "promoted load should append the history", body: "{\"id\":1,\"title\":\"new\"}\n", wantHistory: 1},
+		{name: "dropped load should not append the history", body: "{\"id\":1,\"title\":\"new\"}\n" + strings.Repeat("x", 70*1024) + "\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{stored: map[int]model.Product{1: {ID: 1, Title: "old"}}}
+			s := newRunService(tt.body, config.S3{
+				BucketName:      "test",
+				ObjectKey:       "test",
+				WriteMode:       config.WriteModeReplace,
+				ChangeDetection: true,
+				Atomic:          config.Atomic{Enabled: true, Promote: config.PromoteMerge},
+			}, productStorage, &mockObjectInfoStorage{}, service.WithBatchSize(1))
+			if err := s.Run(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(productStorage.history) != tt.wantHistory {
+				t.Errorf("history = %d, want %d", len(productStorage.history), tt.wantHistory)
+			}
+			if got := s.Report().HistoryAppended; got != int64(tt.wantHistory) {
+				t.Errorf("HistoryAppended = %d, want %d", got, tt.wantHistory)
+			}
+		})
+	}
+}
+
+func TestService_Run_Sinks(t *testing.T) {
+	body := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"
+	policy := config.Retry{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	productStorage := &mockProductStorage{}
+	export := &mockSink{}
+	failing := &mockSink{failures: 100}
+	s := newRunService(body, config.S3{BucketName: "test", ObjectKey: "test"}, productStorage, &mockObjectInfoStorage{},
+		service.WithSink(config.Sink{Name: "export", Workers: 2, BatchSize: 2, Buffer: 10, Retry: policy}, export),
+		service.WithSink(config.Sink{Name: "failing", Workers: 1, BatchSize: 10, Buffer: 10, Retry: policy}, failing),
+	)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(productStorage.written) != 3 {
+		t.Errorf("products written to the product storage = %d, want 3", len(productStorage.written))
+	}
+	if len(export.written) != 3 {
+		t.Errorf("products written to the export sink = %d, want 3", len(export.written))
+	}
+	report := s.Report()
+	want := map[string]model.SinkReport{
+		"export":  {Written: 3},
+		"failing": {Failed: 3, Retries: 2},
+	}
+	if !reflect.DeepEqual(report.Sinks, want) {
+		t.Errorf("Report().Sinks = %+v, want %+v", report.Sinks, want)
+	}
+}
+
+func TestService_Run_SlowSinkDoesNotBlock(t *testing.T) {
+	body := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n"
+	productStorage := &mockProductStorage{}
+	slow := &mockSink{release: make(chan struct{})}
+	s := newRunService(body, config.S3{BucketName: "test", ObjectKey: "test"}, productStorage, &mockObjectInfoStorage{},
+		service.WithSink(config.Sink{Name: "slow", Workers: 1, BatchSize: 1, Buffer: 1, Retry: config.Retry{MaxAttempts: 1}}, slow),
+	)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Run(context.Background())
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		productStorage.mu.Lock()
+		written := len(productStorage.written)
+		productStorage.mu.Unlock()
+		if written == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("products written to the product storage = %d while the sink is blocked, want 4", written)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(slow.release)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	report := s.Report().Sinks["slow"]
+	if report.Dropped == 0 || report.Written+report.Dropped != 4 {
+		t.Errorf("Report().Sinks[slow] = %+v, want dropped products and 4 products in total", report)
+	}
+}
+
+func TestService_Run_WriteRetry(t *testing.T) {
+	body := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"
+	tests := []struct {
+		name              string
+		transientFailures int
+		createBatchErr    error
+		budget            int
+		wantWritten       int
+		wantRetries       int64
+		wantDeadLetters   int
+		wantClass         string
+		wantAttempts      int
+	}{
+		{
+			name:              "retryable errors should be retried until the write succeeds",
+			transientFailures: 2,
+			budget:            100,
+			wantWritten:       3,
+			wantRetries:       6,
+		},
+		{
+			name:              "exhausted retries should be dead-lettered",
+			transientFailures: 10,
+			budget:            100,
+			wantRetries:       6,
+			wantDeadLetters:   3,
+			wantClass:         "retryable",
+			wantAttempts:      3,
+		},
+		{
+			name:              "retries over the budget should be dead-lettered",
+			transientFailures: 10,
+			budget:            2,
+			wantRetries:       2,
+			wantDeadLetters:   3,
+			wantClass:         "retryable",
+		},
+		{
+			name:            "permanent errors should be dead-lettered without retries",
+			createBatchErr:  errors.New("create batch error"),
+			budget:          100,
+			wantWritten:     3,
+			wantDeadLetters: 3,
+			wantClass:       "permanent",
+			wantAttempts:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{transientFailures: tt.transientFailures, createBatchErr: tt.createBatchErr}
+			deadLetterStorage := &mockDeadLetterStorage{}
+			s3Data := config.S3{BucketName: "test", ObjectKey: "test", WriteRetry: config.WriteRetry{
+				Retry:  config.Retry{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Jitter: 0.5},
+				Budget: tt.budget,
+			}}
+			s := newRunService(body, s3Data, productStorage, &mockObjectInfoStorage{},
+				service.WithDBWriteWorkerCount(1),
+				service.WithBatchSize(10),
+				service.WithDeadLetterStorage(deadLetterStorage),
+			)
+			if err := s.Run(context.Background()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if len(productStorage.written) != tt.wantWritten {
+				t.Errorf("products written = %d, want %d", len(productStorage.written), tt.wantWritten)
+			}
+			report := s.Report()
+			if report.WriteRetries != tt.wantRetries {
+				t.Errorf("Report().WriteRetries = %d, want %d", report.WriteRetries, tt.wantRetries)
+			}
+			if report.DeadLettered != int64(tt.wantDeadLetters) || len(deadLetterStorage.deadLetters) != tt.wantDeadLetters {
+				t.Errorf("dead letters = %d (reported %d), want %d", len(deadLetterStorage.deadLetters), report.DeadLettered, tt.wantDeadLetters)
+			}
+			for _, deadLetter := range deadLetterStorage.deadLetters {
+				if deadLetter.Class != tt.wantClass || deadLetter.ObjectKey != "test" {
+					t.Errorf("dead letter of product %d = %+v, want class %s", deadLetter.Product.ID, deadLetter, tt.wantClass)
+				}
+				if tt.wantAttempts > 0 && deadLetter.Attempts != tt.wantAttempts {
+					t.Errorf("dead letter of product %d attempts = %d, want %d", deadLetter.Product.ID, deadLetter.Attempts, tt.wantAttempts)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Run_SharedLimiter(t *testing.T) {
+	body := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n"
+	productStorage := &mockProductStorage{writeDelay: 5 * time.Millisecond}
+	limiter := throttle.New(throttle.WithMaxInFlight(1))
+	wg := sync.WaitGroup{}
+	for _, key := range []string{"first", "second"} {
+		s := newRunService(body, config.S3{BucketName: "test", ObjectKey: key}, productStorage, &mockObjectInfoStorage{},
+			service.WithBatchSize(1),
+			service.WithLimiter(limiter),
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Run(context.Background()); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(productStorage.written) != 8 {
+		t.Errorf("products written = %d, want 8", len(productStorage.written))
+	}
+	if productStorage.peakInFlight != 1 {
+		t.Errorf("batch writes in flight = %d, want 1", productStorage.peakInFlight)
+	}
+}
+
+func TestService_Run_SharedPools(t *testing.T) {
+	bodies := map[string]string{
+		"first":     "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n",
+		"second":    "{\"id\":5}\n{\"id\":6}\n{\"id\":7}\n{\"id\":8}\n",
+		"malformed": "{\"id\":9}\nnot json\n{\"id\":10}\n{\"id\"\n",
+	}
+	wantRejected := map[string]int64{"first": 0, "second": 0, "malformed": 2}
+	productStorage := &mockProductStorage{writeDelay: 5 * time.Millisecond}
+	parserPool, writerPool := pool.New(1), pool.New(2)
+	defer parserPool.Close()
+	defer writerPool.Close()
+	wg := sync.WaitGroup{}
+	for key, body := range bodies {
+		s := newRunService(body, config.S3{BucketName: "test", ObjectKey: key}, productStorage, &mockObjectInfoStorage{},
+			service.WithBatchSize(1),
+			service.WithParserPool(parserPool),
+			service.WithWriterPool(writerPool),
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Run(context.Background()); err != nil {
+				t.Errorf("Run() of %s error = %v", key, err)
+			}
+			if got := s.Report().Rejected; got != wantRejected[key] {
+				t.Errorf("rejected lines of %s = %d, want %d", key, got, wantRejected[key])
+			}
+		}()
+	}
+	wg.Wait()
+	if len(productStorage.written) != 10 {
+		t.Errorf("products written = %d, want 10", len(productStorage.written))
+	}
+	if productStorage.peakInFlight > writerPool.Size() {
+		t.Errorf("batch writes in flight = %d, want at most %d", productStorage.peakInFlight, writerPool.Size())
+	}
+}
+
+func TestService_Run_Interrupted(t *testing.T) {
+	// the batches of the products received before the shutdown are written within the flush deadline,
+	// by the single batch of the writer or by the batches of the partitions of the ordered writes.
+	for _, ordered := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ordered %v", ordered), func(t *testing.T) {
+			productStorage := &mockProductStorage{}
+			objectInfoStorage := &mockObjectInfoStorage{}
+			lines := make(chan string)
+			s := service.New(
+				service.WithS3Data(config.S3{BucketName: "test", ObjectKey: "test", OrderedWrites: ordered}),
+				service.WithS3Client(&mockS3Client{
+					mockHeadBucket: func(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+						return &s3.HeadBucketOutput{}, nil
+					},
+					mockHeadObject: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+						return &s3.HeadObjectOutput{}, nil
+					},
+					mockGetObject: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+						// the body is closed when the request context is done, like the body of an S3 response.
+						pr, pw := io.Pipe()
+						go func() {
+							for {
+								select {
+								case line := <-lines:
+									_, _ = pw.Write([]byte(line + "\n"))
+								case <-ctx.Done():
+									_ = pw.CloseWithError(ctx.Err())
+									return
+								}
+							}
+						}()
+						return &s3.GetObjectOutput{Body: pr, ContentType: new(string), ContentLength: new(int64), ETag: aws.String("etag")}, nil
+					},
+				}),
+				service.WithProductStorage(productStorage),
+				service.WithObjectInfoStorage(objectInfoStorage),
+				service.WithS3OutChan(make(chan *s3.GetObjectOutput, 1)),
+				service.WithLineChannel(make(chan string)),
+				service.WithProductChannel(make(chan model.Product)),
+				service.WithLineHandlerWorkerCount(1),
+				service.WithDBWriteWorkerCount(2),
+				service.WithBatchSize(100),
+				service.WithFlushInterval(time.Hour),
+				service.WithFlushDeadline(time.Second),
+				service.WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil))),
+			)
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- s.Run(ctx)
+			}()
+			for i := 1; i <= 3; i++ {
+				lines <- fmt.Sprintf("{\"id\":%d}", i)
+			}
+			// wait until the products are batched, the flush interval does not elapse before the shutdown.
+			for s.Report().Parsed < 3 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			select {
+			case err := <-errChan:
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run() did not return after the context is cancelled")
+			}
+			if len(productStorage.written) != 3 {
+				t.Errorf("products written = %d, want the 3 products of the batches in flight", len(productStorage.written))
+			}
+			if objectInfoStorage.status != model.ObjectStatusInterrupted {
+				t.Errorf("status = %q, want %q", objectInfoStorage.status, model.ObjectStatusInterrupted)
+			}
+		})
+	}
+}
+
+func TestService_Run_Resume(t *testing.T) {
+	errETagExists := customerror.New(constant.ErrETagExists, true)
+	tests := []struct {
+		name        string
+		interrupted bool
+		wantErr     bool
+		wantWritten int
+		wantStatus  string
+	}{
+		{name: "interrupted object is resumed", interrupted: true, wantWritten: 2, wantStatus: model.ObjectStatusDone},
+		{name: "done object is a duplicate", interrupted: false, wantErr: true, wantWritten: 0, wantStatus: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{}
+			objectInfoStorage := &mockObjectInfoStorage{createErr: errETagExists, interrupted: tt.interrupted}
+			s := newRunService("{\"id\":1}\n{\"id\":2}\n", config.S3{BucketName: "test", ObjectKey: "test"}, productStorage, objectInfoStorage)
+			err := s.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var ce *customerror.Error
+			if err != nil && (!errors.As(err, &ce) || ce.Message != constant.ErrObjectLoaded) {
+				t.Errorf("Run() error = %v, want %q", err, constant.ErrObjectLoaded)
+			}
+			if len(productStorage.written) != tt.wantWritten {
+				t.Errorf("products written = %d, want %d", len(productStorage.written), tt.wantWritten)
+			}
+			if objectInfoStorage.status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", objectInfoStorage.status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestService_Run_Transformers(t *testing.T) {
+	body := "{\"id\":1,\"price\":10}\n{\"id\":2,\"price\":20}\n{\"id\":3}\n{\"id\":4}\n{\"id\":5}\n"
+	chain := transform.Chain{
+		transform.Func(func(ctx context.Context, product model.Product) ([]model.Product, error) {
+			switch product.ID {
+			case 2:
+				variant := product
+				variant.ID = 20
+				return []model.Product{product, variant}, nil
+			case 3:
+				return nil, nil
+			case 4:
+				return nil, errors.New("transform error")
+			case 5:
+				panic("transform panic")
+			}
+			product.Price *= 2
+			return []model.Product{product}, nil
+		}),
+	}
+	productStorage := &mockProductStorage{}
+	s := newRunService(body, config.S3{BucketName: "test", ObjectKey: "test", Source: "test/test"}, productStorage, &mockObjectInfoStorage{},
+		service.WithTransformer(chain),
+	)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	written := make(map[int]model.Product)
+	for _, product := range productStorage.written {
+		written[product.ID] = product
+	}
+	if len(written) != 3 || written[1].Price != 20 || written[2].Price != 20 || written[20].Price != 20 {
+		t.Errorf("written products = %+v, want 1 with doubled price, 2 and its variant 20", productStorage.written)
+	}
+	if written[20].Source != "test/test" {
+		t.Errorf("source of the variant = %q, want %q", written[20].Source, "test/test")
+	}
+	if got := s.Report().Rejected; got != 2 {
+		t.Errorf("rejected lines = %d, want 2", got)
+	}
+}
+
+func TestService_Run_Filter(t *testing.T) {
+	body := "{\"id\":1,\"category\":\"electronics\",\"price\":10}\n" +
+		"{\"id\":2,\"category\":\"electronics\"}\n" +
+		"{\"id\":3,\"category\":\"books\",\"price\":5}\n" +
+		"not json\n" +
+		"{\"op\":\"delete\",\"id\":4}\n"
+	f, err := filter.Compile(`category == "electronics" && price > 0`)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	productStorage := &mockProductStorage{}
+	s := newRunService(body, config.S3{BucketName: "test", ObjectKey: "test", Format: config.FormatCDC}, productStorage, &mockObjectInfoStorage{},
+		service.WithFilter(f),
+	)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(productStorage.written) != 1 || productStorage.written[0].ID != 1 {
+		t.Errorf("written products = %+v, want product 1", productStorage.written)
+	}
+	if len(productStorage.tombstones) != 1 || productStorage.tombstones[0].ID != 4 {
+		t.Errorf("deleted products = %+v, want product 4", productStorage.tombstones)
+	}
+	report := s.Report()
+	if report.Filtered != 2 || report.Rejected != 1 {
+		t.Errorf("filtered, rejected = %d, %d, want 2, 1", report.Filtered, report.Rejected)
+	}
+}
+
+func TestService_Run_Join(t *testing.T) {
+	bodies := map[string]string{
+		"products.jsonl": "{\"id\":1,\"title\":\"Phone\"}\n{\"id\":2,\"title\":\"Laptop\"}\n",
+		"prices.jsonl":   "{\"id\":1,\"price\":10}\n",
+	}
+	etags := map[string]string{"products.jsonl": "products", "prices.jsonl": "prices"}
+	s3Client := &mockS3Client{
+		mockHeadBucket: func(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+			return &s3.HeadBucketOutput{}, nil
+		},
+		mockHeadObject: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{ETag: aws.String(etags[*params.Key])}, nil
+		},
+		mockGetObject: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body:          io.NopCloser(strings.NewReader(bodies[*params.Key])),
+				ContentType:   new(string),
+				ContentLength: new(int64),
+				ETag:          aws.String(etags[*params.Key]),
+			}, nil
+		},
+	}
+	tests := []struct {
+		name     string
+		joinType string
+		wantIDs  []int
+	}{
+		{name: "left", joinType: config.JoinLeft, wantIDs: []int{1, 2}},
+		{name: "inner", joinType: config.JoinInner, wantIDs: []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{}
+			objectInfoStorage := &mockObjectInfoStorage{}
+			s3Data := config.S3{
+				BucketName: "test",
+				ObjectKey:  "products.jsonl",
+				Join: config.Join{
+					Type:          tt.joinType,
+					Sources:       []config.JoinSource{{BucketName: "test", ObjectKey: "prices.jsonl", Key: config.DefaultJoinKey}},
+					MemoryRecords: config.DefaultJoinMemoryRecords,
+				},
+			}
+			s := newRunService("", s3Data, productStorage, objectInfoStorage, service.WithS3Client(s3Client))
+			if err := s.Run(context.Background()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			var ids []int
+			for _, product := range productStorage.written {
+				ids = append(ids, product.ID)
+				if product.ID == 1 && (product.Title != "Phone" || product.Price != 10) {
+					t.Errorf("joined product = %+v, want title Phone and price 10", product)
+				}
+			}
+			sort.Ints(ids)
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("written products = %v, want %v", ids, tt.wantIDs)
+			}
+			if got := s.Report().Unmatched; got != 1 {
+				t.Errorf("unmatched lines = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestService_Run_Dedup(t *testing.T) {
+	// each of the 10 products is corrected 100 times, and the corrections span several chunks of lines.
+	var body strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&body, "{\"id\":%d,\"title\":\"v%d\",\"price\":%d}\n", i%10, i/10, (i*7)%100)
+	}
+	tests := []struct {
+		name  string
+		dedup config.Dedup
+		want  func(id int) string
+	}{
+		{
+			name:  "first",
+			dedup: config.Dedup{Policy: config.DedupFirst, MemoryRecords: config.DefaultDedupMemoryRecords},
+			want:  func(id int) string { return "v0" },
+		},
+		{
+			name:  "last",
+			dedup: config.Dedup{Policy: config.DedupLast, MemoryRecords: config.DefaultDedupMemoryRecords},
+			want:  func(id int) string { return "v99" },
+		},
+		{
+			name:  "last spilled",
+			dedup: config.Dedup{Policy: config.DedupLast, MemoryRecords: 2, SpillDir: t.TempDir()},
+			want:  func(id int) string { return "v99" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			productStorage := &mockProductStorage{}
+			s := newRunService(body.String(), config.S3{BucketName: "test", ObjectKey: "test", Dedup: tt.dedup}, productStorage, &mockObjectInfoStorage{},
+				service.WithLineHandlerWorkerCount(8),
+				service.WithDBWriteWorkerCount(8),
+				service.WithBatchSize(3),
+			)
+			if err := s.Run(context.Background()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if len(productStorage.written) != 10 {
+				t.Fatalf("products written = %d, want 10", len(productStorage.written))
+			}
+			for _, product := range productStorage.written {
+				if want := tt.want(product.ID); product.Title != want {
+					t.Errorf("title of %d = %s, want %s", product.ID, product.Title, want)
+				}
+			}
+			if got := s.Report().Deduplicated; got != 990 {
+				t.Errorf("deduplicated products = %d, want 990", got)
+			}
+		})
+	}
+}
+
+func TestService_Run_OrderedWrites(t *testing.T) {
+	// each of the 20 products is updated 50 times, and the updates span several chunks of lines.
+	var body strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&body, "{\"id\":%d,\"title\":\"%d\"}\n", i%20, i/20)
+	}
+	productStorage := &mockProductStorage{writeDelay: time.Millisecond}
+	s := newRunService(body.String(), config.S3{BucketName: "test", ObjectKey: "test", WriteMode: config.WriteModeReplace, OrderedWrites: true}, productStorage, &mockObjectInfoStorage{},
+		service.WithLineHandlerWorkerCount(8),
+		service.WithDBWriteWorkerCount(8),
+		service.WithBatchSize(5),
+	)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(productStorage.written) != 1000 {
+		t.Fatalf("products written = %d, want 1000", len(productStorage.written))
+	}
+	last := make(map[int]int)
+	for _, product := range productStorage.written {
+		version, _ := strconv.Atoi(product.Title)
+		if previous, ok := last[product.ID]; ok && version != previous+1 {
+			t.Fatalf("update %d of %d written after update %d", version, product.ID, previous)
+		}
+		last[product.ID] = version
+	}
+	if productStorage.peakInFlight < 2 {
+		t.Errorf("batch writes in flight = %d, want the partitions written concurrently", productStorage.peakInFlight)
+	}
+}
+
+func TestService_Run_DryRun(t *testing.T) {
+	body := `{"id":1,"title":"first"}
+{"id":2,"title":"changed"}
+{"id":3,"title":"third"}
+{"id":3,"title":"third again"}
+`
+	productStorage := &mockProductStorage{stored: map[int]model.Product{
+		1: {ID: 1, Title: "first"},
+		2: {ID: 2, Title: "second"},
+	}}
+	objectInfoStorage := &mockObjectInfoStorage{}
+	s := newRunService(body, config.S3{BucketName: "test", ObjectKey: "test", WriteMode: config.WriteModeReplace, OrderedWrites: true},
+		productstorage.NewDryRun(productStorage), objectInfoStorage,
+		service.WithDryRun(true),
+	)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(productStorage.written) != 0 {
+		t.Errorf("products written = %d, want 0", len(productStorage.written))
+	}
+	if objectInfoStorage.status != "" {
+		t.Errorf("object info status = %q, want the object info not written", objectInfoStorage.status)
+	}
+	report := s.Report()
+	if !report.DryRun {
+		t.Error("DryRun = false, want true")
+	}
+	if report.Inserted != 1 || report.Updated != 2 || report.Unchanged != 1 {
+		t.Errorf("Inserted = %d Updated = %d Unchanged = %d, want 1, 2 and 1", report.Inserted, report.Updated, report.Unchanged)
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/retry"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/sink"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// secondary is a secondary sink of the service. Its workers read the products from its own buffer,
+// so a slow or failing sink does not block the database writes.
+type secondary struct {
+	cfg  config.Sink
+	sink sink.Sink
+	ch   chan model.Product
+	wg   sync.WaitGroup
+
+	written atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+	retries atomic.Int64
+}
+
+// WithSink adds a secondary sink that receives every parsed product of the S3 object.
+func WithSink(cfg config.Sink, s sink.Sink) Option {
+	return func(svc *service) {
+		svc.sinks = append(svc.sinks, &secondary{
+			cfg:  cfg,
+			sink: s,
+			ch:   make(chan model.Product, max(cfg.Buffer, 1)),
+		})
+	}
+}
+
+// fanOut hands the product to each secondary sink without waiting. If the buffer of a sink is full, the product is dropped for that sink.
+func (s *service) fanOut(product model.Product) {
+	for _, sec := range s.sinks {
+		select {
+		case sec.ch <- product:
+		default:
+			sec.dropped.Add(1)
+		}
+	}
+}
+
+// startSinks starts the workers of the secondary sinks. They run until their buffers are closed by closeSinks.
+func (s *service) startSinks(ctx context.Context) {
+	for _, sec := range s.sinks {
+		for i := 0; i < max(sec.cfg.Workers, 1); i++ {
+			sec.wg.Add(1)
+			go func(sec *secondary) {
+				defer sec.wg.Done()
+				s.sinkWorker(ctx, sec)
+			}(sec)
+		}
+	}
+}
+
+func (s *service) closeSinks() {
+	for _, sec := range s.sinks {
+		close(sec.ch)
+	}
+}
+
+// waitSinks waits until the secondary sinks write their remaining products.
+func (s *service) waitSinks() {
+	for _, sec := range s.sinks {
+		sec.wg.Wait()
+	}
+}
+
+// sinkWorker reads the products from the buffer of the sink into a batch and writes it with the retry policy of the sink
+// when it is full, when the flush interval of the sink elapses or when the buffer is closed.
+func (s *service) sinkWorker(ctx context.Context, sec *secondary) {
+	b := newBatch(sec.cfg.BatchSize, 0)
+	var tick <-chan time.Time
+	if sec.cfg.FlushInterval > 0 {
+		ticker := time.NewTicker(sec.cfg.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case product, ok := <-sec.ch:
+			if !ok {
+				s.flushSink(ctx, sec, b)
+				return
+			}
+			if b.add(product) {
+				s.flushSink(ctx, sec, b)
+			}
+		case <-tick:
+			s.flushSink(ctx, sec, b)
+		}
+	}
+}
+
+func (s *service) flushSink(ctx context.Context, sec *secondary, b *batch) {
+	products := b.take()
+	if len(products) == 0 {
+		return
+	}
+	retries, err := retry.Do(ctx, sec.cfg.Retry, func(ctx context.Context) error {
+		return sec.sink.Write(ctx, products)
+	})
+	sec.retries.Add(int64(retries))
+	if err != nil {
+		sec.failed.Add(int64(len(products)))
+		s.logger.Error(fmt.Sprintf("sink %s of %s failed: %v", sec.cfg.Name, s.s3Data.ObjectKey, err))
+		return
+	}
+	sec.written.Add(int64(len(products)))
+}
+
+// sinkReports returns the outcome of each secondary sink keyed by its name.
+func (s *service) sinkReports() map[string]model.SinkReport {
+	if len(s.sinks) == 0 {
+		return nil
+	}
+	reports := make(map[string]model.SinkReport, len(s.sinks))
+	for _, sec := range s.sinks {
+		reports[sec.cfg.Name] = model.SinkReport{
+			Written: sec.written.Load(),
+			Failed:  sec.failed.Load(),
+			Dropped: sec.dropped.Load(),
+			Retries: sec.retries.Load(),
+		}
+	}
+	return reports
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"sync"
+)
+
+// seenIDs is the set of product IDs seen in a run. Writer workers update it concurrently.
+type seenIDs struct {
+	mu  sync.Mutex
+	ids map[int]struct{}
+}
+
+func newSeenIDs() *seenIDs {
+	return &seenIDs{ids: make(map[int]struct{})}
+}
+
+func (s *seenIDs) see(products []model.Product) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, product := range products {
+		s.ids[product.ID] = struct{}{}
+	}
+}
+
+func (s *seenIDs) has(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ids[id]
+	return ok
+}
+
+// syncSnapshot deletes the products of the source that are not seen in the run.
+// If the fraction of the products to be deleted is greater than the MaxDeleteRatio of the snapshot, nothing is deleted and an error is returned.
+func (s *service) syncSnapshot(ctx context.Context) error {
+	ids, err := s.productStorage.FindIDsBySource(ctx, s.s3Data.Source)
+	if err != nil {
+		return err
+	}
+	var missing []int
+	for _, id := range ids {
+		if !s.seen.has(id) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	ratio := float64(len(missing)) / float64(len(ids))
+	if ratio > s.s3Data.Snapshot.MaxDeleteRatio {
+		return customerror.New(constant.ErrSnapshotLimit, true).
+			Wrap(fmt.Errorf("service.syncSnapshot: %d of %d products would be deleted", len(missing), len(ids))).
+			AddData(fmt.Sprintf("source: %s ratio: %.4f max: %.4f", s.s3Data.Source, ratio, s.s3Data.Snapshot.MaxDeleteRatio))
+	}
+	deleted, err := s.productStorage.DeleteByIDs(ctx, missing, s.s3Data.Snapshot.DeleteMode == config.DeleteModeHard)
+	s.report.deleted.Add(deleted)
+	if err != nil {
+		return err
+	}
+	s.logger.Info(fmt.Sprintf("Deleted %d products of %s missing from the snapshot", deleted, s.s3Data.Source))
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/errclass"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"time"
+)
+
+// throttledWrite writes the products when the limiter lets the batch through and reports the latency of the write to the limiter.
+// A write with a retryable error is reported as failed, since it is a sign of an overloaded database.
+// If the context is done while waiting, every product of the batch fails with the error of the context.
+func (s *service) throttledWrite(ctx context.Context, products []model.Product, write func(ctx context.Context, products []model.Product) productstorage.BatchResult) productstorage.BatchResult {
+	if s.limiter == nil {
+		return write(ctx, products)
+	}
+	if err := s.limiter.Acquire(ctx, len(products)); err != nil {
+		result := productstorage.BatchResult{Errors: make(map[int]error, len(products))}
+		for i := range products {
+			result.Errors[i] = customerror.New(constant.ErrWaitThrottle, true).
+				Wrap(fmt.Errorf("service.throttledWrite: %w", err)).AddData("err: " + err.Error())
+		}
+		return result
+	}
+	start := time.Now()
+	result := write(ctx, products)
+	failed := false
+	for _, err := range result.Errors {
+		if errclass.Classify(err).Retryable() {
+			failed = true
+			break
+		}
+	}
+	s.limiter.Release(time.Since(start), failed)
+	return result
+}
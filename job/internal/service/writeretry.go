@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/errclass"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/retry"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"sort"
+	"time"
+)
+
+// failedWrite is a product whose last write attempt failed.
+type failedWrite struct {
+	product model.Product
+	err     error
+	class   errclass.Class
+}
+
+// writeWithRetry writes the products and retries the products that fail with a retryable error.
+// The wait between the attempts follows the WriteRetry policy of the S3 object and each retried product takes one retry
+// from the budget of the object. The products that fail for good are written to the dead-letter storage.
+func (s *service) writeWithRetry(ctx context.Context, products []model.Product, write func(ctx context.Context, products []model.Product) productstorage.BatchResult) {
+	policy := s.s3Data.WriteRetry
+	var deadLetters []model.DeadLetter
+	for attempt := 1; len(products) > 0; attempt++ {
+		result := s.throttledWrite(ctx, products, write)
+		s.handleBatchResult(result)
+		indexes := make([]int, 0, len(result.Errors))
+		for i := range result.Errors {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		var pending []failedWrite
+		for _, i := range indexes {
+			err := result.Errors[i]
+			class := errclass.Classify(err)
+			if class.Retryable() && attempt < policy.MaxAttempts && s.takeRetry() {
+				pending = append(pending, failedWrite{product: products[i], err: err, class: class})
+				continue
+			}
+			if s.handleWriteError(err) {
+				deadLetters = append(deadLetters, s.deadLetter(products[i], err, class, attempt))
+			}
+		}
+		products = products[:0:0]
+		if len(pending) == 0 {
+			break
+		}
+		s.report.writeRetries.Add(int64(len(pending)))
+		if err := retry.Sleep(ctx, retry.Backoff(policy.Retry, attempt)); err != nil {
+			for _, failed := range pending {
+				if s.handleWriteError(failed.err) {
+					deadLetters = append(deadLetters, s.deadLetter(failed.product, failed.err, failed.class, attempt))
+				}
+			}
+			break
+		}
+		for _, failed := range pending {
+			products = append(products, failed.product)
+		}
+	}
+	s.saveDeadLetters(ctx, deadLetters)
+}
+
+// failWrites handles the error of the products that are not written and writes them to the dead-letter storage.
+func (s *service) failWrites(ctx context.Context, products []model.Product, err error) {
+	class := errclass.Classify(err)
+	var deadLetters []model.DeadLetter
+	for _, product := range products {
+		if s.handleWriteError(err) {
+			deadLetters = append(deadLetters, s.deadLetter(product, err, class, 1))
+		}
+	}
+	s.saveDeadLetters(ctx, deadLetters)
+}
+
+// takeRetry takes a retry from the retry budget of the S3 object. It reports false if the budget is used up.
+func (s *service) takeRetry() bool {
+	return s.retriesUsed.Add(1) <= int64(s.s3Data.WriteRetry.Budget)
+}
+
+// deadLetter returns the dead letter of the product that failed with the error after the given attempts.
+func (s *service) deadLetter(product model.Product, err error, class errclass.Class, attempts int) model.DeadLetter {
+	return model.DeadLetter{
+		Product:   product,
+		Source:    s.s3Data.Source,
+		ObjectKey: s.s3Data.ObjectKey,
+		Error:     err.Error(),
+		Class:     string(class),
+		Attempts:  attempts,
+		CreatedAt: time.Now(),
+	}
+}
+
+// saveDeadLetters writes the dead letters to the dead-letter storage. They are written even if the run is cancelled,
+// since the products are lost otherwise.
+func (s *service) saveDeadLetters(ctx context.Context, deadLetters []model.DeadLetter) {
+	if s.deadLetterStorage == nil || len(deadLetters) == 0 {
+		return
+	}
+	if err := s.deadLetterStorage.CreateBatch(context.WithoutCancel(ctx), deadLetters); err != nil {
+		s.logError(err)
+		return
+	}
+	s.report.deadLettered.Add(int64(len(deadLetters)))
+}
@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonlSink appends each product as a JSON line to a file. A batch is written with a single write,
+// so the lines of concurrent batches are not interleaved.
+type jsonlSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newJSONL(path string) (*jsonlSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, customerror.New(constant.ErrOpenSink, true).
+			Wrap(fmt.Errorf("sink: failed to create directory: %w", err)).AddData("err: " + err.Error())
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, customerror.New(constant.ErrOpenSink, true).
+			Wrap(fmt.Errorf("sink: failed to open file: %w", err)).AddData("err: " + err.Error())
+	}
+	return &jsonlSink{file: file}, nil
+}
+
+// Write method appends the products to the file.
+func (s *jsonlSink) Write(ctx context.Context, products []model.Product) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, product := range products {
+		if err := encoder.Encode(product); err != nil {
+			return customerror.New(constant.ErrWriteSink, true).
+				Wrap(fmt.Errorf("sink: failed to encode product: %w", err)).AddData("err: " + err.Error())
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return customerror.New(constant.ErrWriteSink, true).
+			Wrap(fmt.Errorf("sink: failed to write file: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// Close method closes the file.
+func (s *jsonlSink) Close() error {
+	return s.file.Close()
+}
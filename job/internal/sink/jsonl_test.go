@@ -0,0 +1,42 @@
+package sink_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/sink"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export", "products.jsonl")
+	s, err := sink.New(config.Sink{Name: "export", Type: config.SinkTypeJSONL, Path: path})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, s.Write(context.TODO(), []model.Product{{ID: 1, Title: "a"}, {ID: 2, Title: "b"}}))
+	assert.Nil(t, s.Write(context.TODO(), []model.Product{{ID: 3, Title: "c"}}))
+	assert.Nil(t, s.Close())
+
+	content, err := os.ReadFile(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 3) {
+		var product model.Product
+		assert.Nil(t, json.Unmarshal([]byte(lines[2]), &product))
+		assert.Equal(t, 3, product.ID)
+		assert.Equal(t, "c", product.Title)
+	}
+}
+
+func TestNew_UnknownType(t *testing.T) {
+	_, err := sink.New(config.Sink{Name: "export", Type: "parquet"})
+	assert.NotNil(t, err)
+}
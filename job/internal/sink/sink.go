@@ -0,0 +1,27 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+)
+
+// Sink is a secondary destination of the products. Write is called concurrently by the workers of the sink.
+type Sink interface {
+	Write(ctx context.Context, products []model.Product) error
+	Close() error
+}
+
+// New opens the sink of the config.
+func New(cfg config.Sink) (Sink, error) {
+	switch cfg.Type {
+	case config.SinkTypeJSONL:
+		return newJSONL(cfg.Path)
+	default:
+		return nil, customerror.New(constant.ErrOpenSink, true).
+			Wrap(fmt.Errorf("sink: unknown sink type %q", cfg.Type)).AddData("sink: " + cfg.Name)
+	}
+}
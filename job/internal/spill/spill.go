@@ -0,0 +1,93 @@
+package spill
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Partitions are files that records are appended to by key, so an input that does not fit in memory can be
+// processed one partition at a time. A record is a single line and must not contain a newline.
+// Partitions are not safe for concurrent use.
+type Partitions struct {
+	dir     string
+	files   []*os.File
+	writers []*bufio.Writer
+}
+
+// New creates n partition files in a new temporary directory in dir. If dir is empty, the temporary directory
+// of the system is used. Close must be called to remove the files.
+func New(dir, pattern string, n int) (*Partitions, error) {
+	dir, err := os.MkdirTemp(dir, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("spill: create directory: %w", err)
+	}
+	p := &Partitions{dir: dir}
+	for i := 0; i < n; i++ {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("%04d", i)))
+		if err != nil {
+			_ = p.Close()
+			return nil, fmt.Errorf("spill: create partition file: %w", err)
+		}
+		p.files = append(p.files, file)
+		p.writers = append(p.writers, bufio.NewWriter(file))
+	}
+	return p, nil
+}
+
+// Len returns the number of partitions.
+func (p *Partitions) Len() int {
+	return len(p.files)
+}
+
+// Write appends the record to the partition of the key. A negative key is converted to unsigned,
+// so every key, including math.MinInt, has a partition.
+func (p *Partitions) Write(key int, record string) error {
+	if _, err := p.writers[uint64(key)%uint64(len(p.writers))].WriteString(record + "\n"); err != nil {
+		return fmt.Errorf("spill: write partition file: %w", err)
+	}
+	return nil
+}
+
+// Read calls fn for each record of the partition in the order the records are written.
+func (p *Partitions) Read(index int, fn func(record string) error) error {
+	if err := p.writers[index].Flush(); err != nil {
+		return fmt.Errorf("spill: flush partition file: %w", err)
+	}
+	if _, err := p.files[index].Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spill: seek partition file: %w", err)
+	}
+	defer func() {
+		// later records are appended after the records that are read.
+		_, _ = p.files[index].Seek(0, io.SeekEnd)
+	}()
+	r := bufio.NewReader(p.files[index])
+	for {
+		record, err := r.ReadString('\n')
+		if record != "" {
+			if err := fn(strings.TrimSuffix(record, "\n")); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("spill: read partition file: %w", err)
+		}
+	}
+}
+
+// Close closes and removes the partition files.
+func (p *Partitions) Close() error {
+	var errs []error
+	for _, file := range p.files {
+		errs = append(errs, file.Close())
+	}
+	errs = append(errs, os.RemoveAll(p.dir))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,57 @@
+package spill_test
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/spill"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPartitions(t *testing.T) {
+	dir := t.TempDir()
+	p, err := spill.New(dir, "test-*", 2)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for key, record := range []string{"a", "b", "c", "d"} {
+		if err := p.Write(key, record); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	read := func(index int) []string {
+		var records []string
+		if err := p.Read(index, func(record string) error {
+			records = append(records, record)
+			return nil
+		}); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		return records
+	}
+	if got := read(0); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Read(0) = %v, want [a c]", got)
+	}
+	// a record written after a read is appended to the partition.
+	if err := p.Write(1, "e"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got := read(1); !reflect.DeepEqual(got, []string{"b", "d", "e"}) {
+		t.Errorf("Read(1) = %v, want [b d e]", got)
+	}
+	// a negative key has a partition, even the one without an absolute value.
+	for _, key := range []int{-1, math.MinInt} {
+		if err := p.Write(key, "f"); err != nil {
+			t.Fatalf("Write(%d) error = %v", key, err)
+		}
+	}
+	if got := len(read(0)) + len(read(1)); got != 7 {
+		t.Errorf("records = %d, want 7", got)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("partition directories = %d, want 0 after Close()", len(entries))
+	}
+}
@@ -0,0 +1,40 @@
+package deadletterstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DeadLetterStorer stores the products that could not be written.
+type DeadLetterStorer interface {
+	CreateIndex(ctx context.Context) error
+	CreateBatch(ctx context.Context, deadLetters []model.DeadLetter) error
+}
+
+type deadLetterStorage struct {
+	collectionName string
+	db             *mongo.Database
+}
+
+type Option func(*deadLetterStorage)
+
+func WithDeadLetterCollection(collection string) Option {
+	return func(s *deadLetterStorage) {
+		s.collectionName = collection
+	}
+}
+
+func WithDB(db *mongo.Database) Option {
+	return func(s *deadLetterStorage) {
+		s.db = db
+	}
+}
+
+func New(opts ...Option) DeadLetterStorer {
+	s := &deadLetterStorage{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
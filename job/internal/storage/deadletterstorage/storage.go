@@ -0,0 +1,39 @@
+package deadletterstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIndex method creates an index for the source and creation time fields of the dead-letter collection.
+func (s *deadLetterStorage) CreateIndex(ctx context.Context) error {
+	if _, err := s.db.Collection(s.collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "source", Value: 1}, {Key: "created_at", Value: 1}},
+	}); err != nil {
+		return customerror.New(constant.ErrCreateIndexFailed, true).
+			Wrap(fmt.Errorf("deadletterstorage: failed to create index: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// CreateBatch method inserts the dead letters with an unordered insert.
+func (s *deadLetterStorage) CreateBatch(ctx context.Context, deadLetters []model.DeadLetter) error {
+	if len(deadLetters) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(deadLetters))
+	for i, deadLetter := range deadLetters {
+		documents[i] = deadLetter
+	}
+	if _, err := s.db.Collection(s.collectionName).InsertMany(ctx, documents, options.InsertMany().SetOrdered(false)); err != nil {
+		return customerror.New(constant.ErrCreateDeadLetters, true).
+			Wrap(fmt.Errorf("deadletterstorage: failed to create dead letters: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
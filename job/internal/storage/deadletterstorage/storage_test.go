@@ -0,0 +1,58 @@
+package deadletterstorage_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/deadletterstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+func Test_deadLetterStorage_CreateBatch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	deadLetters := []model.DeadLetter{
+		{Product: model.Product{ID: 1}, ObjectKey: "products.jsonl", Error: "write error", Class: "network", Attempts: 3, CreatedAt: time.Now()},
+		{Product: model.Product{ID: 2}, ObjectKey: "products.jsonl", Error: "write error", Class: "validation", Attempts: 1, CreatedAt: time.Now()},
+	}
+
+	mt.Run("Case Success CreateBatch", func(mt *mtest.T) {
+		mockCollection := deadletterstorage.New(
+			deadletterstorage.WithDB(mt.DB),
+			deadletterstorage.WithDeadLetterCollection("dead_letters"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := mockCollection.CreateBatch(context.TODO(), deadLetters)
+		assert.Nil(t, err)
+		command := mt.GetStartedEvent().Command
+		documents, _ := command.Lookup("documents").Array().Values()
+		assert.Len(t, documents, 2)
+		assert.Equal(t, "network", documents[0].Document().Lookup("class").StringValue())
+	})
+
+	mt.Run("Case Empty CreateBatch", func(mt *mtest.T) {
+		mockCollection := deadletterstorage.New(
+			deadletterstorage.WithDB(mt.DB),
+			deadletterstorage.WithDeadLetterCollection("dead_letters"),
+		)
+		err := mockCollection.CreateBatch(context.TODO(), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, mt.GetStartedEvent())
+	})
+
+	mt.Run("Case CreateBatch Error", func(mt *mtest.T) {
+		mockCollection := deadletterstorage.New(
+			deadletterstorage.WithDB(mt.DB),
+			deadletterstorage.WithDeadLetterCollection("dead_letters"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		err := mockCollection.CreateBatch(context.TODO(), deadLetters)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrCreateDeadLetters, ce.Message)
+		}
+	})
+}
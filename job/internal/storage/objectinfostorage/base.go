@@ -9,6 +9,8 @@ import (
 type ObjectInfoStorer interface {
 	CreateIndex(ctx context.Context) error
 	Create(ctx context.Context, objectPartition model.ObjectInfo) error
+	Finish(ctx context.Context, etag string, status string, report model.Report) error
+	Resume(ctx context.Context, etag string) (bool, error)
 }
 
 type objectInfoStorage struct {
@@ -33,3 +33,29 @@ func (s *objectInfoStorage) Create(ctx context.Context, object model.ObjectInfo)
 	}
 	return nil
 }
+
+// Finish method stores the final status and the ingestion report of the object identified by the ETag.
+func (s *objectInfoStorage) Finish(ctx context.Context, etag string, status string, report model.Report) error {
+	if _, err := s.db.Collection(s.collectionName).UpdateOne(ctx,
+		bson.M{"etag": etag},
+		bson.M{"$set": bson.M{"status": status, "report": report}},
+	); err != nil {
+		return customerror.New(constant.ErrUpdateObjectInfo, true).
+			Wrap(fmt.Errorf("objectinfostorage: failed to update report: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// Resume method marks the interrupted object identified by the ETag as running again.
+// It reports false if the object is not interrupted, so only one run can resume it.
+func (s *objectInfoStorage) Resume(ctx context.Context, etag string) (bool, error) {
+	res, err := s.db.Collection(s.collectionName).UpdateOne(ctx,
+		bson.M{"etag": etag, "status": model.ObjectStatusInterrupted},
+		bson.M{"$set": bson.M{"status": model.ObjectStatusRunning}},
+	)
+	if err != nil {
+		return false, customerror.New(constant.ErrUpdateObjectInfo, true).
+			Wrap(fmt.Errorf("objectinfostorage: failed to resume object info: %w", err)).AddData("err: " + err.Error())
+	}
+	return res.MatchedCount > 0, nil
+}
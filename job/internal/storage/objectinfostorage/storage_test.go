@@ -7,6 +7,7 @@ import (
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 	"testing"
 )
@@ -121,3 +122,59 @@ func TestObjectInfoStorage_Create(t *testing.T) {
 		mt.ClearEvents()
 	})
 }
+
+func TestObjectInfoStorage_Finish(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Finish Error", func(mt *mtest.T) {
+		mockCollection := objectinfostorage.New(
+			objectinfostorage.WithDB(mt.DB),
+			objectinfostorage.WithObjectCollection("objects-info"),
+		)
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Code:    2,
+			Message: "unknown error",
+		}))
+		err := mockCollection.Finish(context.TODO(), "1234321", model.ObjectStatusDone, model.Report{LinesRead: 10})
+		assert.NotNil(t, err)
+		var ce *customerror.Error
+		if !assert.ErrorAs(t, err, &ce) {
+			t.Fatalf("error should be of type ErrUpdateObjectInfo")
+		}
+	})
+
+	mt.Run("Case Success Finish", func(mt *mtest.T) {
+		mockCollection := objectinfostorage.New(
+			objectinfostorage.WithDB(mt.DB),
+			objectinfostorage.WithObjectCollection("objects-info"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := mockCollection.Finish(context.TODO(), "1234321", model.ObjectStatusDone, model.Report{LinesRead: 10})
+		assert.Nil(t, err)
+	})
+}
+
+func TestObjectInfoStorage_Resume(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name    string
+		matched int32
+		want    bool
+	}{
+		{name: "Case Interrupted Object Resumed", matched: 1, want: true},
+		{name: "Case Object Not Interrupted", matched: 0, want: false},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mockCollection := objectinfostorage.New(
+				objectinfostorage.WithDB(mt.DB),
+				objectinfostorage.WithObjectCollection("objects-info"),
+			)
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: tt.matched}, {Key: "nModified", Value: tt.matched}})
+			resumed, err := mockCollection.Resume(context.TODO(), "1234321")
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, resumed)
+		})
+	}
+}
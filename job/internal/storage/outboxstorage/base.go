@@ -0,0 +1,42 @@
+package outboxstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OutboxStorer reads the product change events that the product storage writes to the outbox.
+type OutboxStorer interface {
+	CreateIndex(ctx context.Context) error
+	FindUnpublished(ctx context.Context, limit int) ([]model.OutboxEvent, error)
+	MarkPublished(ctx context.Context, ids []primitive.ObjectID) error
+}
+
+type outboxStorage struct {
+	collectionName string
+	db             *mongo.Database
+}
+
+type Option func(*outboxStorage)
+
+func WithOutboxCollection(collection string) Option {
+	return func(s *outboxStorage) {
+		s.collectionName = collection
+	}
+}
+
+func WithDB(db *mongo.Database) Option {
+	return func(s *outboxStorage) {
+		s.db = db
+	}
+}
+
+func New(opts ...Option) OutboxStorer {
+	s := &outboxStorage{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
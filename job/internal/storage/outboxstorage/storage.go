@@ -0,0 +1,58 @@
+package outboxstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// CreateIndex method creates an index for the publish time and ID fields of the outbox collection.
+func (s *outboxStorage) CreateIndex(ctx context.Context) error {
+	if _, err := s.db.Collection(s.collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "_id", Value: 1}},
+	}); err != nil {
+		return customerror.New(constant.ErrCreateIndexFailed, true).
+			Wrap(fmt.Errorf("outboxstorage: failed to create index: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// FindUnpublished method returns at most limit unpublished events in the order they are written.
+func (s *outboxStorage) FindUnpublished(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	cursor, err := s.db.Collection(s.collectionName).Find(ctx,
+		bson.M{"published_at": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, customerror.New(constant.ErrFindEvents, true).
+			Wrap(fmt.Errorf("outboxstorage: failed to find events: %w", err)).AddData("err: " + err.Error())
+	}
+	var events []model.OutboxEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, customerror.New(constant.ErrFindEvents, true).
+			Wrap(fmt.Errorf("outboxstorage: failed to decode events: %w", err)).AddData("err: " + err.Error())
+	}
+	return events, nil
+}
+
+// MarkPublished method sets the publish time of the events with the given IDs.
+func (s *outboxStorage) MarkPublished(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := s.db.Collection(s.collectionName).UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"published_at": time.Now()}},
+	); err != nil {
+		return customerror.New(constant.ErrMarkEvents, true).
+			Wrap(fmt.Errorf("outboxstorage: failed to mark events: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package outboxstorage_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/outboxstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_outboxStorage_FindUnpublished(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success FindUnpublished", func(mt *mtest.T) {
+		mockCollection := outboxstorage.New(
+			outboxstorage.WithDB(mt.DB),
+			outboxstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.outbox", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "type", Value: "product.deleted"}, {Key: "product_id", Value: 1}},
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "type", Value: "product.upserted"}, {Key: "product_id", Value: 2}},
+		))
+		events, err := mockCollection.FindUnpublished(context.TODO(), 10)
+		assert.Nil(t, err)
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, model.EventProductDeleted, events[0].Type)
+			assert.Equal(t, 2, events[1].ProductID)
+		}
+		command := mt.GetStartedEvent().Command
+		assert.Equal(t, int64(10), command.Lookup("limit").Int64())
+	})
+
+	mt.Run("Case FindUnpublished Error", func(mt *mtest.T) {
+		mockCollection := outboxstorage.New(
+			outboxstorage.WithDB(mt.DB),
+			outboxstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		_, err := mockCollection.FindUnpublished(context.TODO(), 10)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrFindEvents, ce.Message)
+		}
+	})
+}
+
+func Test_outboxStorage_MarkPublished(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success MarkPublished", func(mt *mtest.T) {
+		mockCollection := outboxstorage.New(
+			outboxstorage.WithDB(mt.DB),
+			outboxstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}))
+		err := mockCollection.MarkPublished(context.TODO(), []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()})
+		assert.Nil(t, err)
+		assert.Equal(t, "update", mt.GetStartedEvent().CommandName)
+	})
+
+	mt.Run("Case MarkPublished Error", func(mt *mtest.T) {
+		mockCollection := outboxstorage.New(
+			outboxstorage.WithDB(mt.DB),
+			outboxstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		err := mockCollection.MarkPublished(context.TODO(), []primitive.ObjectID{primitive.NewObjectID()})
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrMarkEvents, ce.Message)
+		}
+	})
+}
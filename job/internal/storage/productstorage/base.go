@@ -9,10 +9,41 @@ import (
 type ProductStorer interface {
 	CreateIndex(ctx context.Context) error
 	Create(ctx context.Context, product model.Product) error
+	CreateBatch(ctx context.Context, products []model.Product) BatchResult
+	ReplaceBatch(ctx context.Context, products []model.Product) BatchResult
+	MergeBatch(ctx context.Context, products []model.Product) BatchResult
+	FindByIDs(ctx context.Context, ids []int) (map[int]model.Product, error)
+	CreateHistory(ctx context.Context, history []model.ProductHistory) error
+	FindIDsBySource(ctx context.Context, source string) ([]int, error)
+	DeleteByIDs(ctx context.Context, ids []int, hard bool) (int64, error)
+	DeleteBatch(ctx context.Context, products []model.Product) BatchResult
+	Staging(ctx context.Context, runID string) (ProductStorer, error)
+	MergeStaging(ctx context.Context, runID string, whenMatched string) error
+	RenameStaging(ctx context.Context, runID string) error
+	DropStaging(ctx context.Context, runID string) error
+}
+
+// Values of the whenMatched argument of MergeStaging.
+const (
+	WhenMatchedKeepExisting = "keepExisting"
+	WhenMatchedReplace      = "replace"
+	WhenMatchedMerge        = "merge"
+)
+
+// BatchResult holds the outcome of a batch write.
+// Errors are keyed by the index of the product in the batch, so each failed product can be handled individually.
+type BatchResult struct {
+	Inserted  int64
+	Updated   int64
+	Unchanged int64
+	Deleted   int64
+	Errors    map[int]error
 }
 
 type productStorage struct {
 	productCollectionName string
+	historyCollectionName string
+	outboxCollectionName  string
 	db                    *mongo.Database
 }
 
@@ -24,6 +55,21 @@ func WithProductCollection(collection string) Option {
 	}
 }
 
+// WithHistoryCollection sets the collection that the previous versions of the changed products are appended to.
+func WithHistoryCollection(collection string) Option {
+	return func(s *productStorage) {
+		s.historyCollectionName = collection
+	}
+}
+
+// WithOutboxCollection sets the collection that the change events of the written products are appended to
+// in the same transaction as the write. Transactions require a replica set.
+func WithOutboxCollection(collection string) Option {
+	return func(s *productStorage) {
+		s.outboxCollectionName = collection
+	}
+}
+
 func WithDB(db *mongo.Database) Option {
 	return func(s *productStorage) {
 		s.db = db
@@ -0,0 +1,180 @@
+package productstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"sync"
+	"time"
+)
+
+// dryRunStorage simulates the writes of a product storage without changing it. The reads are delegated to the
+// storage, and the products written by the dry run are kept in memory on top of the stored products, so the
+// results of the batch writes are the results a real run would get.
+type dryRunStorage struct {
+	base ProductStorer
+
+	mu sync.Mutex
+	// written are the products written by the dry run.
+	written map[int]model.Product
+}
+
+// NewDryRun returns a product storage that reads from the base storage and only simulates the writes.
+// The staging storage of a dry run is the dry run itself, so an atomic load is simulated against the product collection.
+func NewDryRun(base ProductStorer) ProductStorer {
+	return &dryRunStorage{
+		base:    base,
+		written: make(map[int]model.Product),
+	}
+}
+
+func (s *dryRunStorage) CreateIndex(ctx context.Context) error {
+	return nil
+}
+
+func (s *dryRunStorage) Create(ctx context.Context, product model.Product) error {
+	result := s.CreateBatch(ctx, []model.Product{product})
+	return result.Errors[0]
+}
+
+// CreateBatch method reports the products whose ID does not exist as inserted.
+func (s *dryRunStorage) CreateBatch(ctx context.Context, products []model.Product) BatchResult {
+	return s.simulate(ctx, products, constant.ErrCreateProduct, func(current model.Product, exists bool, product model.Product) (model.Product, bool) {
+		return product, !exists
+	})
+}
+
+// ReplaceBatch method reports the missing products as inserted and the stored products as updated or unchanged.
+func (s *dryRunStorage) ReplaceBatch(ctx context.Context, products []model.Product) BatchResult {
+	return s.simulate(ctx, products, constant.ErrReplaceProduct, func(current model.Product, exists bool, product model.Product) (model.Product, bool) {
+		return product, true
+	})
+}
+
+// MergeBatch method reports the missing products as inserted and the stored products as updated or unchanged.
+func (s *dryRunStorage) MergeBatch(ctx context.Context, products []model.Product) BatchResult {
+	return s.simulate(ctx, products, constant.ErrMergeProduct, func(current model.Product, exists bool, product model.Product) (model.Product, bool) {
+		if !exists {
+			return product, true
+		}
+		return merged(current, product), true
+	})
+}
+
+// DeleteBatch method reports a tombstone for each product, like the writes of a real run.
+func (s *dryRunStorage) DeleteBatch(ctx context.Context, products []model.Product) BatchResult {
+	now := time.Now()
+	result := s.simulate(ctx, products, constant.ErrDeleteProducts, func(current model.Product, exists bool, product model.Product) (model.Product, bool) {
+		current.ID = product.ID
+		current.DeletedAt = &now
+		if product.Ts != 0 {
+			current.Ts = product.Ts
+		}
+		if product.Source != "" {
+			current.Source = product.Source
+		}
+		return current, true
+	})
+	result.Deleted = result.Inserted + result.Updated + result.Unchanged
+	result.Inserted, result.Updated, result.Unchanged = 0, 0, 0
+	return result
+}
+
+// simulate simulates the write of each product. write returns the product that would be stored and whether
+// the product would be written. A product that would not be written gets ErrIDExists, and an event that is not
+// newer than the event of the stored product gets ErrStaleEvent.
+func (s *dryRunStorage) simulate(ctx context.Context, products []model.Product, message string, write func(current model.Product, exists bool, product model.Product) (model.Product, bool)) BatchResult {
+	result := BatchResult{Errors: make(map[int]error)}
+	stored, err := s.current(ctx, products)
+	if err != nil {
+		for i := range products {
+			result.Errors[i] = batchError(message, err)
+		}
+		return result
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, product := range products {
+		current, exists := s.written[product.ID]
+		if !exists {
+			current, exists = stored[product.ID]
+		}
+		if exists && product.Ts != 0 && current.Ts != 0 && current.Ts >= product.Ts {
+			result.Errors[i] = customerror.New(constant.ErrStaleEvent, false).AddData(product.ID)
+			continue
+		}
+		next, ok := write(current, exists, product)
+		if !ok {
+			result.Errors[i] = customerror.New(constant.ErrIDExists, false).
+				Wrap(fmt.Errorf("productstorage: dry run: product %d exists", product.ID)).AddData(product.ID)
+			continue
+		}
+		switch {
+		case !exists:
+			result.Inserted++
+		case unchanged(current, next):
+			result.Unchanged++
+		default:
+			result.Updated++
+		}
+		s.written[product.ID] = next
+	}
+	return result
+}
+
+// current returns the stored products of the products that are not written by the dry run yet.
+func (s *dryRunStorage) current(ctx context.Context, products []model.Product) (map[int]model.Product, error) {
+	s.mu.Lock()
+	ids := make([]int, 0, len(products))
+	for _, product := range products {
+		if _, ok := s.written[product.ID]; !ok {
+			ids = append(ids, product.ID)
+		}
+	}
+	s.mu.Unlock()
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return s.base.FindByIDs(ctx, ids)
+}
+
+// unchanged reports whether storing next in place of current would not modify the stored product.
+func unchanged(current, next model.Product) bool {
+	return current.ContentHash() == next.ContentHash() && current.Source == next.Source &&
+		current.Ts == next.Ts && current.DeletedAt == nil && next.DeletedAt == nil
+}
+
+func (s *dryRunStorage) FindByIDs(ctx context.Context, ids []int) (map[int]model.Product, error) {
+	return s.base.FindByIDs(ctx, ids)
+}
+
+func (s *dryRunStorage) CreateHistory(ctx context.Context, history []model.ProductHistory) error {
+	return nil
+}
+
+func (s *dryRunStorage) FindIDsBySource(ctx context.Context, source string) ([]int, error) {
+	return s.base.FindIDsBySource(ctx, source)
+}
+
+// DeleteByIDs method reports the products as deleted without deleting them.
+func (s *dryRunStorage) DeleteByIDs(ctx context.Context, ids []int, hard bool) (int64, error) {
+	return int64(len(ids)), nil
+}
+
+func (s *dryRunStorage) Staging(ctx context.Context, runID string) (ProductStorer, error) {
+	return s, nil
+}
+
+func (s *dryRunStorage) MergeStaging(ctx context.Context, runID string, whenMatched string) error {
+	return nil
+}
+
+func (s *dryRunStorage) RenameStaging(ctx context.Context, runID string) error {
+	return nil
+}
+
+func (s *dryRunStorage) DropStaging(ctx context.Context, runID string) error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package productstorage_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_productStorage_DryRun(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case CreateBatch", func(mt *mtest.T) {
+		dryRun := productstorage.NewDryRun(productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		))
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "id", Value: 1}, {Key: "title", Value: "first"}},
+			),
+			mtest.CreateCursorResponse(0, "db.products", mtest.NextBatch),
+		)
+		result := dryRun.CreateBatch(context.TODO(), []model.Product{{ID: 1, Title: "first"}, {ID: 2, Title: "second"}})
+		assert.Equal(t, int64(1), result.Inserted)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, result.Errors[0], &ce) {
+			assert.Equal(t, constant.ErrIDExists, ce.Message)
+		}
+
+		// the product inserted by the dry run exists for the later batches without reading the collection.
+		events := len(mt.GetAllStartedEvents())
+		result = dryRun.CreateBatch(context.TODO(), []model.Product{{ID: 2, Title: "second"}})
+		assert.Equal(t, int64(0), result.Inserted)
+		assert.Len(t, result.Errors, 1)
+		assert.Len(t, mt.GetAllStartedEvents(), events)
+	})
+
+	mt.Run("Case ReplaceBatch", func(mt *mtest.T) {
+		dryRun := productstorage.NewDryRun(productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		))
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "id", Value: 1}, {Key: "title", Value: "first"}, {Key: "ts", Value: int64(10)}},
+				bson.D{{Key: "id", Value: 2}, {Key: "title", Value: "second"}},
+			),
+			mtest.CreateCursorResponse(0, "db.products", mtest.NextBatch),
+		)
+		result := dryRun.ReplaceBatch(context.TODO(), []model.Product{
+			{ID: 1, Title: "stale", Ts: 5},
+			{ID: 2, Title: "second"},
+			{ID: 3, Title: "third"},
+		})
+		assert.Equal(t, int64(1), result.Inserted)
+		assert.Equal(t, int64(0), result.Updated)
+		assert.Equal(t, int64(1), result.Unchanged)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, result.Errors[0], &ce) {
+			assert.Equal(t, constant.ErrStaleEvent, ce.Message)
+		}
+
+		result = dryRun.ReplaceBatch(context.TODO(), []model.Product{{ID: 3, Title: "third again"}})
+		assert.Equal(t, int64(1), result.Updated)
+	})
+
+	mt.Run("Case FindByIDs Error", func(mt *mtest.T) {
+		dryRun := productstorage.NewDryRun(productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		))
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    2,
+			Message: "command error",
+		}))
+		result := dryRun.MergeBatch(context.TODO(), []model.Product{{ID: 1}})
+		var ce *customerror.Error
+		if assert.ErrorAs(t, result.Errors[0], &ce) {
+			assert.Equal(t, constant.ErrMergeProduct, ce.Message)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package productstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// bulkWriteWithOutbox runs the write models and inserts the events of the written products in a transaction.
+// A write error aborts a transaction, so the products with write errors are removed from the batch
+// and the rest of the batch is written again in a new transaction until no write error is left.
+// If next is set, a product whose write leaves the stored product unchanged gets no event.
+func (s *productStorage) bulkWriteWithOutbox(ctx context.Context, products []model.Product, models []mongo.WriteModel, message string, eventType model.EventType, next func(current, product model.Product) model.Product) BatchResult {
+	result := BatchResult{Errors: make(map[int]error)}
+	pending := make([]int, len(products))
+	for i := range pending {
+		pending[i] = i
+	}
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		for _, i := range pending {
+			result.Errors[i] = batchError(message, err)
+		}
+		return result
+	}
+	defer session.EndSession(ctx)
+
+	for len(pending) > 0 {
+		pendingModels := make([]mongo.WriteModel, len(pending))
+		for n, i := range pending {
+			pendingModels[n] = models[i]
+		}
+		var res *mongo.BulkWriteResult
+		_, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			events, err := s.pendingEvents(sc, products, pending, eventType, next)
+			if err != nil {
+				return nil, err
+			}
+			res, err = s.db.Collection(s.productCollectionName).BulkWrite(sc, pendingModels, options.BulkWrite().SetOrdered(false))
+			if err != nil {
+				return nil, err
+			}
+			if len(events) == 0 {
+				return nil, nil
+			}
+			if _, err := s.db.Collection(s.outboxCollectionName).InsertMany(sc, events); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		})
+		if err == nil {
+			result.Inserted = res.InsertedCount + res.UpsertedCount
+			result.Updated = res.ModifiedCount
+			result.Unchanged = res.MatchedCount - res.ModifiedCount
+			return result
+		}
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+			for _, i := range pending {
+				result.Errors[i] = batchError(message, err)
+			}
+			return result
+		}
+		failed := make(map[int]bool, len(bwe.WriteErrors))
+		for _, we := range bwe.WriteErrors {
+			i := pending[we.Index]
+			result.Errors[i] = writeError(products[i], we, message)
+			failed[i] = true
+		}
+		rest := pending[:0]
+		for _, i := range pending {
+			if !failed[i] {
+				rest = append(rest, i)
+			}
+		}
+		pending = rest
+	}
+	return result
+}
+
+// pendingEvents returns the events of the pending products. If next is set, the stored products are read in the
+// transaction before they are written, and a product whose write leaves the stored product unchanged gets no event,
+// like the unchanged products of the change detection.
+func (s *productStorage) pendingEvents(ctx context.Context, products []model.Product, pending []int, eventType model.EventType, next func(current, product model.Product) model.Product) ([]interface{}, error) {
+	var stored map[int]model.Product
+	if next != nil {
+		ids := make([]int, len(pending))
+		for n, i := range pending {
+			ids[n] = products[i].ID
+		}
+		var err error
+		if stored, err = s.FindByIDs(ctx, ids); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	events := make([]interface{}, 0, len(pending))
+	for _, i := range pending {
+		if current, ok := stored[products[i].ID]; ok && unchanged(current, next(current, products[i])) {
+			continue
+		}
+		events = append(events, newEvent(products[i], eventType, now))
+	}
+	return events, nil
+}
+
+// replaced returns the product stored by a replace of the stored product.
+func replaced(current, product model.Product) model.Product {
+	return product
+}
+
+// merged returns the product stored by a merge into the stored product. A merged product is not deleted anymore.
+func merged(current, product model.Product) model.Product {
+	next := current.Merge(product)
+	next.DeletedAt = nil
+	if product.Source != "" {
+		next.Source = product.Source
+	}
+	if product.Ts != 0 {
+		next.Ts = product.Ts
+	}
+	return next
+}
+
+// deleteByIDsWithOutbox deletes the products with the given IDs in chunks and inserts a delete event for each deleted product.
+// The IDs of the products to be deleted are read in the transaction of the chunk, so only the deleted products get an event.
+func (s *productStorage) deleteByIDsWithOutbox(ctx context.Context, ids []int, hard bool) (int64, error) {
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return 0, customerror.New(constant.ErrDeleteProducts, true).
+			Wrap(fmt.Errorf("productstorage: failed to start session: %w", err)).AddData("err: " + err.Error())
+	}
+	defer session.EndSession(ctx)
+
+	var deleted int64
+	collection := s.db.Collection(s.productCollectionName)
+	for start := 0; start < len(ids); start += deleteChunkSize {
+		chunk := ids[start:min(start+deleteChunkSize, len(ids))]
+		n, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			filter := bson.M{"id": bson.M{"$in": chunk}, "deleted_at": bson.M{"$exists": false}}
+			cursor, err := collection.Find(sc, filter, options.Find().SetProjection(bson.M{"id": 1, "source": 1}))
+			if err != nil {
+				return int64(0), err
+			}
+			var found []model.Product
+			if err := cursor.All(sc, &found); err != nil {
+				return int64(0), err
+			}
+			if len(found) == 0 {
+				return int64(0), nil
+			}
+			foundIDs := make([]int, len(found))
+			events := make([]interface{}, len(found))
+			now := time.Now()
+			for i, product := range found {
+				foundIDs[i] = product.ID
+				events[i] = newEvent(product, model.EventProductDeleted, now)
+			}
+			filter = bson.M{"id": bson.M{"$in": foundIDs}}
+			if hard {
+				_, err = collection.DeleteMany(sc, filter)
+			} else {
+				_, err = collection.UpdateMany(sc, filter, bson.M{"$set": bson.M{"deleted_at": now}})
+			}
+			if err != nil {
+				return int64(0), err
+			}
+			if _, err := s.db.Collection(s.outboxCollectionName).InsertMany(sc, events); err != nil {
+				return int64(0), err
+			}
+			return int64(len(found)), nil
+		})
+		if err != nil {
+			return deleted, customerror.New(constant.ErrDeleteProducts, true).
+				Wrap(fmt.Errorf("productstorage: failed to delete products: %w", err)).AddData("err: " + err.Error())
+		}
+		deleted += n.(int64)
+	}
+	return deleted, nil
+}
+
+// newEvent returns the change event of the product. An upsert event holds the written product.
+func newEvent(product model.Product, eventType model.EventType, now time.Time) model.OutboxEvent {
+	event := model.OutboxEvent{
+		Type:      eventType,
+		ProductID: product.ID,
+		Ts:        product.Ts,
+		Source:    product.Source,
+		CreatedAt: now,
+	}
+	if eventType == model.EventProductUpserted {
+		event.Product = &product
+	}
+	return event
+}
@@ -0,0 +1,123 @@
+package productstorage_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_productStorage_Outbox(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Write And Events Are Committed Together", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+			productstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(),
+		)
+		result := mockCollection.CreateBatch(context.TODO(), []model.Product{{ID: 1}, {ID: 2}})
+		assert.Empty(t, result.Errors)
+		assert.Equal(t, int64(2), result.Inserted)
+		events := mt.GetAllStartedEvents()
+		if assert.Len(t, events, 3) {
+			assert.Equal(t, "insert", events[0].CommandName)
+			assert.Equal(t, "products", events[0].Command.Lookup("insert").StringValue())
+			assert.Equal(t, "outbox", events[1].Command.Lookup("insert").StringValue())
+			assert.Equal(t, "commitTransaction", events[2].CommandName)
+			docs, _ := events[1].Command.Lookup("documents").Array().Values()
+			if assert.Len(t, docs, 2) {
+				assert.Equal(t, string(model.EventProductUpserted), docs[0].Document().Lookup("type").StringValue())
+			}
+		}
+	})
+
+	mt.Run("Case Write Error Retries The Rest Of The Batch", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+			productstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(),
+		)
+		result := mockCollection.CreateBatch(context.TODO(), []model.Product{{ID: 1}, {ID: 2}})
+		assert.Equal(t, int64(1), result.Inserted)
+		var ce *customerror.Error
+		if assert.Len(t, result.Errors, 1) && assert.ErrorAs(t, result.Errors[0], &ce) {
+			assert.Equal(t, constant.ErrIDExists, ce.Message)
+		}
+		var names []string
+		for _, event := range mt.GetAllStartedEvents() {
+			names = append(names, event.CommandName)
+		}
+		assert.Equal(t, []string{"insert", "abortTransaction", "insert", "insert", "commitTransaction"}, names)
+	})
+
+	mt.Run("Case Unchanged Replace Has No Event", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+			productstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "id", Value: 1}, {Key: "title", Value: "same"}, {Key: "source", Value: "test"}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(),
+		)
+		result := mockCollection.ReplaceBatch(context.TODO(), []model.Product{
+			{ID: 1, Title: "same", Source: "test"},
+			{ID: 2, Title: "new", Source: "test"},
+		})
+		assert.Empty(t, result.Errors)
+		events := mt.GetAllStartedEvents()
+		if assert.Len(t, events, 4) {
+			assert.Equal(t, "find", events[0].CommandName)
+			assert.Equal(t, "update", events[1].CommandName)
+			assert.Equal(t, "outbox", events[2].Command.Lookup("insert").StringValue())
+			docs, _ := events[2].Command.Lookup("documents").Array().Values()
+			if assert.Len(t, docs, 1) {
+				assert.Equal(t, int32(2), docs[0].Document().Lookup("product_id").Int32())
+			}
+		}
+	})
+
+	mt.Run("Case Unchanged Merge Batch Writes No Event", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+			productstorage.WithOutboxCollection("outbox"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "id", Value: 1}, {Key: "title", Value: "same"}, {Key: "price", Value: 10.0}, {Key: "source", Value: "test"}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(),
+		)
+		result := mockCollection.MergeBatch(context.TODO(), []model.Product{{ID: 1, Title: "same", Source: "test"}})
+		assert.Empty(t, result.Errors)
+		var names []string
+		for _, event := range mt.GetAllStartedEvents() {
+			names = append(names, event.CommandName)
+		}
+		assert.Equal(t, []string{"find", "update", "commitTransaction"}, names)
+	})
+}
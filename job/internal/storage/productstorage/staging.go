@@ -0,0 +1,144 @@
+package productstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// stagingCollectionName returns the name of the staging collection of the run.
+func (s *productStorage) stagingCollectionName(runID string) string {
+	return s.productCollectionName + "_staging_" + runID
+}
+
+// Staging method returns a storage that writes into the staging collection of the run.
+// The staging collection is created with the validator and the indexes of the product collection, including the ones
+// of the migrations, so they are kept if it is renamed. If the product collection does not exist, the staging collection
+// gets the indexes of CreateIndex.
+func (s *productStorage) Staging(ctx context.Context, runID string) (ProductStorer, error) {
+	staging := &productStorage{
+		productCollectionName: s.stagingCollectionName(runID),
+		historyCollectionName: s.historyCollectionName,
+		db:                    s.db,
+	}
+	if err := s.copySchema(ctx, staging); err != nil {
+		return nil, customerror.New(constant.ErrCreateStaging, true).
+			Wrap(fmt.Errorf("productstorage: failed to create staging collection: %w", err)).AddData("err: " + err.Error())
+	}
+	return staging, nil
+}
+
+// copySchema creates the staging collection with the validator options and the indexes of the product collection.
+func (s *productStorage) copySchema(ctx context.Context, staging *productStorage) error {
+	specs, err := s.db.ListCollectionSpecifications(ctx, bson.M{"name": s.productCollectionName})
+	if err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		return staging.CreateIndex(ctx)
+	}
+	create := bson.D{{Key: "create", Value: staging.productCollectionName}}
+	if specs[0].Options != nil {
+		var collectionOptions bson.M
+		if err := bson.Unmarshal(specs[0].Options, &collectionOptions); err != nil {
+			return err
+		}
+		for _, key := range []string{"validator", "validationLevel", "validationAction"} {
+			if value, ok := collectionOptions[key]; ok {
+				create = append(create, bson.E{Key: key, Value: value})
+			}
+		}
+	}
+	if err := s.db.RunCommand(ctx, create).Err(); err != nil {
+		return err
+	}
+	cursor, err := s.db.Collection(s.productCollectionName).Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []bson.D
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+	// the listed index specifications are created as they are, without the fields that are set by the server.
+	models := bson.A{}
+	for _, index := range indexes {
+		model, name := bson.D{}, ""
+		for _, field := range index {
+			switch field.Key {
+			case "v", "ns":
+				continue
+			case "name":
+				name, _ = field.Value.(string)
+			}
+			model = append(model, field)
+		}
+		if name != "_id_" {
+			models = append(models, model)
+		}
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	return s.db.RunCommand(ctx, bson.D{
+		{Key: "createIndexes", Value: staging.productCollectionName},
+		{Key: "indexes", Value: models},
+	}).Err()
+}
+
+// MergeStaging method merges the staging collection of the run into the product collection by product ID and drops it.
+// whenMatched decides how a stored product is changed. It is one of WhenMatchedKeepExisting, WhenMatchedReplace, WhenMatchedMerge.
+func (s *productStorage) MergeStaging(ctx context.Context, runID string, whenMatched string) error {
+	var matched interface{} = whenMatched
+	if whenMatched == WhenMatchedMerge {
+		// a merged product is not deleted anymore, like the products written by MergeBatch.
+		matched = bson.A{
+			bson.M{"$replaceWith": bson.M{"$mergeObjects": bson.A{"$$ROOT", "$$new"}}},
+			bson.M{"$unset": "deleted_at"},
+		}
+	}
+	cursor, err := s.db.Collection(s.stagingCollectionName(runID)).Aggregate(ctx, bson.A{
+		bson.M{"$unset": "_id"},
+		bson.M{"$merge": bson.M{
+			"into":           s.productCollectionName,
+			"on":             "id",
+			"whenMatched":    matched,
+			"whenNotMatched": "insert",
+		}},
+	})
+	if err != nil {
+		return customerror.New(constant.ErrPromoteStaging, true).
+			Wrap(fmt.Errorf("productstorage: failed to merge staging collection: %w", err)).AddData("err: " + err.Error())
+	}
+	if err := cursor.Close(ctx); err != nil {
+		return customerror.New(constant.ErrPromoteStaging, true).
+			Wrap(fmt.Errorf("productstorage: failed to merge staging collection: %w", err)).AddData("err: " + err.Error())
+	}
+	return s.DropStaging(ctx, runID)
+}
+
+// RenameStaging method replaces the product collection with the staging collection of the run.
+// The products that are not in the staging collection are deleted, so the run must write every product of the collection.
+func (s *productStorage) RenameStaging(ctx context.Context, runID string) error {
+	dbName := s.db.Name()
+	if err := s.db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: dbName + "." + s.stagingCollectionName(runID)},
+		{Key: "to", Value: dbName + "." + s.productCollectionName},
+		{Key: "dropTarget", Value: true},
+	}).Err(); err != nil {
+		return customerror.New(constant.ErrPromoteStaging, true).
+			Wrap(fmt.Errorf("productstorage: failed to rename staging collection: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// DropStaging method drops the staging collection of the run.
+func (s *productStorage) DropStaging(ctx context.Context, runID string) error {
+	if err := s.db.Collection(s.stagingCollectionName(runID)).Drop(ctx); err != nil {
+		return customerror.New(constant.ErrDropStaging, true).
+			Wrap(fmt.Errorf("productstorage: failed to drop staging collection: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
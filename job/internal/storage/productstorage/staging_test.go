@@ -0,0 +1,162 @@
+package productstorage_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"strings"
+	"testing"
+)
+
+func Test_productStorage_Staging(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Staging Writes Into Staging Collection", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.$cmd.listCollections", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		staging, err := mockCollection.Staging(context.TODO(), "run")
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, "products", mt.GetStartedEvent().Command.Lookup("filter", "name").StringValue())
+		assert.Equal(t, "products_staging_run", mt.GetStartedEvent().Command.Lookup("createIndexes").StringValue())
+		result := staging.CreateBatch(context.TODO(), []model.Product{{ID: 1}})
+		assert.Empty(t, result.Errors)
+		assert.Equal(t, "products_staging_run", mt.GetStartedEvent().Command.Lookup("insert").StringValue())
+	})
+
+	mt.Run("Case Staging Copies Validator And Indexes", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.$cmd.listCollections", mtest.FirstBatch, bson.D{
+				{Key: "name", Value: "products"},
+				{Key: "type", Value: "collection"},
+				{Key: "options", Value: bson.D{
+					{Key: "validator", Value: bson.D{{Key: "$jsonSchema", Value: bson.D{{Key: "bsonType", Value: "object"}}}}},
+					{Key: "validationLevel", Value: "moderate"},
+				}},
+			}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCursorResponse(0, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "name", Value: "_id_"}},
+				bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "id", Value: 1}}}, {Key: "name", Value: "id_1"}, {Key: "unique", Value: true}},
+				bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: 1}}}, {Key: "name", Value: "product_text"},
+					{Key: "weights", Value: bson.D{{Key: "title", Value: 1}}}, {Key: "textIndexVersion", Value: 3}},
+			),
+			mtest.CreateSuccessResponse(),
+		)
+		_, err := mockCollection.Staging(context.TODO(), "run")
+		if !assert.Nil(t, err) {
+			return
+		}
+		mt.GetStartedEvent()
+		create := mt.GetStartedEvent().Command
+		assert.Equal(t, "products_staging_run", create.Lookup("create").StringValue())
+		assert.Equal(t, "moderate", create.Lookup("validationLevel").StringValue())
+		assert.Equal(t, "object", create.Lookup("validator", "$jsonSchema", "bsonType").StringValue())
+		mt.GetStartedEvent()
+		createIndexes := mt.GetStartedEvent().Command
+		assert.Equal(t, "products_staging_run", createIndexes.Lookup("createIndexes").StringValue())
+		indexes, _ := createIndexes.Lookup("indexes").Array().Values()
+		if assert.Len(t, indexes, 2) {
+			assert.Equal(t, "id_1", indexes[0].Document().Lookup("name").StringValue())
+			assert.True(t, indexes[0].Document().Lookup("unique").Boolean())
+			assert.Equal(t, "product_text", indexes[1].Document().Lookup("name").StringValue())
+			_, err := indexes[1].Document().LookupErr("v")
+			assert.NotNil(t, err)
+		}
+	})
+
+	mt.Run("Case Staging Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		_, err := mockCollection.Staging(context.TODO(), "run")
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrCreateStaging, ce.Message)
+		}
+	})
+}
+
+func Test_productStorage_MergeStaging(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success MergeStaging", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.products_staging_run", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+		)
+		err := mockCollection.MergeStaging(context.TODO(), "run", productstorage.WhenMatchedReplace)
+		assert.Nil(t, err)
+		events := mt.GetAllStartedEvents()
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "aggregate", events[0].CommandName)
+			assert.Equal(t, "drop", events[1].CommandName)
+		}
+	})
+
+	mt.Run("Case MergeStaging Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		err := mockCollection.MergeStaging(context.TODO(), "run", productstorage.WhenMatchedMerge)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrPromoteStaging, ce.Message)
+		}
+	})
+}
+
+func Test_productStorage_RenameStaging(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success RenameStaging", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := mockCollection.RenameStaging(context.TODO(), "run")
+		assert.Nil(t, err)
+		command := mt.GetStartedEvent().Command
+		assert.True(t, strings.HasSuffix(command.Lookup("renameCollection").StringValue(), ".products_staging_run"))
+		assert.True(t, command.Lookup("dropTarget").Boolean())
+	})
+
+	mt.Run("Case RenameStaging Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "command error"}))
+		err := mockCollection.RenameStaging(context.TODO(), "run")
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrPromoteStaging, ce.Message)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package productstorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
@@ -9,17 +10,33 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
-// CreateIndex method creates an index for the ID field of the product collection.
+// CreateIndex method creates an index for the ID field and an index for the source field of the product collection.
+// If the history collection is set, it also creates an index for the product ID and archive time of the history collection.
 func (s *productStorage) CreateIndex(ctx context.Context) error {
-	if _, err := s.db.Collection(s.productCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.M{"id": 1},
-		Options: options.Index().SetUnique(true),
+	if _, err := s.db.Collection(s.productCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"id": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"source": 1},
+		},
 	}); err != nil {
 		return customerror.New(constant.ErrCreateIndexFailed, true).
 			Wrap(fmt.Errorf("productstorage: failed to create index: %w", err)).AddData("err: " + err.Error())
 	}
+	if s.historyCollectionName == "" {
+		return nil
+	}
+	if _, err := s.db.Collection(s.historyCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "archived_at", Value: 1}},
+	}); err != nil {
+		return customerror.New(constant.ErrCreateIndexFailed, true).
+			Wrap(fmt.Errorf("productstorage: failed to create history index: %w", err)).AddData("err: " + err.Error())
+	}
 	return nil
 }
 
@@ -34,3 +51,265 @@ func (s *productStorage) Create(ctx context.Context, product model.Product) erro
 	}
 	return nil
 }
+
+// CreateBatch method inserts the products with an unordered bulk write.
+// Write errors are mapped back to the index of the product in the batch. If the whole batch fails, every product gets the error.
+func (s *productStorage) CreateBatch(ctx context.Context, products []model.Product) BatchResult {
+	models := make([]mongo.WriteModel, len(products))
+	for i, product := range products {
+		models[i] = mongo.NewInsertOneModel().SetDocument(product)
+	}
+	return s.bulkWrite(ctx, products, models, constant.ErrCreateProduct, model.EventProductUpserted, nil)
+}
+
+// ReplaceBatch method replaces the whole document of each product by its ID. Missing products are inserted.
+func (s *productStorage) ReplaceBatch(ctx context.Context, products []model.Product) BatchResult {
+	models := make([]mongo.WriteModel, len(products))
+	for i, product := range products {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(productFilter(product)).
+			SetReplacement(product).
+			SetUpsert(true)
+	}
+	return s.bulkWrite(ctx, products, models, constant.ErrReplaceProduct, model.EventProductUpserted, replaced)
+}
+
+// MergeBatch method sets only the non-empty fields of each product by its ID. Missing products are inserted.
+func (s *productStorage) MergeBatch(ctx context.Context, products []model.Product) BatchResult {
+	models := make([]mongo.WriteModel, len(products))
+	for i, product := range products {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(productFilter(product)).
+			SetUpdate(bson.M{"$set": mergeFields(product), "$unset": bson.M{"deleted_at": ""}}).
+			SetUpsert(true)
+	}
+	return s.bulkWrite(ctx, products, models, constant.ErrMergeProduct, model.EventProductUpserted, merged)
+}
+
+// DeleteBatch method writes a tombstone for each product by its ID. The tombstone keeps the event time,
+// so a replayed older event cannot bring the product back. Missing products get a tombstone too.
+func (s *productStorage) DeleteBatch(ctx context.Context, products []model.Product) BatchResult {
+	now := time.Now()
+	models := make([]mongo.WriteModel, len(products))
+	for i, product := range products {
+		set := bson.M{"deleted_at": now}
+		if product.Ts != 0 {
+			set["ts"] = product.Ts
+		}
+		if product.Source != "" {
+			set["source"] = product.Source
+		}
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(productFilter(product)).
+			SetUpdate(bson.M{"$set": set}).
+			SetUpsert(true)
+	}
+	result := s.bulkWrite(ctx, products, models, constant.ErrDeleteProducts, model.EventProductDeleted, nil)
+	result.Deleted = result.Inserted + result.Updated
+	result.Inserted, result.Updated = 0, 0
+	return result
+}
+
+// productFilter returns the filter that matches the stored product by its ID.
+// If the product has an event time, the filter matches only a stored product with an older event time,
+// so the upsert of an older event fails with a duplicate key error and is reported as stale.
+func productFilter(product model.Product) bson.M {
+	if product.Ts == 0 {
+		return bson.M{"id": product.ID}
+	}
+	return bson.M{
+		"id": product.ID,
+		"$or": bson.A{
+			bson.M{"ts": bson.M{"$lt": product.Ts}},
+			bson.M{"ts": bson.M{"$exists": false}},
+		},
+	}
+}
+
+// bulkWrite runs the write models with an unordered bulk write and maps the result back to the products.
+// Write errors are mapped back to the index of the product in the batch. If the whole batch fails, every product gets the error.
+// If the outbox collection is set, the write and its events of the event type are committed in a single transaction.
+// next returns the product stored by the write of a product over a stored product. It is nil if every write changes the stored product.
+func (s *productStorage) bulkWrite(ctx context.Context, products []model.Product, models []mongo.WriteModel, message string, eventType model.EventType, next func(current, product model.Product) model.Product) BatchResult {
+	result := BatchResult{Errors: make(map[int]error)}
+	if len(models) == 0 {
+		return result
+	}
+	if s.outboxCollectionName != "" {
+		return s.bulkWriteWithOutbox(ctx, products, models, message, eventType, next)
+	}
+	res, err := s.db.Collection(s.productCollectionName).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var bwe mongo.BulkWriteException
+	if err != nil && !(errors.As(err, &bwe) && bwe.WriteConcernError == nil) {
+		for i := range products {
+			result.Errors[i] = batchError(message, err)
+		}
+		return result
+	}
+	if res != nil {
+		result.Inserted = res.InsertedCount + res.UpsertedCount
+		result.Updated = res.ModifiedCount
+		result.Unchanged = res.MatchedCount - res.ModifiedCount
+	}
+	for _, we := range bwe.WriteErrors {
+		result.Errors[we.Index] = writeError(products[we.Index], we, message)
+	}
+	return result
+}
+
+// batchError returns the error of a product of a batch that failed as a whole.
+func batchError(message string, err error) error {
+	return customerror.New(message, true).
+		Wrap(fmt.Errorf("productstorage: failed to write batch: %w", err)).AddData("err: " + err.Error())
+}
+
+// writeError maps the write error of the product to a custom error. A duplicate key error is a stale event
+// if the product has an event time, otherwise the ID of the product already exists.
+func writeError(product model.Product, we mongo.BulkWriteError, message string) error {
+	if isDuplicateKey(we.Code) && product.Ts != 0 {
+		return customerror.New(constant.ErrStaleEvent, false).
+			Wrap(fmt.Errorf("productstorage: failed to write product: %w", we)).AddData(product.ID)
+	}
+	if isDuplicateKey(we.Code) {
+		return customerror.New(constant.ErrIDExists, false).
+			Wrap(fmt.Errorf("productstorage: failed to write product: %w", we)).AddData(product.ID)
+	}
+	return customerror.New(message, true).
+		Wrap(fmt.Errorf("productstorage: failed to write product: %w", we)).AddData("err: " + we.Error())
+}
+
+// mergeFields returns the non-empty fields of the product to be set on the stored document.
+func mergeFields(product model.Product) bson.M {
+	fields := bson.M{"id": product.ID}
+	if product.Title != "" {
+		fields["title"] = product.Title
+	}
+	if product.Price != 0 {
+		fields["price"] = product.Price
+	}
+	if product.Category != "" {
+		fields["category"] = product.Category
+	}
+	if product.Brand != "" {
+		fields["brand"] = product.Brand
+	}
+	if product.Url != "" {
+		fields["url"] = product.Url
+	}
+	if product.Description != "" {
+		fields["description"] = product.Description
+	}
+	if product.Hash != "" {
+		fields["hash"] = product.Hash
+	}
+	if product.Source != "" {
+		fields["source"] = product.Source
+	}
+	if product.Ts != 0 {
+		fields["ts"] = product.Ts
+	}
+	return fields
+}
+
+// isDuplicateKey reports whether the write error code is a duplicate key error.
+func isDuplicateKey(code int) bool {
+	return code == 11000 || code == 11001 || code == 12582
+}
+
+// FindByIDs method returns the stored products with the given IDs keyed by their ID.
+func (s *productStorage) FindByIDs(ctx context.Context, ids []int) (map[int]model.Product, error) {
+	products := make(map[int]model.Product, len(ids))
+	if len(ids) == 0 {
+		return products, nil
+	}
+	cursor, err := s.db.Collection(s.productCollectionName).Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, customerror.New(constant.ErrFindProducts, true).
+			Wrap(fmt.Errorf("productstorage: failed to find products: %w", err)).AddData("err: " + err.Error())
+	}
+	var found []model.Product
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, customerror.New(constant.ErrFindProducts, true).
+			Wrap(fmt.Errorf("productstorage: failed to decode products: %w", err)).AddData("err: " + err.Error())
+	}
+	for _, product := range found {
+		products[product.ID] = product
+	}
+	return products, nil
+}
+
+// CreateHistory method appends the previous versions of the products to the history collection.
+func (s *productStorage) CreateHistory(ctx context.Context, history []model.ProductHistory) error {
+	if len(history) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(history))
+	for i, h := range history {
+		documents[i] = h
+	}
+	if _, err := s.db.Collection(s.historyCollectionName).InsertMany(ctx, documents); err != nil {
+		return customerror.New(constant.ErrCreateHistory, true).
+			Wrap(fmt.Errorf("productstorage: failed to create history: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// deleteChunkSize is the maximum number of IDs in a single delete query.
+const deleteChunkSize = 1000
+
+// FindIDsBySource method returns the IDs of the products written by the source that are not deleted.
+func (s *productStorage) FindIDsBySource(ctx context.Context, source string) ([]int, error) {
+	cursor, err := s.db.Collection(s.productCollectionName).Find(ctx,
+		bson.M{"source": source, "deleted_at": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 0, "id": 1}),
+	)
+	if err != nil {
+		return nil, customerror.New(constant.ErrFindProducts, true).
+			Wrap(fmt.Errorf("productstorage: failed to find product ids: %w", err)).AddData("err: " + err.Error())
+	}
+	defer cursor.Close(ctx)
+	var ids []int
+	for cursor.Next(ctx) {
+		var product model.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, customerror.New(constant.ErrFindProducts, true).
+				Wrap(fmt.Errorf("productstorage: failed to decode product id: %w", err)).AddData("err: " + err.Error())
+		}
+		ids = append(ids, product.ID)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, customerror.New(constant.ErrFindProducts, true).
+			Wrap(fmt.Errorf("productstorage: failed to find product ids: %w", err)).AddData("err: " + err.Error())
+	}
+	return ids, nil
+}
+
+// DeleteByIDs method deletes the products with the given IDs and returns the number of deleted products.
+// If hard is false, the products are soft deleted by setting their deleted_at field.
+func (s *productStorage) DeleteByIDs(ctx context.Context, ids []int, hard bool) (int64, error) {
+	if s.outboxCollectionName != "" {
+		return s.deleteByIDsWithOutbox(ctx, ids, hard)
+	}
+	var deleted int64
+	collection := s.db.Collection(s.productCollectionName)
+	for start := 0; start < len(ids); start += deleteChunkSize {
+		chunk := ids[start:min(start+deleteChunkSize, len(ids))]
+		filter := bson.M{"id": bson.M{"$in": chunk}, "deleted_at": bson.M{"$exists": false}}
+		if hard {
+			res, err := collection.DeleteMany(ctx, filter)
+			if err != nil {
+				return deleted, customerror.New(constant.ErrDeleteProducts, true).
+					Wrap(fmt.Errorf("productstorage: failed to delete products: %w", err)).AddData("err: " + err.Error())
+			}
+			deleted += res.DeletedCount
+			continue
+		}
+		res, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"deleted_at": time.Now()}})
+		if err != nil {
+			return deleted, customerror.New(constant.ErrDeleteProducts, true).
+				Wrap(fmt.Errorf("productstorage: failed to soft delete products: %w", err)).AddData("err: " + err.Error())
+		}
+		deleted += res.ModifiedCount
+	}
+	return deleted, nil
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
 	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 	"testing"
 )
@@ -125,3 +127,310 @@ func Test_productStorage_CreateIndex(t *testing.T) {
 		mt.ClearEvents()
 	})
 }
+
+func Test_productStorage_CreateBatch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	products := []model.Product{
+		{ID: 1, Title: "first"},
+		{ID: 2, Title: "second"},
+		{ID: 3, Title: "third"},
+	}
+
+	mt.Run("Case Success CreateBatch", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}))
+		result := mockCollection.CreateBatch(context.TODO(), products)
+		assert.Equal(t, int64(3), result.Inserted)
+		assert.Empty(t, result.Errors)
+	})
+
+	mt.Run("Case Write Errors Mapped To Products", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(
+			mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key error"},
+			mtest.WriteError{Index: 2, Code: 2, Message: "unknown error"},
+		))
+		result := mockCollection.CreateBatch(context.TODO(), products)
+		assert.Len(t, result.Errors, 2)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, result.Errors[1], &ce) {
+			assert.Equal(t, constant.ErrIDExists, ce.Message)
+		}
+		if assert.ErrorAs(t, result.Errors[2], &ce) {
+			assert.Equal(t, constant.ErrCreateProduct, ce.Message)
+		}
+	})
+
+	mt.Run("Case Whole Batch Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    2,
+			Message: "command error",
+		}))
+		result := mockCollection.CreateBatch(context.TODO(), products)
+		assert.Equal(t, int64(0), result.Inserted)
+		assert.Len(t, result.Errors, len(products))
+	})
+}
+
+func Test_productStorage_ReplaceBatch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	products := []model.Product{
+		{ID: 1, Title: "first"},
+		{ID: 2, Title: "second"},
+		{ID: 3, Title: "third"},
+	}
+
+	mt.Run("Case Success ReplaceBatch", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 3},
+			bson.E{Key: "nModified", Value: 1},
+			bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: "uid"}}}},
+		))
+		result := mockCollection.ReplaceBatch(context.TODO(), products)
+		assert.Equal(t, int64(1), result.Inserted)
+		assert.Equal(t, int64(1), result.Updated)
+		assert.Equal(t, int64(1), result.Unchanged)
+		assert.Empty(t, result.Errors)
+	})
+
+	mt.Run("Case Whole Batch Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    2,
+			Message: "command error",
+		}))
+		result := mockCollection.ReplaceBatch(context.TODO(), products)
+		assert.Len(t, result.Errors, len(products))
+		var ce *customerror.Error
+		if assert.ErrorAs(t, result.Errors[0], &ce) {
+			assert.Equal(t, constant.ErrReplaceProduct, ce.Message)
+		}
+	})
+}
+
+func Test_productStorage_MergeBatch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success MergeBatch Sets Only Non-Empty Fields", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 1},
+			bson.E{Key: "nModified", Value: 1},
+		))
+		result := mockCollection.MergeBatch(context.TODO(), []model.Product{{ID: 1, Price: 12.5}})
+		assert.Equal(t, int64(1), result.Updated)
+		assert.Empty(t, result.Errors)
+
+		started := mt.GetStartedEvent()
+		if !assert.NotNil(t, started) {
+			return
+		}
+		update := started.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		assert.Equal(t, 12.5, update.Lookup("price").Double())
+		_, err := update.LookupErr("title")
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_productStorage_FindByIDs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success FindByIDs", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "id", Value: 1}, {Key: "title", Value: "first"}},
+				bson.D{{Key: "id", Value: 2}, {Key: "title", Value: "second"}},
+			),
+			mtest.CreateCursorResponse(0, "db.products", mtest.NextBatch),
+		)
+		products, err := mockCollection.FindByIDs(context.TODO(), []int{1, 2, 3})
+		assert.Nil(t, err)
+		assert.Len(t, products, 2)
+		assert.Equal(t, "second", products[2].Title)
+	})
+
+	mt.Run("Case FindByIDs Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    2,
+			Message: "command error",
+		}))
+		_, err := mockCollection.FindByIDs(context.TODO(), []int{1})
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrFindProducts, ce.Message)
+		}
+	})
+}
+
+func Test_productStorage_CreateHistory(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success CreateHistory", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+			productstorage.WithHistoryCollection("product_history"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := mockCollection.CreateHistory(context.TODO(), []model.ProductHistory{
+			{ProductID: 1, Product: model.Product{ID: 1, Title: "old"}},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "product_history", mt.GetStartedEvent().Command.Lookup("insert").StringValue())
+	})
+
+	mt.Run("Case CreateHistory Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+			productstorage.WithHistoryCollection("product_history"),
+		)
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Code:    2,
+			Message: "unknown error",
+		}))
+		err := mockCollection.CreateHistory(context.TODO(), []model.ProductHistory{{ProductID: 1}})
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrCreateHistory, ce.Message)
+		}
+	})
+}
+
+func Test_productStorage_FindIDsBySource(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success FindIDsBySource", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.products", mtest.FirstBatch,
+				bson.D{{Key: "id", Value: 1}},
+				bson.D{{Key: "id", Value: 2}},
+			),
+			mtest.CreateCursorResponse(0, "db.products", mtest.NextBatch),
+		)
+		ids, err := mockCollection.FindIDsBySource(context.TODO(), "bucket/key")
+		assert.Nil(t, err)
+		assert.Equal(t, []int{1, 2}, ids)
+	})
+}
+
+func Test_productStorage_DeleteByIDs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Soft Delete", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 2},
+			bson.E{Key: "nModified", Value: 2},
+		))
+		deleted, err := mockCollection.DeleteByIDs(context.TODO(), []int{1, 2}, false)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), deleted)
+		assert.Equal(t, "update", mt.GetStartedEvent().CommandName)
+	})
+
+	mt.Run("Case Hard Delete", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}))
+		deleted, err := mockCollection.DeleteByIDs(context.TODO(), []int{1, 2}, true)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), deleted)
+		assert.Equal(t, "delete", mt.GetStartedEvent().CommandName)
+	})
+
+	mt.Run("Case Delete Error", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    2,
+			Message: "command error",
+		}))
+		_, err := mockCollection.DeleteByIDs(context.TODO(), []int{1}, true)
+		var ce *customerror.Error
+		if assert.ErrorAs(t, err, &ce) {
+			assert.Equal(t, constant.ErrDeleteProducts, ce.Message)
+		}
+	})
+}
+
+func Test_productStorage_DeleteBatch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Case Success DeleteBatch Writes Tombstones", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 2},
+			bson.E{Key: "nModified", Value: 1},
+			bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 1}, {Key: "_id", Value: "uid"}}}},
+		))
+		result := mockCollection.DeleteBatch(context.TODO(), []model.Product{{ID: 1, Ts: 10}, {ID: 2, Ts: 11}})
+		assert.Equal(t, int64(2), result.Deleted)
+		assert.Equal(t, int64(0), result.Inserted)
+		assert.Empty(t, result.Errors)
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, int64(10), update.Lookup("u", "$set", "ts").Int64())
+		_, err := update.LookupErr("q", "$or")
+		assert.Nil(t, err)
+	})
+
+	mt.Run("Case Stale Event", func(mt *mtest.T) {
+		mockCollection := productstorage.New(
+			productstorage.WithDB(mt.DB),
+			productstorage.WithProductCollection("products"),
+		)
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+		result := mockCollection.DeleteBatch(context.TODO(), []model.Product{{ID: 1, Ts: 10}})
+		var ce *customerror.Error
+		if assert.ErrorAs(t, result.Errors[0], &ce) {
+			assert.Equal(t, constant.ErrStaleEvent, ce.Message)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package sqldialect
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect holds the differences between the SQL databases supported by the SQL storages.
+type Dialect struct {
+	Name string
+	// JSONType is the column type of the JSON documents.
+	JSONType string
+	// TimestampType is the column type of the timestamps.
+	TimestampType string
+	placeholder   func(n int) string
+	isDistinct    func(a, b string) string
+}
+
+var Postgres = Dialect{
+	Name:          "postgres",
+	JSONType:      "JSONB",
+	TimestampType: "TIMESTAMPTZ",
+	placeholder: func(n int) string {
+		return "$" + strconv.Itoa(n)
+	},
+	isDistinct: func(a, b string) string {
+		return a + " IS DISTINCT FROM " + b
+	},
+}
+
+// SQLite stores the JSON documents as text. Its DATETIME columns are scanned into time.Time by the modernc driver.
+var SQLite = Dialect{
+	Name:          "sqlite",
+	JSONType:      "TEXT",
+	TimestampType: "DATETIME",
+	placeholder: func(n int) string {
+		return "?" + strconv.Itoa(n)
+	},
+	isDistinct: func(a, b string) string {
+		return a + " IS NOT " + b
+	},
+}
+
+// Placeholder returns the placeholder of the nth argument of a statement. n starts from 1.
+func (d Dialect) Placeholder(n int) string {
+	return d.placeholder(n)
+}
+
+// Placeholders returns count comma separated placeholders starting from the nth argument.
+func (d Dialect) Placeholders(n, count int) string {
+	placeholders := make([]string, count)
+	for i := range placeholders {
+		placeholders[i] = d.placeholder(n + i)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// IsDistinct returns a null-safe expression that is true if a and b are different.
+func (d Dialect) IsDistinct(a, b string) string {
+	return d.isDistinct(a, b)
+}
+
+// Quote returns the quoted identifier.
+func Quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
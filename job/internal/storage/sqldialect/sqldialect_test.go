@@ -0,0 +1,42 @@
+package sqldialect_test
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"testing"
+)
+
+func TestDialect_Placeholders(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect sqldialect.Dialect
+		n       int
+		count   int
+		want    string
+	}{
+		{name: "postgres placeholders are numbered", dialect: sqldialect.Postgres, n: 3, count: 3, want: "$3, $4, $5"},
+		{name: "sqlite placeholders are numbered", dialect: sqldialect.SQLite, n: 1, count: 2, want: "?1, ?2"},
+		{name: "zero placeholders are empty", dialect: sqldialect.Postgres, n: 1, count: 0, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Placeholders(tt.n, tt.count); got != tt.want {
+				t.Errorf("Placeholders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialect_IsDistinct(t *testing.T) {
+	if got := sqldialect.Postgres.IsDistinct("a", "b"); got != "a IS DISTINCT FROM b" {
+		t.Errorf("IsDistinct() = %q", got)
+	}
+	if got := sqldialect.SQLite.IsDistinct("a", "b"); got != "a IS NOT b" {
+		t.Errorf("IsDistinct() = %q", got)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := sqldialect.Quote(`prod"ucts`); got != `"prod""ucts"` {
+		t.Errorf("Quote() = %q", got)
+	}
+}
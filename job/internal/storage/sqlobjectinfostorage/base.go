@@ -0,0 +1,44 @@
+package sqlobjectinfostorage
+
+import (
+	"database/sql"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/objectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+)
+
+// objectInfoStorage is the SQL implementation of objectinfostorage.ObjectInfoStorer.
+type objectInfoStorage struct {
+	tableName string
+	db        *sql.DB
+	dialect   sqldialect.Dialect
+}
+
+type Option func(*objectInfoStorage)
+
+func WithObjectTable(table string) Option {
+	return func(s *objectInfoStorage) {
+		s.tableName = table
+	}
+}
+
+func WithDB(db *sql.DB) Option {
+	return func(s *objectInfoStorage) {
+		s.db = db
+	}
+}
+
+func WithDialect(dialect sqldialect.Dialect) Option {
+	return func(s *objectInfoStorage) {
+		s.dialect = dialect
+	}
+}
+
+func New(opts ...Option) objectinfostorage.ObjectInfoStorer {
+	s := &objectInfoStorage{
+		dialect: sqldialect.Postgres,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package sqlobjectinfostorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+)
+
+// CreateIndex method creates the object info table with a unique constraint for the ETag field.
+func (s *objectInfoStorage) CreateIndex(ctx context.Context) error {
+	table := sqldialect.Quote(s.tableName)
+	statement := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+	bucket_name TEXT NOT NULL DEFAULT '',
+	object_key TEXT NOT NULL DEFAULT '',
+	content_length BIGINT NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL DEFAULT '',
+	etag TEXT NOT NULL CONSTRAINT ` + sqldialect.Quote(s.tableName+"_etag_key") + ` UNIQUE,
+	status TEXT NOT NULL DEFAULT '',
+	report ` + s.dialect.JSONType + `
+)`
+	if _, err := s.db.ExecContext(ctx, statement); err != nil {
+		return customerror.New(constant.ErrCreateIndexFailed, true).Wrap(fmt.Errorf("sqlobjectinfostorage: failed to create table: %w", err))
+	}
+	// the tables created before the status column are altered.
+	if _, err := s.db.ExecContext(ctx, `SELECT status FROM `+table+` WHERE 1 = 0`); err != nil {
+		if _, err := s.db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN status TEXT NOT NULL DEFAULT ''`); err != nil {
+			return customerror.New(constant.ErrCreateIndexFailed, true).Wrap(fmt.Errorf("sqlobjectinfostorage: failed to add status column: %w", err))
+		}
+	}
+	return nil
+}
+
+// Create method creates an object info in the database.
+func (s *objectInfoStorage) Create(ctx context.Context, object model.ObjectInfo) error {
+	statement := `INSERT INTO ` + sqldialect.Quote(s.tableName) +
+		` (bucket_name, object_key, content_length, content_type, etag, status) VALUES (` + s.dialect.Placeholders(1, 6) + `)` +
+		` ON CONFLICT (etag) DO NOTHING`
+	res, err := s.db.ExecContext(ctx, statement, object.BucketName, object.ObjectKey, object.ContentLength, object.ContentType, object.ETag, object.Status)
+	if err != nil {
+		return customerror.New(constant.ErrCreateObjectInfo, true).
+			Wrap(fmt.Errorf("sqlobjectinfostorage: failed to create object info: %w", err)).AddData("err: " + err.Error())
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return customerror.New(constant.ErrETagExists, true).
+			Wrap(fmt.Errorf("sqlobjectinfostorage: failed to create object info: %s", constant.ErrETagExists)).AddData(object.ETag)
+	}
+	return nil
+}
+
+// Finish method stores the final status and the ingestion report of the object identified by the ETag.
+func (s *objectInfoStorage) Finish(ctx context.Context, etag string, status string, report model.Report) error {
+	content, err := json.Marshal(report)
+	if err != nil {
+		return customerror.New(constant.ErrUpdateObjectInfo, true).
+			Wrap(fmt.Errorf("sqlobjectinfostorage: failed to encode report: %w", err)).AddData("err: " + err.Error())
+	}
+	statement := `UPDATE ` + sqldialect.Quote(s.tableName) + ` SET status = ` + s.dialect.Placeholder(1) +
+		`, report = ` + s.dialect.Placeholder(2) + ` WHERE etag = ` + s.dialect.Placeholder(3)
+	if _, err := s.db.ExecContext(ctx, statement, status, string(content), etag); err != nil {
+		return customerror.New(constant.ErrUpdateObjectInfo, true).
+			Wrap(fmt.Errorf("sqlobjectinfostorage: failed to update report: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// Resume method marks the interrupted object identified by the ETag as running again.
+// It reports false if the object is not interrupted, so only one run can resume it.
+func (s *objectInfoStorage) Resume(ctx context.Context, etag string) (bool, error) {
+	statement := `UPDATE ` + sqldialect.Quote(s.tableName) + ` SET status = ` + s.dialect.Placeholder(1) +
+		` WHERE etag = ` + s.dialect.Placeholder(2) + ` AND status = ` + s.dialect.Placeholder(3)
+	res, err := s.db.ExecContext(ctx, statement, model.ObjectStatusRunning, etag, model.ObjectStatusInterrupted)
+	if err != nil {
+		return false, customerror.New(constant.ErrUpdateObjectInfo, true).
+			Wrap(fmt.Errorf("sqlobjectinfostorage: failed to resume object info: %w", err)).AddData("err: " + err.Error())
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, customerror.New(constant.ErrUpdateObjectInfo, true).
+			Wrap(fmt.Errorf("sqlobjectinfostorage: failed to resume object info: %w", err)).AddData("err: " + err.Error())
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,63 @@
+package sqlobjectinfostorage_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqlobjectinfostorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+func Test_objectInfoStorage(t *testing.T) {
+	db, err := sqlite.ConnectSQLite(config.Database{Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storage := sqlobjectinfostorage.New(
+		sqlobjectinfostorage.WithDB(db),
+		sqlobjectinfostorage.WithDialect(sqldialect.SQLite),
+		sqlobjectinfostorage.WithObjectTable("objectinfo"),
+	)
+	ctx := context.TODO()
+	if !assert.Nil(t, storage.CreateIndex(ctx)) {
+		return
+	}
+	assert.Nil(t, storage.CreateIndex(ctx), "CreateIndex is idempotent")
+
+	object := model.ObjectInfo{BucketName: "bucket", ObjectKey: "key", ContentLength: 10, ContentType: "text/plain", ETag: "etag", Status: model.ObjectStatusRunning}
+	assert.Nil(t, storage.Create(ctx, object))
+
+	err = storage.Create(ctx, object)
+	var ce *customerror.Error
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, constant.ErrETagExists, ce.Message)
+	}
+
+	resumed, err := storage.Resume(ctx, "etag")
+	assert.Nil(t, err)
+	assert.False(t, resumed, "a running object is not resumed")
+
+	assert.Nil(t, storage.Finish(ctx, "etag", model.ObjectStatusInterrupted, model.Report{Inserted: 3}))
+	var content, status string
+	if assert.Nil(t, db.QueryRow(`SELECT report, status FROM objectinfo WHERE etag = 'etag'`).Scan(&content, &status)) {
+		var report model.Report
+		assert.Nil(t, json.Unmarshal([]byte(content), &report))
+		assert.Equal(t, int64(3), report.Inserted)
+		assert.Equal(t, model.ObjectStatusInterrupted, status)
+	}
+
+	resumed, err = storage.Resume(ctx, "etag")
+	assert.Nil(t, err)
+	assert.True(t, resumed, "an interrupted object is resumed")
+	resumed, err = storage.Resume(ctx, "etag")
+	assert.Nil(t, err)
+	assert.False(t, resumed, "an object is resumed only once")
+}
@@ -0,0 +1,52 @@
+package sqlproductstorage
+
+import (
+	"database/sql"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+)
+
+// productStorage is the SQL implementation of productstorage.ProductStorer.
+type productStorage struct {
+	productTableName string
+	historyTableName string
+	db               *sql.DB
+	dialect          sqldialect.Dialect
+}
+
+type Option func(*productStorage)
+
+func WithProductTable(table string) Option {
+	return func(s *productStorage) {
+		s.productTableName = table
+	}
+}
+
+// WithHistoryTable sets the table that the previous versions of the changed products are appended to.
+func WithHistoryTable(table string) Option {
+	return func(s *productStorage) {
+		s.historyTableName = table
+	}
+}
+
+func WithDB(db *sql.DB) Option {
+	return func(s *productStorage) {
+		s.db = db
+	}
+}
+
+func WithDialect(dialect sqldialect.Dialect) Option {
+	return func(s *productStorage) {
+		s.dialect = dialect
+	}
+}
+
+func New(opts ...Option) productstorage.ProductStorer {
+	s := &productStorage{
+		dialect: sqldialect.Postgres,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package sqlproductstorage_test
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqlproductstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/sqlite"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// backend is a database that the tests run against.
+type backend struct {
+	name    string
+	dialect sqldialect.Dialect
+	open    func(t *testing.T) *sql.DB
+}
+
+// backends returns SQLite and, if POSTGRES_TEST_URL is set, PostgreSQL.
+func backends() []backend {
+	backends := []backend{{
+		name:    "sqlite",
+		dialect: sqldialect.SQLite,
+		open: func(t *testing.T) *sql.DB {
+			db, err := sqlite.ConnectSQLite(config.Database{Path: filepath.Join(t.TempDir(), "test.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return db
+		},
+	}}
+	if url := os.Getenv("POSTGRES_TEST_URL"); url != "" {
+		backends = append(backends, backend{
+			name:    "postgres",
+			dialect: sqldialect.Postgres,
+			open: func(t *testing.T) *sql.DB {
+				db, err := sql.Open("pgx", url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return db
+			},
+		})
+	}
+	return backends
+}
+
+// runStorage runs the test against a storage on fresh tables of each backend.
+// The test gets the database and the product table name to inspect the rows.
+func runStorage(t *testing.T, test func(t *testing.T, storage productstorage.ProductStorer, db *sql.DB, table string)) {
+	for _, b := range backends() {
+		t.Run(b.name, func(t *testing.T) {
+			db := b.open(t)
+			table := "products_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+			t.Cleanup(func() {
+				_, _ = db.Exec(`DROP TABLE IF EXISTS ` + sqldialect.Quote(table))
+				_, _ = db.Exec(`DROP TABLE IF EXISTS ` + sqldialect.Quote(table+"_history"))
+				_ = db.Close()
+			})
+			storage := sqlproductstorage.New(
+				sqlproductstorage.WithDB(db),
+				sqlproductstorage.WithDialect(b.dialect),
+				sqlproductstorage.WithProductTable(table),
+				sqlproductstorage.WithHistoryTable(table+"_history"),
+			)
+			if err := storage.CreateIndex(context.TODO()); err != nil {
+				t.Fatal(err)
+			}
+			test(t, storage, db, table)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package sqlproductstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"strings"
+)
+
+// stagingTableName returns the name of the staging table of the run.
+func (s *productStorage) stagingTableName(runID string) string {
+	return s.productTableName + "_staging_" + runID
+}
+
+// Staging method returns a storage that writes into the staging table of the run.
+func (s *productStorage) Staging(ctx context.Context, runID string) (productstorage.ProductStorer, error) {
+	staging := &productStorage{
+		productTableName: s.stagingTableName(runID),
+		historyTableName: s.historyTableName,
+		db:               s.db,
+		dialect:          s.dialect,
+	}
+	if err := staging.createProductTable(ctx, staging.productTableName); err != nil {
+		return nil, customerror.New(constant.ErrCreateStaging, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to create staging table: %w", err)).AddData("err: " + err.Error())
+	}
+	return staging, nil
+}
+
+// MergeStaging method merges the staging table of the run into the product table by product ID and drops it.
+// whenMatched decides how a stored product is changed. It is one of the productstorage.WhenMatched values.
+func (s *productStorage) MergeStaging(ctx context.Context, runID string, whenMatched string) error {
+	table := sqldialect.Quote(s.productTableName)
+	columns := strings.Join(productColumns, ", ")
+	conflict := `DO NOTHING`
+	switch whenMatched {
+	case productstorage.WhenMatchedReplace:
+		conflict = s.conflictUpdate(table, false)
+	case productstorage.WhenMatchedMerge:
+		conflict = s.conflictUpdate(table, true)
+	}
+	// WHERE true resolves the parsing ambiguity between the ON clause of a join and ON CONFLICT in SQLite.
+	statement := `INSERT INTO ` + table + ` (` + columns + `) SELECT ` + columns + ` FROM ` +
+		sqldialect.Quote(s.stagingTableName(runID)) + ` WHERE true ON CONFLICT (id) ` + conflict
+	if _, err := s.db.ExecContext(ctx, statement); err != nil {
+		return customerror.New(constant.ErrPromoteStaging, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to merge staging table: %w", err)).AddData("err: " + err.Error())
+	}
+	return s.DropStaging(ctx, runID)
+}
+
+// RenameStaging method replaces the product table with the staging table of the run in a transaction.
+// The products that are not in the staging table are deleted, so the run must write every product of the table.
+// The indexes of the staging table are recreated with the names of the product table.
+func (s *productStorage) RenameStaging(ctx context.Context, runID string) error {
+	staging := s.stagingTableName(runID)
+	statements := []string{
+		`DROP TABLE IF EXISTS ` + sqldialect.Quote(s.productTableName),
+		`ALTER TABLE ` + sqldialect.Quote(staging) + ` RENAME TO ` + sqldialect.Quote(s.productTableName),
+		`DROP INDEX IF EXISTS ` + sqldialect.Quote(staging+"_id_idx"),
+		`DROP INDEX IF EXISTS ` + sqldialect.Quote(staging+"_source_idx"),
+	}
+	statements = append(statements, indexStatements(s.productTableName)...)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return customerror.New(constant.ErrPromoteStaging, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to rename staging table: %w", err)).AddData("err: " + err.Error())
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return customerror.New(constant.ErrPromoteStaging, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to rename staging table: %w", err)).AddData("err: " + err.Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return customerror.New(constant.ErrPromoteStaging, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to rename staging table: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
+
+// DropStaging method drops the staging table of the run.
+func (s *productStorage) DropStaging(ctx context.Context, runID string) error {
+	if _, err := s.db.ExecContext(ctx, `DROP TABLE IF EXISTS `+sqldialect.Quote(s.stagingTableName(runID))); err != nil {
+		return customerror.New(constant.ErrDropStaging, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to drop staging table: %w", err)).AddData("err: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package sqlproductstorage_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"testing"
+)
+
+func Test_productStorage_MergeStaging(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, db *sql.DB, table string) {
+		ctx := context.TODO()
+		storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "a", Brand: "brand"}, {ID: 2, Title: "b"}})
+
+		staging, err := storage.Staging(ctx, "run")
+		if !assert.Nil(t, err) {
+			return
+		}
+		result := staging.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "new"}, {ID: 3, Title: "c"}})
+		assert.Equal(t, int64(2), result.Inserted)
+
+		products, err := storage.FindByIDs(ctx, []int{1, 3})
+		assert.Nil(t, err)
+		assert.Equal(t, "a", products[1].Title)
+		assert.Len(t, products, 1)
+
+		assert.Nil(t, storage.MergeStaging(ctx, "run", productstorage.WhenMatchedMerge))
+		products, err = storage.FindByIDs(ctx, []int{1, 2, 3})
+		assert.Nil(t, err)
+		assert.Equal(t, model.Product{ID: 1, Title: "new", Brand: "brand"}, products[1])
+		assert.Equal(t, "b", products[2].Title)
+		assert.Equal(t, "c", products[3].Title)
+
+		var count int
+		err = db.QueryRow(`SELECT count(*) FROM "` + table + `_staging_run"`).Scan(&count)
+		assert.NotNil(t, err, "staging table is dropped")
+	})
+}
+
+func Test_productStorage_RenameStaging(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+		storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "a"}, {ID: 2, Title: "b"}})
+
+		staging, err := storage.Staging(ctx, "run")
+		if !assert.Nil(t, err) {
+			return
+		}
+		staging.ReplaceBatch(ctx, []model.Product{{ID: 3, Title: "c"}})
+		assert.Nil(t, storage.RenameStaging(ctx, "run"))
+
+		products, err := storage.FindByIDs(ctx, []int{1, 2, 3})
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+		assert.Equal(t, "c", products[3].Title)
+
+		result := storage.CreateBatch(ctx, []model.Product{{ID: 3}})
+		assert.Len(t, result.Errors, 1, "unique id index is kept")
+	})
+}
+
+func Test_productStorage_DropStaging(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+		staging, err := storage.Staging(ctx, "run")
+		if !assert.Nil(t, err) {
+			return
+		}
+		staging.ReplaceBatch(ctx, []model.Product{{ID: 1}})
+		assert.Nil(t, storage.DropStaging(ctx, "run"))
+
+		products, err := storage.FindByIDs(ctx, []int{1})
+		assert.Nil(t, err)
+		assert.Empty(t, products)
+	})
+}
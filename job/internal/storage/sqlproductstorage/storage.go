@@ -0,0 +1,476 @@
+package sqlproductstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"strings"
+	"time"
+)
+
+// chunkSize is the maximum number of rows of a single statement. It keeps the statements under the placeholder limit of the databases.
+const chunkSize = 1000
+
+// productColumns are the columns of the product table. The id column is the first one.
+var productColumns = []string{"id", "title", "price", "category", "brand", "url", "description", "hash", "source", "ts", "deleted_at"}
+
+// writeKind selects how a batch write resolves the conflicts on the id column.
+type writeKind int
+
+const (
+	writeInsert writeKind = iota
+	writeReplace
+	writeMerge
+	writeDelete
+)
+
+// CreateIndex method creates the product table with a unique index for the ID column and an index for the source column.
+// If the history table is set, it also creates the history table with an index for the product ID and archive time.
+func (s *productStorage) CreateIndex(ctx context.Context) error {
+	if err := s.createProductTable(ctx, s.productTableName); err != nil {
+		return customerror.New(constant.ErrCreateIndexFailed, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to create table: %w", err)).AddData("err: " + err.Error())
+	}
+	if s.historyTableName == "" {
+		return nil
+	}
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + sqldialect.Quote(s.historyTableName) + ` (
+	product_id BIGINT NOT NULL,
+	product ` + s.dialect.JSONType + ` NOT NULL,
+	source_bucket TEXT NOT NULL DEFAULT '',
+	source_object TEXT NOT NULL DEFAULT '',
+	archived_at ` + s.dialect.TimestampType + ` NOT NULL
+)`,
+		`CREATE INDEX IF NOT EXISTS ` + sqldialect.Quote(s.historyTableName+"_product_id_archived_at_idx") +
+			` ON ` + sqldialect.Quote(s.historyTableName) + ` (product_id, archived_at)`,
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return customerror.New(constant.ErrCreateIndexFailed, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to create history table: %w", err)).AddData("err: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *productStorage) createProductTable(ctx context.Context, table string) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + sqldialect.Quote(table) + ` (
+	id BIGINT NOT NULL,
+	title TEXT NOT NULL DEFAULT '',
+	price DOUBLE PRECISION NOT NULL DEFAULT 0,
+	category TEXT NOT NULL DEFAULT '',
+	brand TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	hash TEXT,
+	source TEXT,
+	ts BIGINT,
+	deleted_at ` + s.dialect.TimestampType + `
+)`,
+	}
+	statements = append(statements, indexStatements(table)...)
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexStatements(table string) []string {
+	return []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS ` + sqldialect.Quote(table+"_id_idx") + ` ON ` + sqldialect.Quote(table) + ` (id)`,
+		`CREATE INDEX IF NOT EXISTS ` + sqldialect.Quote(table+"_source_idx") + ` ON ` + sqldialect.Quote(table) + ` (source)`,
+	}
+}
+
+// Create method creates a product in the database.
+func (s *productStorage) Create(ctx context.Context, product model.Product) error {
+	result := s.CreateBatch(ctx, []model.Product{product})
+	return result.Errors[0]
+}
+
+// CreateBatch method inserts the products and skips the ones whose ID already exists.
+// Write errors are mapped back to the index of the product in the batch. If a statement fails, every product of it gets the error.
+func (s *productStorage) CreateBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return s.writeBatch(ctx, products, writeInsert, constant.ErrCreateProduct)
+}
+
+// ReplaceBatch method replaces the whole row of each product by its ID. Missing products are inserted.
+func (s *productStorage) ReplaceBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return s.writeBatch(ctx, products, writeReplace, constant.ErrReplaceProduct)
+}
+
+// MergeBatch method sets only the non-empty fields of each product by its ID. Missing products are inserted.
+func (s *productStorage) MergeBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return s.writeBatch(ctx, products, writeMerge, constant.ErrMergeProduct)
+}
+
+// DeleteBatch method marks each product as deleted by its ID. Missing products are inserted as tombstones,
+// so an older upsert event that arrives later is rejected as stale.
+func (s *productStorage) DeleteBatch(ctx context.Context, products []model.Product) productstorage.BatchResult {
+	return s.writeBatch(ctx, products, writeDelete, constant.ErrDeleteProducts)
+}
+
+// writeBatch method writes the products in rounds that hold each ID at most once, since a statement can not touch the same row twice.
+func (s *productStorage) writeBatch(ctx context.Context, products []model.Product, kind writeKind, message string) productstorage.BatchResult {
+	result := productstorage.BatchResult{Errors: make(map[int]error)}
+	for _, round := range uniqueRounds(products) {
+		for start := 0; start < len(round); start += chunkSize {
+			end := min(start+chunkSize, len(round))
+			s.writeChunk(ctx, products, round[start:end], kind, message, &result)
+		}
+	}
+	return result
+}
+
+// writeChunk method writes the products at the indexes in a transaction. The stored IDs and timestamps are read first,
+// so the rows returned by the statement can be classified as inserted or updated and the skipped ones as unchanged or stale.
+func (s *productStorage) writeChunk(ctx context.Context, products []model.Product, indexes []int, kind writeKind, message string, result *productstorage.BatchResult) {
+	fail := func(err error) {
+		for _, i := range indexes {
+			result.Errors[i] = customerror.New(message, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to write batch: %w", err)).AddData("err: " + err.Error())
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(indexes))
+	for n, i := range indexes {
+		ids[n] = products[i].ID
+	}
+	stored, err := s.storedTimestamps(ctx, tx, ids)
+	if err != nil {
+		fail(err)
+		return
+	}
+	statement, args := s.upsertStatement(products, indexes, kind)
+	written, err := queryIDs(ctx, tx, statement, args...)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		fail(err)
+		return
+	}
+
+	for _, i := range indexes {
+		product := products[i]
+		ts, exists := stored[product.ID]
+		switch {
+		case written[product.ID] && kind == writeDelete:
+			result.Deleted++
+		case written[product.ID] && exists:
+			result.Updated++
+		case written[product.ID]:
+			result.Inserted++
+		case kind == writeInsert:
+			result.Errors[i] = customerror.New(constant.ErrIDExists, false).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to create product: %s", constant.ErrIDExists)).AddData(product.ID)
+		case product.Ts != 0 && ts.Valid && ts.Int64 >= product.Ts:
+			result.Errors[i] = customerror.New(constant.ErrStaleEvent, false).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to write product: %s", constant.ErrStaleEvent)).AddData(product.ID)
+		default:
+			result.Unchanged++
+		}
+	}
+}
+
+// storedTimestamps method returns the event timestamps of the stored products keyed by their IDs.
+func (s *productStorage) storedTimestamps(ctx context.Context, tx *sql.Tx, ids []int) (map[int]sql.NullInt64, error) {
+	statement := `SELECT id, ts FROM ` + sqldialect.Quote(s.productTableName) + ` WHERE id IN (` + s.dialect.Placeholders(1, len(ids)) + `)`
+	rows, err := tx.QueryContext(ctx, statement, intArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stored := make(map[int]sql.NullInt64, len(ids))
+	for rows.Next() {
+		var id int
+		var ts sql.NullInt64
+		if err := rows.Scan(&id, &ts); err != nil {
+			return nil, err
+		}
+		stored[id] = ts
+	}
+	return stored, rows.Err()
+}
+
+// upsertStatement method builds the insert statement of the products at the indexes and its arguments.
+// Conflicting rows are updated only if the update changes them and the stored event is not newer.
+func (s *productStorage) upsertStatement(products []model.Product, indexes []int, kind writeKind) (string, []any) {
+	table := sqldialect.Quote(s.productTableName)
+	columns := productColumns
+	if kind == writeDelete {
+		columns = []string{"id", "source", "ts", "deleted_at"}
+	}
+	values := make([]string, len(indexes))
+	args := make([]any, 0, len(indexes)*len(columns))
+	for n, i := range indexes {
+		values[n] = "(" + s.dialect.Placeholders(len(args)+1, len(columns)) + ")"
+		if kind == writeDelete {
+			args = append(args, tombstoneArgs(products[i])...)
+			continue
+		}
+		args = append(args, productArgs(products[i])...)
+	}
+
+	var b strings.Builder
+	b.WriteString(`INSERT INTO ` + table + ` (` + strings.Join(columns, ", ") + `) VALUES ` + strings.Join(values, ", "))
+	switch kind {
+	case writeInsert:
+		b.WriteString(` ON CONFLICT (id) DO NOTHING`)
+	case writeDelete:
+		b.WriteString(` ON CONFLICT (id) DO UPDATE SET deleted_at = excluded.deleted_at, ts = COALESCE(excluded.ts, ` + table + `.ts), source = COALESCE(excluded.source, ` + table + `.source)`)
+		b.WriteString(` WHERE ` + tsGuard(table))
+	default:
+		b.WriteString(` ON CONFLICT (id) ` + s.conflictUpdate(table, kind == writeMerge))
+		b.WriteString(` AND ` + tsGuard(table))
+	}
+	b.WriteString(` RETURNING id`)
+	return b.String(), args
+}
+
+// conflictUpdate method returns the DO UPDATE clause that replaces or merges the conflicting row of the table.
+// The WHERE clause skips the rows that the update would not change, so they are not returned as written.
+func (s *productStorage) conflictUpdate(table string, merge bool) string {
+	sets := make([]string, 0, len(productColumns)-1)
+	changes := make([]string, 0, len(productColumns)-1)
+	for _, column := range productColumns[1:] {
+		value := "excluded." + column
+		if merge {
+			value = mergeValue(table, column)
+		}
+		sets = append(sets, column+" = "+value)
+		changes = append(changes, s.dialect.IsDistinct(table+"."+column, value))
+	}
+	return `DO UPDATE SET ` + strings.Join(sets, ", ") + ` WHERE (` + strings.Join(changes, " OR ") + `)`
+}
+
+// mergeValue returns the value of the column after merging the excluded row into the row of the table.
+// Empty fields keep the stored value and a merged product is no longer deleted.
+func mergeValue(table, column string) string {
+	switch column {
+	case "price":
+		return `CASE WHEN excluded.price = 0 THEN ` + table + `.price ELSE excluded.price END`
+	case "hash", "source", "ts":
+		return `COALESCE(excluded.` + column + `, ` + table + `.` + column + `)`
+	case "deleted_at":
+		return `NULL`
+	default:
+		return `CASE WHEN excluded.` + column + ` = '' THEN ` + table + `.` + column + ` ELSE excluded.` + column + ` END`
+	}
+}
+
+// tsGuard returns the condition that rejects an event older than the stored one.
+func tsGuard(table string) string {
+	return `(excluded.ts IS NULL OR ` + table + `.ts IS NULL OR ` + table + `.ts < excluded.ts)`
+}
+
+// FindByIDs method returns the stored products with the given IDs keyed by their IDs.
+func (s *productStorage) FindByIDs(ctx context.Context, ids []int) (map[int]model.Product, error) {
+	products := make(map[int]model.Product, len(ids))
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		statement := `SELECT ` + strings.Join(productColumns, ", ") + ` FROM ` + sqldialect.Quote(s.productTableName) +
+			` WHERE id IN (` + s.dialect.Placeholders(1, len(chunk)) + `)`
+		if err := s.scanProducts(ctx, products, statement, intArgs(chunk)...); err != nil {
+			return nil, customerror.New(constant.ErrFindProducts, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to find products: %w", err)).AddData("err: " + err.Error())
+		}
+	}
+	return products, nil
+}
+
+func (s *productStorage) scanProducts(ctx context.Context, products map[int]model.Product, statement string, args ...any) error {
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var product model.Product
+		var hash, source sql.NullString
+		var ts sql.NullInt64
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&product.ID, &product.Title, &product.Price, &product.Category, &product.Brand,
+			&product.Url, &product.Description, &hash, &source, &ts, &deletedAt); err != nil {
+			return err
+		}
+		product.Hash = hash.String
+		product.Source = source.String
+		product.Ts = ts.Int64
+		if deletedAt.Valid {
+			product.DeletedAt = &deletedAt.Time
+		}
+		products[product.ID] = product
+	}
+	return rows.Err()
+}
+
+// CreateHistory method appends the previous versions of the products to the history table.
+func (s *productStorage) CreateHistory(ctx context.Context, history []model.ProductHistory) error {
+	for start := 0; start < len(history); start += chunkSize {
+		chunk := history[start:min(start+chunkSize, len(history))]
+		values := make([]string, len(chunk))
+		args := make([]any, 0, len(chunk)*5)
+		for n, h := range chunk {
+			product, err := json.Marshal(h.Product)
+			if err != nil {
+				return customerror.New(constant.ErrCreateHistory, true).
+					Wrap(fmt.Errorf("sqlproductstorage: failed to encode history: %w", err)).AddData("err: " + err.Error())
+			}
+			values[n] = "(" + s.dialect.Placeholders(len(args)+1, 5) + ")"
+			args = append(args, h.ProductID, string(product), h.SourceBucket, h.SourceObject, h.ArchivedAt)
+		}
+		statement := `INSERT INTO ` + sqldialect.Quote(s.historyTableName) +
+			` (product_id, product, source_bucket, source_object, archived_at) VALUES ` + strings.Join(values, ", ")
+		if _, err := s.db.ExecContext(ctx, statement, args...); err != nil {
+			return customerror.New(constant.ErrCreateHistory, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to create history: %w", err)).AddData("err: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// FindIDsBySource method returns the IDs of the products loaded from the source that are not deleted.
+func (s *productStorage) FindIDsBySource(ctx context.Context, source string) ([]int, error) {
+	statement := `SELECT id FROM ` + sqldialect.Quote(s.productTableName) +
+		` WHERE source = ` + s.dialect.Placeholder(1) + ` AND deleted_at IS NULL`
+	rows, err := s.db.QueryContext(ctx, statement, source)
+	if err != nil {
+		return nil, customerror.New(constant.ErrFindProducts, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to find products by source: %w", err)).AddData("err: " + err.Error())
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, customerror.New(constant.ErrFindProducts, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to scan product id: %w", err)).AddData("err: " + err.Error())
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, customerror.New(constant.ErrFindProducts, true).
+			Wrap(fmt.Errorf("sqlproductstorage: failed to find products by source: %w", err)).AddData("err: " + err.Error())
+	}
+	return ids, nil
+}
+
+// DeleteByIDs method deletes the products with the given IDs in chunks and returns the number of deleted products.
+// A soft delete sets the deleted_at column, a hard delete removes the rows.
+func (s *productStorage) DeleteByIDs(ctx context.Context, ids []int, hard bool) (int64, error) {
+	var deleted int64
+	now := time.Now().UTC()
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		var statement string
+		var args []any
+		if hard {
+			statement = `DELETE FROM ` + sqldialect.Quote(s.productTableName) +
+				` WHERE id IN (` + s.dialect.Placeholders(1, len(chunk)) + `)`
+			args = intArgs(chunk)
+		} else {
+			statement = `UPDATE ` + sqldialect.Quote(s.productTableName) + ` SET deleted_at = ` + s.dialect.Placeholder(1) +
+				` WHERE id IN (` + s.dialect.Placeholders(2, len(chunk)) + `) AND deleted_at IS NULL`
+			args = append([]any{now}, intArgs(chunk)...)
+		}
+		res, err := s.db.ExecContext(ctx, statement, args...)
+		if err != nil {
+			return deleted, customerror.New(constant.ErrDeleteProducts, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to delete products: %w", err)).AddData("err: " + err.Error())
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return deleted, customerror.New(constant.ErrDeleteProducts, true).
+				Wrap(fmt.Errorf("sqlproductstorage: failed to count deleted products: %w", err)).AddData("err: " + err.Error())
+		}
+		deleted += affected
+	}
+	return deleted, nil
+}
+
+// uniqueRounds splits the indexes of the products into rounds in which each ID appears at most once.
+// The order of the products with the same ID is kept across the rounds.
+func uniqueRounds(products []model.Product) [][]int {
+	var rounds [][]int
+	seen := make(map[int]int, len(products))
+	for i, product := range products {
+		round := seen[product.ID]
+		seen[product.ID] = round + 1
+		if round == len(rounds) {
+			rounds = append(rounds, nil)
+		}
+		rounds[round] = append(rounds[round], i)
+	}
+	return rounds
+}
+
+func queryIDs(ctx context.Context, tx *sql.Tx, statement string, args ...any) (map[int]bool, error) {
+	rows, err := tx.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+func productArgs(product model.Product) []any {
+	return []any{product.ID, product.Title, product.Price, product.Category, product.Brand, product.Url, product.Description,
+		nullString(product.Hash), nullString(product.Source), nullInt(product.Ts), nullTime(product.DeletedAt)}
+}
+
+func tombstoneArgs(product model.Product) []any {
+	deletedAt := time.Now().UTC()
+	if product.DeletedAt != nil {
+		deletedAt = *product.DeletedAt
+	}
+	return []any{product.ID, nullString(product.Source), nullInt(product.Ts), deletedAt}
+}
+
+func intArgs(ids []int) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
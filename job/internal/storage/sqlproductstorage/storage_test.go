@@ -0,0 +1,161 @@
+package sqlproductstorage_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/customerror"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/productstorage"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/storage/sqldialect"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/constant"
+	"sort"
+	"testing"
+	"time"
+)
+
+func errorMessage(err error) string {
+	if ce, ok := err.(*customerror.Error); ok {
+		return ce.Message
+	}
+	return ""
+}
+
+func Test_productStorage_CreateBatch(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+
+		result := storage.CreateBatch(ctx, []model.Product{{ID: 1, Title: "a"}, {ID: 2, Title: "b"}, {ID: 1, Title: "c"}})
+		assert.Equal(t, int64(2), result.Inserted)
+		if assert.Len(t, result.Errors, 1) {
+			assert.Equal(t, constant.ErrIDExists, errorMessage(result.Errors[2]))
+		}
+
+		err := storage.Create(ctx, model.Product{ID: 2})
+		assert.Equal(t, constant.ErrIDExists, errorMessage(err))
+
+		products, err := storage.FindByIDs(ctx, []int{1, 2, 3})
+		assert.Nil(t, err)
+		assert.Len(t, products, 2)
+		assert.Equal(t, "a", products[1].Title)
+	})
+}
+
+func Test_productStorage_ReplaceBatch(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+
+		result := storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "a", Price: 1}})
+		assert.Equal(t, int64(1), result.Inserted)
+
+		result = storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "a", Price: 1}, {ID: 2, Title: "b"}})
+		assert.Equal(t, int64(1), result.Inserted)
+		assert.Equal(t, int64(1), result.Unchanged)
+
+		result = storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "new"}})
+		assert.Equal(t, int64(1), result.Updated)
+
+		products, err := storage.FindByIDs(ctx, []int{1})
+		assert.Nil(t, err)
+		assert.Equal(t, model.Product{ID: 1, Title: "new"}, products[1])
+	})
+}
+
+func Test_productStorage_ReplaceBatch_Stale(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+
+		result := storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "new", Ts: 2}})
+		assert.Equal(t, int64(1), result.Inserted)
+
+		result = storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "old", Ts: 1}})
+		if assert.Len(t, result.Errors, 1) {
+			assert.Equal(t, constant.ErrStaleEvent, errorMessage(result.Errors[0]))
+		}
+
+		products, err := storage.FindByIDs(ctx, []int{1})
+		assert.Nil(t, err)
+		assert.Equal(t, "new", products[1].Title)
+	})
+}
+
+func Test_productStorage_MergeBatch(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+
+		storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "a", Brand: "brand", Price: 1}})
+		result := storage.MergeBatch(ctx, []model.Product{{ID: 1, Price: 2}})
+		assert.Equal(t, int64(1), result.Updated)
+
+		products, err := storage.FindByIDs(ctx, []int{1})
+		assert.Nil(t, err)
+		assert.Equal(t, model.Product{ID: 1, Title: "a", Brand: "brand", Price: 2}, products[1])
+	})
+}
+
+func Test_productStorage_DeleteBatch(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+
+		storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Title: "a", Ts: 1}})
+		result := storage.DeleteBatch(ctx, []model.Product{{ID: 1, Ts: 2}, {ID: 2, Ts: 2}})
+		assert.Equal(t, int64(2), result.Deleted)
+		assert.Empty(t, result.Errors)
+
+		result = storage.ReplaceBatch(ctx, []model.Product{{ID: 2, Title: "late", Ts: 1}})
+		if assert.Len(t, result.Errors, 1) {
+			assert.Equal(t, constant.ErrStaleEvent, errorMessage(result.Errors[0]))
+		}
+
+		products, err := storage.FindByIDs(ctx, []int{1, 2})
+		assert.Nil(t, err)
+		assert.NotNil(t, products[1].DeletedAt)
+		assert.NotNil(t, products[2].DeletedAt)
+	})
+}
+
+func Test_productStorage_DeleteByIDs(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, _ *sql.DB, _ string) {
+		ctx := context.TODO()
+
+		storage.ReplaceBatch(ctx, []model.Product{{ID: 1, Source: "s"}, {ID: 2, Source: "s"}, {ID: 3, Source: "other"}})
+		ids, err := storage.FindIDsBySource(ctx, "s")
+		assert.Nil(t, err)
+		sort.Ints(ids)
+		assert.Equal(t, []int{1, 2}, ids)
+
+		deleted, err := storage.DeleteByIDs(ctx, []int{1}, false)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), deleted)
+		ids, err = storage.FindIDsBySource(ctx, "s")
+		assert.Nil(t, err)
+		assert.Equal(t, []int{2}, ids)
+
+		deleted, err = storage.DeleteByIDs(ctx, []int{2, 3}, true)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), deleted)
+		products, err := storage.FindByIDs(ctx, []int{1, 2, 3})
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+	})
+}
+
+func Test_productStorage_CreateHistory(t *testing.T) {
+	runStorage(t, func(t *testing.T, storage productstorage.ProductStorer, db *sql.DB, table string) {
+		ctx := context.TODO()
+
+		err := storage.CreateHistory(ctx, []model.ProductHistory{{
+			ProductID:    1,
+			Product:      model.Product{ID: 1, Title: "a"},
+			SourceBucket: "bucket",
+			SourceObject: "object",
+			ArchivedAt:   time.Now(),
+		}})
+		assert.Nil(t, err)
+
+		var count int
+		err = db.QueryRow(`SELECT count(*) FROM ` + sqldialect.Quote(table+"_history") + ` WHERE product_id = 1`).Scan(&count)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
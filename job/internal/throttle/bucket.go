@@ -0,0 +1,54 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket of products. A batch larger than the burst is let through by going into debt,
+// so the next batches wait until the debt is paid back.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst int) *bucket {
+	b := &bucket{rate: rate, burst: float64(burst), last: time.Now()}
+	if b.burst < 1 {
+		b.burst = max(rate, 1)
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// wait takes n tokens from the bucket and waits until the tokens are available.
+func (b *bucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,157 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits the product writes of all services that share it. The products written per second are limited
+// by a token bucket and the batch writes in flight by a semaphore. In adaptive mode, the in-flight limit is halved
+// when the write latency exceeds the target latency or a write fails, and raised by one after a limit worth
+// of fast writes, so the writers back off while the database is under pressure.
+// A zero rate or in-flight limit is not limited.
+type Limiter struct {
+	bucket *bucket
+
+	mu            sync.Mutex
+	inFlight      int
+	limit         int
+	maxInFlight   int
+	minInFlight   int
+	adaptive      bool
+	targetLatency time.Duration
+	successes     int
+	lastDecrease  time.Time
+	released      chan struct{}
+}
+
+type Option func(*Limiter)
+
+// WithRate sets the products written per second and the burst of products written at once.
+// If the burst is less than one, it is one second worth of products.
+func WithRate(rate float64, burst int) Option {
+	return func(l *Limiter) {
+		if rate > 0 {
+			l.bucket = newBucket(rate, burst)
+		}
+	}
+}
+
+// WithMaxInFlight sets the maximum number of batch writes in flight.
+func WithMaxInFlight(n int) Option {
+	return func(l *Limiter) {
+		l.maxInFlight = n
+	}
+}
+
+// WithAdaptive adapts the in-flight limit between minInFlight and the maximum in-flight limit to the write latency.
+// It requires a maximum in-flight limit.
+func WithAdaptive(minInFlight int, targetLatency time.Duration) Option {
+	return func(l *Limiter) {
+		l.adaptive = true
+		l.minInFlight = minInFlight
+		l.targetLatency = targetLatency
+	}
+}
+
+func New(opts ...Option) *Limiter {
+	l := &Limiter{
+		released: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.limit = l.maxInFlight
+	l.minInFlight = min(max(l.minInFlight, 1), max(l.maxInFlight, 1))
+	return l
+}
+
+// Acquire method waits until a batch of n products can be written. Each successful Acquire must be followed by a Release.
+// It returns the error of the context if the context is done first.
+func (l *Limiter) Acquire(ctx context.Context, n int) error {
+	if err := l.acquireSlot(ctx); err != nil {
+		return err
+	}
+	if l.bucket == nil {
+		return nil
+	}
+	if err := l.bucket.wait(ctx, n); err != nil {
+		l.releaseSlot()
+		return err
+	}
+	return nil
+}
+
+// Release method ends a batch write with its latency and whether it failed. The adaptive mode adjusts the in-flight limit.
+func (l *Limiter) Release(latency time.Duration, failed bool) {
+	if l.maxInFlight <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if l.adaptive {
+		l.adapt(latency, failed)
+	}
+	l.mu.Unlock()
+	l.releaseSlot()
+}
+
+// Limit method returns the current in-flight limit. It is zero if the writes in flight are not limited.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// acquireSlot waits until the writes in flight are less than the limit.
+func (l *Limiter) acquireSlot(ctx context.Context) error {
+	if l.maxInFlight <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.limit {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// releaseSlot frees a slot and wakes up the waiting writers.
+func (l *Limiter) releaseSlot() {
+	if l.maxInFlight <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.inFlight--
+	close(l.released)
+	l.released = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// adapt halves the limit on a slow or failed write and raises it by one after a limit worth of fast writes.
+// The limit is halved at most once per target latency, since the writes in flight report the same congestion.
+func (l *Limiter) adapt(latency time.Duration, failed bool) {
+	if failed || latency > l.targetLatency {
+		l.successes = 0
+		if time.Since(l.lastDecrease) < l.targetLatency {
+			return
+		}
+		l.limit = max(l.limit/2, l.minInFlight)
+		l.lastDecrease = time.Now()
+		return
+	}
+	l.successes++
+	if l.successes >= l.limit && l.limit < l.maxInFlight {
+		l.limit++
+		l.successes = 0
+	}
+}
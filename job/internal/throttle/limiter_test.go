@@ -0,0 +1,118 @@
+package throttle_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/internal/throttle"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Rate(t *testing.T) {
+	l := throttle.New(throttle.WithRate(100, 10))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Acquire(context.Background(), 10); err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		l.Release(0, false)
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("30 products with a rate of 100 and a burst of 10 took %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestLimiter_MaxInFlight(t *testing.T) {
+	l := throttle.New(throttle.WithMaxInFlight(2))
+	var inFlight, peak atomic.Int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Acquire(context.Background(), 1); err != nil {
+				t.Errorf("Acquire() error = %v", err)
+				return
+			}
+			n := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			inFlight.Add(-1)
+			l.Release(5*time.Millisecond, false)
+		}()
+	}
+	wg.Wait()
+	if peak.Load() != 2 {
+		t.Errorf("writes in flight = %d, want 2", peak.Load())
+	}
+}
+
+func TestLimiter_Acquire_ContextDone(t *testing.T) {
+	l := throttle.New(throttle.WithMaxInFlight(1))
+	if err := l.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLimiter_Adaptive(t *testing.T) {
+	tests := []struct {
+		name      string
+		releases  []time.Duration
+		failed    bool
+		wantLimit int
+	}{
+		{name: "fast writes keep the limit", releases: []time.Duration{time.Millisecond, time.Millisecond}, wantLimit: 8},
+		{name: "a slow write halves the limit", releases: []time.Duration{time.Second}, wantLimit: 4},
+		{name: "a failed write halves the limit", releases: []time.Duration{time.Millisecond}, failed: true, wantLimit: 4},
+		{name: "slow writes at once halve the limit once", releases: []time.Duration{time.Second, time.Second, time.Second}, wantLimit: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := throttle.New(throttle.WithMaxInFlight(8), throttle.WithAdaptive(2, 100*time.Millisecond))
+			for _, latency := range tt.releases {
+				if err := l.Acquire(context.Background(), 1); err != nil {
+					t.Fatalf("Acquire() error = %v", err)
+				}
+				l.Release(latency, tt.failed)
+			}
+			if got := l.Limit(); got != tt.wantLimit {
+				t.Errorf("Limit() = %d, want %d", got, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestLimiter_Adaptive_Recovers(t *testing.T) {
+	l := throttle.New(throttle.WithMaxInFlight(4), throttle.WithAdaptive(1, 10*time.Millisecond))
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire(context.Background(), 1); err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		l.Release(time.Second, false)
+		time.Sleep(15 * time.Millisecond)
+	}
+	if got := l.Limit(); got != 1 {
+		t.Fatalf("Limit() after slow writes = %d, want 1", got)
+	}
+	for i := 0; i < 20; i++ {
+		if err := l.Acquire(context.Background(), 1); err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		l.Release(time.Millisecond, false)
+	}
+	if got := l.Limit(); got != 4 {
+		t.Errorf("Limit() after fast writes = %d, want 4", got)
+	}
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// DeadLetter is a product that could not be written to the product collection.
+// It keeps the error of the last attempt, so the product can be fixed and loaded again.
+type DeadLetter struct {
+	UID       primitive.ObjectID `bson:"_id,omitempty"`
+	Product   Product            `bson:"product"`
+	Source    string             `bson:"source,omitempty"`
+	ObjectKey string             `bson:"object_key"`
+	Error     string             `bson:"error"`
+	Class     string             `bson:"class"`
+	Attempts  int                `bson:"attempts"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
@@ -2,9 +2,22 @@ package model
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Statuses of an object. An object is running while it is processed. An interrupted object is resumed by the next run,
+// while a done or failed object is skipped as a duplicate.
+const (
+	ObjectStatusRunning     = "running"
+	ObjectStatusDone        = "done"
+	ObjectStatusFailed      = "failed"
+	ObjectStatusInterrupted = "interrupted"
+)
+
 type ObjectInfo struct {
 	UID           primitive.ObjectID `bson:"_id,omitempty"`
+	BucketName    string             `bson:"bucket_name"`
+	ObjectKey     string             `bson:"object_key"`
 	ContentLength int64              `bson:"content_length"`
 	ContentType   string             `bson:"content_type"`
 	ETag          string             `bson:"etag"`
+	Status        string             `bson:"status,omitempty"`
+	Report        *Report            `bson:"report,omitempty"`
 }
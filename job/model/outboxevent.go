@@ -0,0 +1,28 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// EventType is the type of a product change event.
+type EventType string
+
+const (
+	EventProductUpserted EventType = "product.upserted"
+	EventProductDeleted  EventType = "product.deleted"
+)
+
+// OutboxEvent is a product change event written to the outbox in the same transaction as the change.
+// The ID of the event is unique, so consumers can drop the events that are delivered more than once.
+type OutboxEvent struct {
+	UID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type      EventType          `bson:"type" json:"type"`
+	ProductID int                `bson:"product_id" json:"product_id"`
+	// Product holds the written fields of an upserted product. It is empty for a deleted product.
+	Product     *Product   `bson:"product,omitempty" json:"product,omitempty"`
+	Ts          int64      `bson:"ts,omitempty" json:"ts,omitempty"`
+	Source      string     `bson:"source,omitempty" json:"source,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	PublishedAt *time.Time `bson:"published_at,omitempty" json:"-"`
+}
@@ -1,9 +1,23 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Operation is the operation of a CDC formatted line.
+type Operation string
+
+const (
+	OpUpsert Operation = "upsert"
+	OpDelete Operation = "delete"
+)
 
 type Product struct {
-	UID         primitive.ObjectID `bson:"_id,omitempty"`
+	UID         primitive.ObjectID `bson:"_id,omitempty" json:"-"`
 	ID          int                `bson:"id" `
 	Title       string             `bson:"title"`
 	Price       float64            `bson:"price"`
@@ -11,4 +25,50 @@ type Product struct {
 	Brand       string             `bson:"brand" `
 	Url         string             `bson:"url" `
 	Description string             `bson:"description"`
+	Hash        string             `bson:"hash,omitempty" json:"-"`
+	// Op and Ts are read from the operation lines of CDC formatted objects.
+	// Ts is stored to ignore the events that are older than the stored product.
+	Op        Operation  `bson:"-" json:"op"`
+	Ts        int64      `bson:"ts,omitempty" json:"ts"`
+	Source    string     `bson:"source,omitempty" json:"-"`
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"-"`
+}
+
+// ContentHash returns the SHA-256 hash of the product data fields.
+// Database and operation fields like UID, Hash, Op, Ts, Source and DeletedAt are not part of the hash.
+func (p Product) ContentHash() string {
+	content, _ := json.Marshal(struct {
+		ID          int
+		Title       string
+		Price       float64
+		Category    string
+		Brand       string
+		Url         string
+		Description string
+	}{p.ID, p.Title, p.Price, p.Category, p.Brand, p.Url, p.Description})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Merge returns a copy of the product with the non-empty data fields of other applied.
+func (p Product) Merge(other Product) Product {
+	if other.Title != "" {
+		p.Title = other.Title
+	}
+	if other.Price != 0 {
+		p.Price = other.Price
+	}
+	if other.Category != "" {
+		p.Category = other.Category
+	}
+	if other.Brand != "" {
+		p.Brand = other.Brand
+	}
+	if other.Url != "" {
+		p.Url = other.Url
+	}
+	if other.Description != "" {
+		p.Description = other.Description
+	}
+	return p
 }
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// ProductHistory is a previous version of a product. It is archived when the product is changed by an S3 object.
+type ProductHistory struct {
+	UID          primitive.ObjectID `bson:"_id,omitempty"`
+	ProductID    int                `bson:"product_id"`
+	Product      Product            `bson:"product"`
+	SourceBucket string             `bson:"source_bucket"`
+	SourceObject string             `bson:"source_object"`
+	ArchivedAt   time.Time          `bson:"archived_at"`
+}
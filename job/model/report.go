@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Report is the ingestion report of a single S3 object run.
+// It is stored alongside the object info record of the object.
+type Report struct {
+	LinesRead         int64                 `bson:"lines_read" json:"lines_read"`
+	BytesRead         int64                 `bson:"bytes_read" json:"bytes_read"`
+	Parsed            int64                 `bson:"parsed" json:"parsed"`
+	Rejected          int64                 `bson:"rejected" json:"rejected"`
+	Filtered          int64                 `bson:"filtered" json:"filtered"`
+	Unmatched         int64                 `bson:"unmatched" json:"unmatched"`
+	Deduplicated      int64                 `bson:"deduplicated" json:"deduplicated"`
+	Inserted          int64                 `bson:"inserted" json:"inserted"`
+	Updated           int64                 `bson:"updated" json:"updated"`
+	Unchanged         int64                 `bson:"unchanged" json:"unchanged"`
+	DuplicatesSkipped int64                 `bson:"duplicates_skipped" json:"duplicates_skipped"`
+	HistoryAppended   int64                 `bson:"history_appended" json:"history_appended"`
+	Deleted           int64                 `bson:"deleted" json:"deleted"`
+	StaleSkipped      int64                 `bson:"stale_skipped" json:"stale_skipped"`
+	WriteErrors       int64                 `bson:"write_errors" json:"write_errors"`
+	WriteRetries      int64                 `bson:"write_retries" json:"write_retries"`
+	DeadLettered      int64                 `bson:"dead_lettered" json:"dead_lettered"`
+	FirstLineAt       time.Time             `bson:"first_line_at,omitempty" json:"first_line_at,omitempty"`
+	LastLineAt        time.Time             `bson:"last_line_at,omitempty" json:"last_line_at,omitempty"`
+	StageDurations    map[string]int64      `bson:"stage_durations_ms" json:"stage_durations_ms"`
+	Sinks             map[string]SinkReport `bson:"sinks,omitempty" json:"sinks,omitempty"`
+	StartedAt         time.Time             `bson:"started_at" json:"started_at"`
+	FinishedAt        time.Time             `bson:"finished_at" json:"finished_at"`
+	// DryRun reports that the counters of the written products are the products a real run would write.
+	DryRun bool `bson:"dry_run,omitempty" json:"dry_run,omitempty"`
+}
+
+// SinkReport is the outcome of a secondary sink in a single run.
+type SinkReport struct {
+	Written int64 `bson:"written" json:"written"`
+	Failed  int64 `bson:"failed" json:"failed"`
+	Dropped int64 `bson:"dropped" json:"dropped"`
+	Retries int64 `bson:"retries" json:"retries"`
+}
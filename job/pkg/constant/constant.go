@@ -16,4 +16,30 @@ var (
 	ErrCreateIndexFailed = "failed to create index"
 	ErrCreateObjectInfo  = "failed to create object file. already exists"
 	ErrCreateProduct     = "failed to create product"
+	ErrUpdateObjectInfo  = "failed to update object info"
+	ErrReplaceProduct    = "failed to replace product"
+	ErrMergeProduct      = "failed to merge product"
+	ErrFindProducts      = "failed to find products"
+	ErrCreateHistory     = "failed to create product history"
+	ErrDeleteProducts    = "failed to delete products"
+	ErrSnapshotLimit     = "snapshot delete limit exceeded"
+	ErrStaleEvent        = "newer event already stored"
+	ErrInvalidOperation  = "invalid operation"
+	ErrCreateStaging     = "failed to create staging collection"
+	ErrPromoteStaging    = "failed to promote staging collection"
+	ErrDropStaging       = "failed to drop staging collection"
+	ErrOpenSink          = "failed to open sink"
+	ErrWriteSink         = "failed to write to sink"
+	ErrFindEvents        = "failed to find outbox events"
+	ErrMarkEvents        = "failed to mark outbox events as published"
+	ErrPublishEvents     = "failed to publish outbox events"
+	ErrCreateDeadLetters = "failed to create dead letters"
+	ErrWaitThrottle      = "failed to wait for the write throttle"
+	ErrFindMigrations    = "failed to find applied migrations"
+	ErrApplyMigration    = "failed to apply migration"
+	ErrTransformProduct  = "failed to transform product"
+	ErrFilterProduct     = "failed to filter product"
+	ErrJoinObject        = "failed to join object"
+	ErrDedupProducts     = "failed to deduplicate products"
+	ErrObjectLoaded      = "object is already loaded"
 )
@@ -0,0 +1,61 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+)
+
+// env is the environment of a filter expression. The fields are named like the fields of the stored product.
+type env struct {
+	ID          int     `expr:"id"`
+	Title       string  `expr:"title"`
+	Price       float64 `expr:"price"`
+	Category    string  `expr:"category"`
+	Brand       string  `expr:"brand"`
+	Url         string  `expr:"url"`
+	Description string  `expr:"description"`
+	Source      string  `expr:"source"`
+	Ts          int64   `expr:"ts"`
+}
+
+// Filter is a compiled filter expression. A Filter is safe for concurrent use.
+type Filter struct {
+	expression string
+	program    *vm.Program
+}
+
+// Compile compiles a boolean filter expression over the product fields, e.g.
+// `category == "electronics" && price > 0`. An expression with an unknown field or a non-boolean result fails to compile.
+func Compile(expression string) (*Filter, error) {
+	program, err := expr.Compile(expression, expr.Env(env{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("filter: compile %q: %w", expression, err)
+	}
+	return &Filter{expression: expression, program: program}, nil
+}
+
+// String returns the expression of the filter.
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// Match reports whether the product matches the filter expression.
+func (f *Filter) Match(product model.Product) (bool, error) {
+	out, err := expr.Run(f.program, env{
+		ID:          product.ID,
+		Title:       product.Title,
+		Price:       product.Price,
+		Category:    product.Category,
+		Brand:       product.Brand,
+		Url:         product.Url,
+		Description: product.Description,
+		Source:      product.Source,
+		Ts:          product.Ts,
+	})
+	if err != nil {
+		return false, fmt.Errorf("filter: run %q: %w", f.expression, err)
+	}
+	return out.(bool), nil
+}
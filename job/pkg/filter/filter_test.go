@@ -0,0 +1,75 @@
+package filter_test
+
+import (
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/filter"
+	"testing"
+)
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		product    model.Product
+		want       bool
+	}{
+		{
+			name:       "match",
+			expression: `category == "electronics" && price > 0`,
+			product:    model.Product{ID: 1, Category: "electronics", Price: 10},
+			want:       true,
+		},
+		{
+			name:       "no match",
+			expression: `category == "electronics" && price > 0`,
+			product:    model.Product{ID: 2, Category: "electronics"},
+			want:       false,
+		},
+		{
+			name:       "string functions",
+			expression: `title startsWith "TEST-" || brand in ["acme", "globex"]`,
+			product:    model.Product{ID: 3, Title: "Phone", Brand: "acme"},
+			want:       true,
+		},
+		{
+			name:       "source",
+			expression: `source == "vendor" and id % 2 == 0`,
+			product:    model.Product{ID: 4, Source: "vendor"},
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := filter.Compile(tt.expression)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, err := f.Match(tt.product)
+			if err != nil {
+				t.Fatalf("Match() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "syntax error", expression: `category ==`},
+		{name: "unknown field", expression: `colour == "red"`},
+		{name: "not boolean", expression: `price * 2`},
+		{name: "type mismatch", expression: `price == "free"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := filter.Compile(tt.expression); err == nil {
+				t.Errorf("Compile() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package locals3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// Client serves the files of a local directory like S3. Each subdirectory of the root is a bucket and
+// the path of a file in the bucket is its object key. It lets the job load fixture files without AWS.
+type Client struct {
+	root string
+}
+
+func New(root string) *Client {
+	return &Client{root: root}
+}
+
+// HeadBucket method returns an error if the bucket directory does not exist.
+func (c *Client) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	info, err := os.Stat(c.path(aws.ToString(params.Bucket), ""))
+	if err != nil {
+		return nil, fmt.Errorf("locals3: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, errors.New("locals3: " + aws.ToString(params.Bucket) + " is not a directory")
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+// HeadObject method returns an error if the object file does not exist.
+func (c *Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	info, err := os.Stat(c.path(aws.ToString(params.Bucket), aws.ToString(params.Key)))
+	if err != nil {
+		return nil, fmt.Errorf("locals3: %w", err)
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(info.Size())}, nil
+}
+
+// GetObject method opens the object file. The ETag is the quoted MD5 hash of the file like the ETag of a single part upload.
+func (c *Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	name := c.path(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("locals3: %w", err)
+	}
+	hash := md5.New()
+	size, err := io.Copy(hash, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("locals3: %w", err)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &s3.GetObjectOutput{
+		Body:          file,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+		ETag:          aws.String(`"` + hex.EncodeToString(hash.Sum(nil)) + `"`),
+	}, nil
+}
+
+func (c *Client) path(bucket, key string) string {
+	return filepath.Join(c.root, bucket, filepath.FromSlash(key))
+}
@@ -0,0 +1,44 @@
+package locals3_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/locals3"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "bucket", "feeds"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "bucket", "feeds", "products.jsonl"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := locals3.New(root)
+	ctx := context.TODO()
+
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("bucket")})
+	assert.Nil(t, err)
+	_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("missing")})
+	assert.NotNil(t, err)
+
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("feeds/missing.jsonl")})
+	assert.NotNil(t, err)
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("feeds/products.jsonl")})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer out.Body.Close()
+	body, err := io.ReadAll(out.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, int64(5), aws.ToInt64(out.ContentLength))
+	assert.Equal(t, `"5d41402abc4b2a76b9719d911017c592"`, aws.ToString(out.ETag))
+}
@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// ConnectPostgres connects to the URI of the database config. If the URI is not set, it is built from the host, port,
+// user, password, name and SSL mode.
+func ConnectPostgres(dbConfig config.Database) (*sql.DB, error) {
+	connString := dbConfig.URI
+	if connString == "" {
+		connString = connectionString(dbConfig)
+	}
+	db, err := sql.Open("pgx", connString)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	log.Println("Connected to PostgreSQL")
+	return db, nil
+}
+
+// connectionString builds the URI of the database config. The user and password are escaped.
+func connectionString(dbConfig config.Database) string {
+	connURL := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(dbConfig.Host, dbConfig.Port),
+		Path:     "/" + dbConfig.Name,
+		RawQuery: url.Values{"sslmode": []string{dbConfig.SSLMode}}.Encode(),
+	}
+	if dbConfig.User != "" || dbConfig.Pass != "" {
+		connURL.User = url.UserPassword(dbConfig.User, dbConfig.Pass)
+	}
+	return connURL.String()
+}
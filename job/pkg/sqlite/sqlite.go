@@ -0,0 +1,33 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"log"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"time"
+)
+
+// ConnectSQLite opens the SQLite database file of the database config. The file is created if it does not exist.
+// SQLite allows a single writer, so the pool is limited to one connection and the writers of the job wait for each other.
+func ConnectSQLite(dbConfig config.Database) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	db, err := sql.Open("sqlite", "file:"+dbConfig.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	log.Println("Connected to SQLite")
+	return db, nil
+}
@@ -0,0 +1,121 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"math"
+	"strings"
+)
+
+// Names of the built-in transformers.
+const (
+	NameCurrency    = "currency"
+	NameCategoryMap = "category_map"
+	NameDrop        = "drop"
+)
+
+func init() {
+	Register(NameCurrency, newCurrency)
+	Register(NameCategoryMap, newCategoryMap)
+	Register(NameDrop, newDrop)
+}
+
+// currency converts the price with the exchange rate and rounds it to the given decimals.
+type currency struct {
+	Rate     float64
+	Decimals int
+}
+
+func newCurrency(params map[string]any) (Transformer, error) {
+	c := currency{Decimals: 2}
+	if err := decodeParams(params, &c); err != nil {
+		return nil, err
+	}
+	if c.Rate <= 0 {
+		return nil, errors.New("Rate must be positive")
+	}
+	if c.Decimals < 0 {
+		return nil, errors.New("Decimals must not be negative")
+	}
+	return c, nil
+}
+
+func (c currency) Transform(ctx context.Context, product model.Product) ([]model.Product, error) {
+	scale := math.Pow(10, float64(c.Decimals))
+	product.Price = math.Round(product.Price*c.Rate*scale) / scale
+	return []model.Product{product}, nil
+}
+
+// categoryMap renames the categories of the mapping. A category that is not mapped is kept, or rejected if Strict is set.
+// The mapping is a list, since the keys of a map in s3-objects.yml are lowercased.
+type categoryMap struct {
+	Mapping []struct {
+		From string
+		To   string
+	}
+	Strict bool
+	lookup map[string]string
+}
+
+func newCategoryMap(params map[string]any) (Transformer, error) {
+	var c categoryMap
+	if err := decodeParams(params, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Mapping) == 0 {
+		return nil, errors.New("Mapping must not be empty")
+	}
+	c.lookup = make(map[string]string, len(c.Mapping))
+	for _, m := range c.Mapping {
+		c.lookup[m.From] = m.To
+	}
+	return c, nil
+}
+
+func (c categoryMap) Transform(ctx context.Context, product model.Product) ([]model.Product, error) {
+	to, ok := c.lookup[product.Category]
+	if !ok {
+		if c.Strict {
+			return nil, fmt.Errorf("category %q of product %d is not mapped", product.Category, product.ID)
+		}
+		return []model.Product{product}, nil
+	}
+	product.Category = to
+	return []model.Product{product}, nil
+}
+
+// drop drops the products with one of the IDs or a title with one of the prefixes, like the test SKUs of a feed.
+type drop struct {
+	IDs           []int
+	TitlePrefixes []string
+	ids           map[int]struct{}
+}
+
+func newDrop(params map[string]any) (Transformer, error) {
+	var d drop
+	if err := decodeParams(params, &d); err != nil {
+		return nil, err
+	}
+	if len(d.IDs) == 0 && len(d.TitlePrefixes) == 0 {
+		return nil, errors.New("IDs or TitlePrefixes must be set")
+	}
+	d.ids = make(map[int]struct{}, len(d.IDs))
+	for _, id := range d.IDs {
+		d.ids[id] = struct{}{}
+	}
+	return d, nil
+}
+
+func (d drop) Transform(ctx context.Context, product model.Product) ([]model.Product, error) {
+	if _, ok := d.ids[product.ID]; ok {
+		return nil, nil
+	}
+	for _, prefix := range d.TitlePrefixes {
+		if strings.HasPrefix(product.Title, prefix) {
+			return nil, nil
+		}
+	}
+	return []model.Product{product}, nil
+}
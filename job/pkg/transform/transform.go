@@ -0,0 +1,122 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"sort"
+	"sync"
+)
+
+// Transformer transforms a decoded product before it is written. It returns no product to drop the product
+// and more than one product to split it. An error rejects the product.
+// A transformer is called concurrently by the parser workers, so it must be safe for concurrent use.
+type Transformer interface {
+	Transform(ctx context.Context, product model.Product) ([]model.Product, error)
+}
+
+// Func is a function that is a Transformer.
+type Func func(ctx context.Context, product model.Product) ([]model.Product, error)
+
+func (f Func) Transform(ctx context.Context, product model.Product) ([]model.Product, error) {
+	return f(ctx, product)
+}
+
+// Factory creates a transformer from the params of its configuration.
+type Factory func(params map[string]any) (Transformer, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a transformer available by name. It panics if the name is registered twice or the factory is nil,
+// like database/sql drivers. Register is usually called from the init function of the package of the transformer.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("transform: Register factory is nil for " + name)
+	}
+	if _, ok := factories[name]; ok {
+		panic("transform: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Names returns the sorted names of the registered transformers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the transformer registered by name with the params.
+func New(name string, params map[string]any) (Transformer, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("transform: unknown transformer %q, registered: %v", name, Names())
+	}
+	t, err := factory(params)
+	if err != nil {
+		return nil, fmt.Errorf("transform: failed to create %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Chain applies the transformers in order. Each product returned by a transformer is passed to the next one.
+type Chain []Transformer
+
+func (c Chain) Transform(ctx context.Context, product model.Product) ([]model.Product, error) {
+	products := []model.Product{product}
+	for _, t := range c {
+		var next []model.Product
+		for _, p := range products {
+			out, err := t.Transform(ctx, p)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		if len(next) == 0 {
+			return nil, nil
+		}
+		products = next
+	}
+	return products, nil
+}
+
+// decodeParams decodes the params of a transformer configuration into out. Unknown params are an error.
+func decodeParams(params map[string]any, out any) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(params)
+}
+
+// NewChain creates the chain of the transformer configurations of an S3 object.
+func NewChain(configs []config.Transformer) (Chain, error) {
+	chain := make(Chain, 0, len(configs))
+	for _, cfg := range configs {
+		t, err := New(cfg.Name, cfg.Params)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, t)
+	}
+	return chain, nil
+}
@@ -0,0 +1,110 @@
+package transform_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/config"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/model"
+	"github.com/yigithankarabulut/asyncs3todbloader/job/pkg/transform"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	transform.Register("test_split", func(params map[string]any) (transform.Transformer, error) {
+		return transform.Func(func(ctx context.Context, product model.Product) ([]model.Product, error) {
+			variant := product
+			variant.ID += 1000
+			return []model.Product{product, variant}, nil
+		}), nil
+	})
+	if !slices.Contains(transform.Names(), "test_split") {
+		t.Errorf("Names() = %v, want test_split", transform.Names())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() twice did not panic")
+		}
+	}()
+	transform.Register("test_split", nil)
+}
+
+func TestNewChain(t *testing.T) {
+	chain, err := transform.NewChain([]config.Transformer{
+		{Name: transform.NameDrop, Params: map[string]any{"titleprefixes": []any{"TEST-"}, "ids": []any{3}}},
+		{Name: transform.NameCategoryMap, Params: map[string]any{"mapping": []any{map[string]any{"from": "Phones", "to": "Mobile"}}}},
+		{Name: transform.NameCurrency, Params: map[string]any{"rate": "0.5"}},
+	})
+	if err != nil {
+		t.Fatalf("NewChain() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		product model.Product
+		want    []model.Product
+	}{
+		{
+			name:    "transformed",
+			product: model.Product{ID: 1, Title: "Phone", Category: "Phones", Price: 10.25},
+			want:    []model.Product{{ID: 1, Title: "Phone", Category: "Mobile", Price: 5.13}},
+		},
+		{
+			name:    "unmapped category is kept",
+			product: model.Product{ID: 2, Title: "Laptop", Category: "Computers", Price: 100},
+			want:    []model.Product{{ID: 2, Title: "Laptop", Category: "Computers", Price: 50}},
+		},
+		{name: "dropped by id", product: model.Product{ID: 3, Title: "Phone"}},
+		{name: "dropped by title", product: model.Product{ID: 4, Title: "TEST-Phone"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chain.Transform(context.Background(), tt.product)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Transform() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChain_Error(t *testing.T) {
+	errTransform := errors.New("transform error")
+	calls := 0
+	chain := transform.Chain{
+		transform.Func(func(ctx context.Context, product model.Product) ([]model.Product, error) {
+			return nil, errTransform
+		}),
+		transform.Func(func(ctx context.Context, product model.Product) ([]model.Product, error) {
+			calls++
+			return []model.Product{product}, nil
+		}),
+	}
+	if _, err := chain.Transform(context.Background(), model.Product{ID: 1}); !errors.Is(err, errTransform) || calls != 0 {
+		t.Errorf("Transform() = %v with %d calls of the next transformer, want %v with 0 calls", err, calls, errTransform)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		transform  string
+		params     map[string]any
+		wantErrMsg string
+	}{
+		{name: "unknown transformer", transform: "unknown"},
+		{name: "unknown param", transform: transform.NameCurrency, params: map[string]any{"rate": 1, "ratio": 2}},
+		{name: "invalid rate", transform: transform.NameCurrency, params: map[string]any{"rate": 0}},
+		{name: "empty mapping", transform: transform.NameCategoryMap},
+		{name: "nothing to drop", transform: transform.NameDrop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := transform.New(tt.transform, tt.params); err == nil {
+				t.Errorf("New() error = nil, want error")
+			}
+		})
+	}
+}
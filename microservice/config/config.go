@@ -3,7 +3,10 @@ package config
 import (
 	"errors"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config struct stores the configuration of the application
@@ -14,12 +17,38 @@ type Config struct {
 
 // Database struct stores the configuration of the database
 type Database struct {
+	// URI is the connection string of the database. If it is set, the host, port, user and password are not used.
+	URI        string          `mapstructure:"uri"`
 	Name       string          `mapstructure:"name"`
 	Host       string          `mapstructure:"host"`
 	Pass       string          `mapstructure:"pass"`
 	User       string          `mapstructure:"user"`
 	Port       string          `mapstructure:"port"`
 	Collection MongoCollection `mapstructure:"collection"`
+	Mongo      MongoOptions    `mapstructure:"mongo"`
+}
+
+// MongoOptions struct stores the client options of the database. They override the options of the connection string.
+// A zero value keeps the option of the connection string or the default of the driver.
+type MongoOptions struct {
+	AuthSource string `mapstructure:"auth_source"`
+	ReplicaSet string `mapstructure:"replica_set"`
+	TLS        bool   `mapstructure:"tls"`
+	// TLSCAFile is the PEM file of the certificate authorities that the server certificate is verified with.
+	TLSCAFile string `mapstructure:"tls_ca_file"`
+	// TLSCertificateKeyFile is the PEM file of the client certificate and its key.
+	// Without a user, the client authenticates with the certificate (MONGODB-X509).
+	TLSCertificateKeyFile  string        `mapstructure:"tls_certificate_key_file"`
+	MaxPoolSize            uint64        `mapstructure:"max_pool_size"`
+	MinPoolSize            uint64        `mapstructure:"min_pool_size"`
+	ConnectTimeout         time.Duration `mapstructure:"connect_timeout"`
+	ServerSelectionTimeout time.Duration `mapstructure:"server_selection_timeout"`
+	// Timeout is the timeout of each operation.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// WriteConcern is majority, a number of members or a tag set name.
+	WriteConcern string `mapstructure:"write_concern"`
+	// ReadPreference is one of primary, primaryPreferred, secondary, secondaryPreferred, nearest.
+	ReadPreference string `mapstructure:"read_preference"`
 }
 
 // MongoCollection struct stores the configuration of the MongoDB collection
@@ -35,7 +64,16 @@ func (c *Config) LoadDatabase() error {
 	c.Database.User = os.Getenv("DB_USER")
 	c.Database.Port = os.Getenv("DB_PORT")
 	c.Database.Collection.Name = os.Getenv("DB_PRODUCT_COLLECTION")
-	for _, env := range []string{"DB_NAME", "DB_HOST", "DB_PASS", "DB_USER", "DB_PORT", "DB_PRODUCT_COLLECTION"} {
+	c.Database.URI = os.Getenv("DB_URI")
+	if err := c.Database.Mongo.load(); err != nil {
+		return err
+	}
+	required := []string{"DB_NAME", "DB_HOST", "DB_PASS", "DB_USER", "DB_PORT", "DB_PRODUCT_COLLECTION"}
+	if c.Database.URI != "" {
+		// The database name can be the default database of the connection string.
+		required = []string{"DB_PRODUCT_COLLECTION"}
+	}
+	for _, env := range required {
 		if os.Getenv(env) == "" {
 			return errors.New(env + " is required")
 		}
@@ -43,6 +81,59 @@ func (c *Config) LoadDatabase() error {
 	return nil
 }
 
+// readPreferences are the read preferences of the database
+var readPreferences = []string{"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"}
+
+// load loads the client options of the database from the environment variables
+func (m *MongoOptions) load() error {
+	m.AuthSource = os.Getenv("DB_AUTH_SOURCE")
+	m.ReplicaSet = os.Getenv("DB_REPLICA_SET")
+	m.TLSCAFile = os.Getenv("DB_TLS_CA_FILE")
+	m.TLSCertificateKeyFile = os.Getenv("DB_TLS_CERT_KEY_FILE")
+	m.WriteConcern = os.Getenv("DB_WRITE_CONCERN")
+	m.ReadPreference = os.Getenv("DB_READ_PREFERENCE")
+	if tls := os.Getenv("DB_TLS"); tls != "" {
+		b, err := strconv.ParseBool(tls)
+		if err != nil {
+			return errors.New("DB_TLS must be a boolean")
+		}
+		m.TLS = b
+	}
+	m.TLS = m.TLS || m.TLSCAFile != "" || m.TLSCertificateKeyFile != ""
+	for env, value := range map[string]*uint64{
+		"DB_MAX_POOL_SIZE": &m.MaxPoolSize,
+		"DB_MIN_POOL_SIZE": &m.MinPoolSize,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return errors.New(env + " must be a non-negative number")
+			}
+			*value = n
+		}
+	}
+	for env, value := range map[string]*time.Duration{
+		"DB_CONNECT_TIMEOUT":          &m.ConnectTimeout,
+		"DB_SERVER_SELECTION_TIMEOUT": &m.ServerSelectionTimeout,
+		"DB_TIMEOUT":                  &m.Timeout,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return errors.New(env + " must be a positive duration")
+			}
+			*value = d
+		}
+	}
+	if m.MaxPoolSize > 0 && m.MinPoolSize > m.MaxPoolSize {
+		return errors.New("DB_MIN_POOL_SIZE must not be greater than DB_MAX_POOL_SIZE")
+	}
+	if m.ReadPreference != "" && !slices.Contains(readPreferences, m.ReadPreference) {
+		return errors.New("DB_READ_PREFERENCE must be one of " + strings.Join(readPreferences, ", "))
+	}
+	return nil
+}
+
 // LoadConfig loads the configuration from the environment variables
 func LoadConfig() (*Config, error) {
 	var Config Config
@@ -9,9 +9,11 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// GetProductByID returns the product with the given id. Products deleted by the job are not returned.
 func (r *productRepository) GetProductByID(ctx context.Context, id int) (model.Product, error) {
 	var product model.Product
-	if err := r.db.Collection(r.productCollectionName).FindOne(ctx, bson.M{"id": id}).Decode(&product); err != nil {
+	filter := bson.M{"id": id, "deleted_at": bson.M{"$exists": false}}
+	if err := r.db.Collection(r.productCollectionName).FindOne(ctx, filter).Decode(&product); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return product, fmt.Errorf("%w", err)
 		}
@@ -2,23 +2,36 @@ package mongo
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"github.com/yigithankarabulut/asyncs3todbloader/microservice/config"
-
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
 	"time"
 )
 
 var DB *mongo.Database
 
+// authMechanismX509 authenticates the client with its TLS certificate.
+const authMechanismX509 = "MONGODB-X509"
+
 func Connect(dbConfig config.Database) error {
-	var connString string
-	if dbConfig.User == "" && dbConfig.Pass == "" {
-		connString = "mongodb://" + dbConfig.Host + ":" + dbConfig.Port
-	} else {
-		connString = "mongodb://" + dbConfig.User + ":" + dbConfig.Pass + "@" + dbConfig.Host + ":" + dbConfig.Port
+	clientOptions, err := ClientOptions(dbConfig)
+	if err != nil {
+		return err
+	}
+	name, err := DatabaseName(dbConfig)
+	if err != nil {
+		return err
 	}
-	clientOptions := options.Client().ApplyURI(connString)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -30,7 +43,127 @@ func Connect(dbConfig config.Database) error {
 	if err != nil {
 		return err
 	}
-	db := client.Database(dbConfig.Name)
+	db := client.Database(name)
 	DB = db
 	return nil
 }
+
+// ConnectionString returns the URI of the database config. If the URI is not set, it is built from the host, port,
+// user and password. The user and password are escaped, so they can contain any character.
+func ConnectionString(dbConfig config.Database) string {
+	if dbConfig.URI != "" {
+		return dbConfig.URI
+	}
+	connURL := url.URL{
+		Scheme: "mongodb",
+		Host:   net.JoinHostPort(dbConfig.Host, dbConfig.Port),
+		Path:   "/",
+	}
+	if dbConfig.User != "" || dbConfig.Pass != "" {
+		connURL.User = url.UserPassword(dbConfig.User, dbConfig.Pass)
+	}
+	return connURL.String()
+}
+
+// DatabaseName returns the name of the database config. If the name is not set, it is the default database of the URI.
+func DatabaseName(dbConfig config.Database) (string, error) {
+	if dbConfig.Name != "" {
+		return dbConfig.Name, nil
+	}
+	cs, err := connstring.Parse(ConnectionString(dbConfig))
+	if err != nil {
+		return "", err
+	}
+	if cs.Database == "" {
+		return "", errors.New("DB_NAME is required if DB_URI has no database")
+	}
+	return cs.Database, nil
+}
+
+// ClientOptions returns the client options of the connection string and the mongo options of the database config.
+// The mongo options override the options of the connection string.
+func ClientOptions(dbConfig config.Database) (*options.ClientOptions, error) {
+	opts := dbConfig.Mongo
+	clientOptions := options.Client().ApplyURI(ConnectionString(dbConfig))
+	if err := clientOptions.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.AuthSource != "" && clientOptions.Auth != nil {
+		clientOptions.Auth.AuthSource = opts.AuthSource
+	}
+	if opts.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(opts.ReplicaSet)
+	}
+	if opts.TLS {
+		tlsConfig, err := newTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+		if opts.TLSCertificateKeyFile != "" && clientOptions.Auth == nil {
+			clientOptions.SetAuth(options.Credential{AuthMechanism: authMechanismX509, AuthSource: "$external"})
+		}
+	}
+	if opts.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(opts.MaxPoolSize)
+	}
+	if opts.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(opts.MinPoolSize)
+	}
+	if opts.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(opts.ConnectTimeout)
+	}
+	if opts.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(opts.ServerSelectionTimeout)
+	}
+	if opts.Timeout > 0 {
+		clientOptions.SetTimeout(opts.Timeout)
+	}
+	if opts.WriteConcern != "" {
+		clientOptions.SetWriteConcern(newWriteConcern(opts.WriteConcern))
+	}
+	if opts.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(opts.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		pref, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(pref)
+	}
+	return clientOptions, clientOptions.Validate()
+}
+
+// newTLSConfig returns the TLS config of the mongo options with the certificate authorities and the client certificate.
+func newTLSConfig(opts config.MongoOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.TLSCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + opts.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.TLSCertificateKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertificateKeyFile, opts.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newWriteConcern returns the write concern of majority, a number of members or a tag set name.
+func newWriteConcern(w string) *writeconcern.WriteConcern {
+	if n, err := strconv.Atoi(w); err == nil {
+		return &writeconcern.WriteConcern{W: n}
+	}
+	return &writeconcern.WriteConcern{W: w}
+}